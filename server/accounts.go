@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"regexp"
+	"sort"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+const (
+	// defaultAccountName is the Google Play connection used when none is stated. It is stored
+	// on the key used before the named connections, so existing connections keep working.
+	defaultAccountName = "default"
+	// GooglePlayAccountsKey denotes the key on the KVStore for the named Google Play connections of a user
+	GooglePlayAccountsKey = "_googleplayaccounts"
+	// maxAccountsUpdateRetries bounds the retries when several requests change the connections at once
+	maxAccountsUpdateRetries = 5
+)
+
+var accountNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,32}$`)
+
+func getAccountName(accountName string) string {
+	if accountName == "" {
+		return defaultAccountName
+	}
+	return accountName
+}
+
+func isValidAccountName(accountName string) bool {
+	return accountNameRegexp.MatchString(accountName)
+}
+
+// getTokenKey returns the key of the token of the connection. The named connections hash the user and the
+// account name, as they do not fit on the KV key, keeping GooglePlayTokenKey on it to be found on key rotations.
+func getTokenKey(userID string, accountName string) string {
+	if getAccountName(accountName) == defaultAccountName {
+		return userID + GooglePlayTokenKey
+	}
+	return getHashedKey(GooglePlayTokenKey+"_", userID+"/"+accountName)
+}
+
+// getGooglePlayAccounts returns the names of the Google Play connections of the user, sorted
+func (p *Plugin) getGooglePlayAccounts(userID string) ([]string, error) {
+	accounts, _, err := p.getNamedAccounts(userID + GooglePlayAccountsKey)
+	if err != nil {
+		return nil, err
+	}
+
+	defaultToken, appErr := p.API.KVGet(getTokenKey(userID, defaultAccountName))
+	if appErr != nil {
+		return nil, appErr
+	}
+	if defaultToken != nil {
+		accounts = append(accounts, defaultAccountName)
+	}
+
+	sort.Strings(accounts)
+	return accounts, nil
+}
+
+// getNamedAccounts returns the named connections stored on the index key, and the raw value to update them atomically
+func (p *Plugin) getNamedAccounts(indexKey string) ([]string, []byte, error) {
+	b, appErr := p.API.KVGet(indexKey)
+	if appErr != nil {
+		return nil, nil, appErr
+	}
+
+	accounts := []string{}
+	if b == nil {
+		return accounts, nil, nil
+	}
+	if err := json.Unmarshal(b, &accounts); err != nil {
+		return nil, nil, err
+	}
+	return accounts, b, nil
+}
+
+// updateNamedAccounts applies f to the named connections stored on the index key, retrying if they changed meanwhile
+func (p *Plugin) updateNamedAccounts(indexKey string, f func(accounts []string) []string) error {
+	for i := 0; i < maxAccountsUpdateRetries; i++ {
+		accounts, old, err := p.getNamedAccounts(indexKey)
+		if err != nil {
+			return err
+		}
+
+		b, err := json.Marshal(f(accounts))
+		if err != nil {
+			return err
+		}
+
+		var ok bool
+		var appErr *model.AppError
+		if old == nil {
+			ok, appErr = p.API.KVSetWithOptions(indexKey, b, model.PluginKVSetOptions{Atomic: true, OldValue: nil})
+		} else {
+			ok, appErr = p.API.KVCompareAndSet(indexKey, old, b)
+		}
+		if appErr != nil {
+			return appErr
+		}
+		if ok {
+			return nil
+		}
+	}
+	return errors.New("too many concurrent changes on the connections")
+}
+
+func (p *Plugin) addNamedAccount(indexKey string, accountName string) error {
+	return p.updateNamedAccounts(indexKey, func(accounts []string) []string {
+		for _, account := range accounts {
+			if account == accountName {
+				return accounts
+			}
+		}
+		return append(accounts, accountName)
+	})
+}
+
+func (p *Plugin) removeNamedAccount(indexKey string, accountName string) error {
+	return p.updateNamedAccounts(indexKey, func(accounts []string) []string {
+		result := []string{}
+		for _, account := range accounts {
+			if account != accountName {
+				result = append(result, account)
+			}
+		}
+		return result
+	})
+}
+
+func (p *Plugin) addGooglePlayAccount(userID string, accountName string) error {
+	if getAccountName(accountName) == defaultAccountName {
+		return nil
+	}
+	return p.addNamedAccount(userID+GooglePlayAccountsKey, accountName)
+}
+
+// removeGooglePlayAccount deletes the token of the connection and forgets it
+func (p *Plugin) removeGooglePlayAccount(userID string, accountName string) error {
+	if appErr := p.API.KVDelete(getTokenKey(userID, accountName)); appErr != nil {
+		return appErr
+	}
+	if getAccountName(accountName) == defaultAccountName {
+		return nil
+	}
+	return p.removeNamedAccount(userID+GooglePlayAccountsKey, accountName)
+}
+
+// getAppStoreAccounts returns the names of the App Store connections of the user, sorted
+func (p *Plugin) getAppStoreAccounts(userID string) ([]string, error) {
+	accounts, _, err := p.getNamedAccounts(userID + AppStoreAccountsKey)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(accounts)
+	return accounts, nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestStoreGooglePlayTokenOfLongAccountName(t *testing.T) {
+	p := newTestPlugin()
+	newTestKVStore(p.API.(*plugintest.API))
+	p.configuration.EncryptionKey = testKeyA
+
+	userID := model.NewId()
+	accountName := strings.Repeat("a", 32)
+	require.True(t, isValidAccountName(accountName))
+	key := getTokenKey(userID, accountName)
+	assert.True(t, utf8.RuneCountInString(key) <= model.KEY_VALUE_KEY_MAX_RUNES)
+	assert.Contains(t, key, GooglePlayTokenKey, "the key rotation finds the token")
+	assert.NotEqual(t, key, getTokenKey(userID, strings.Repeat("b", 32)))
+
+	token := &oauth2.Token{AccessToken: "access", RefreshToken: "refresh"}
+	require.NoError(t, p.storeGooglePlayUserInfo(&GooglePlayUserInfo{UserID: userID, AccountName: accountName, Token: token}))
+	info, err := p.getGooglePlayUserInfo(userID, accountName)
+	require.NoError(t, err)
+	assert.Equal(t, token, info.Token)
+
+	reencrypted, failed, err := p.reencryptTokens()
+	require.NoError(t, err)
+	assert.Equal(t, 1, reencrypted)
+	assert.Equal(t, 0, failed)
+}
@@ -1,15 +1,8 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
-	"net/http"
-	"strings"
 	"time"
-
-	"github.com/mattermost/mattermost-server/v5/model"
-
-	"google.golang.org/api/androidpublisher/v3"
 )
 
 // Alert stores the important information about what to alert and how often.
@@ -23,13 +16,15 @@ type Alert = struct {
 // NewReviewsAlert declares an alert for new reviews on the system
 type NewReviewsAlert = struct {
 	Alert
-	count int
+	// Tag limits the alert to the reviews tagged with it by the classifier. Empty means all reviews.
+	Tag        string
+	newReviews []*Review
 }
 
 // NewUpdatesAlert declares an alert for updates in the user reviews
 type NewUpdatesAlert = struct {
 	Alert
-	updatedReviews []*androidpublisher.Review
+	updatedReviews []*Review
 }
 
 func (p *Plugin) watchAlerts() {
@@ -37,43 +32,50 @@ func (p *Plugin) watchAlerts() {
 		//s.testAlert(&mockReview)
 		config := p.getConfiguration()
 		time.Sleep(time.Duration(config.AlertWatcherTime) * time.Second)
+		if !p.isLeader() {
+			continue
+		}
 		p.alertNewReviews()
 		p.alertNewUpdates()
+		p.alertKeywords()
+		p.alertAnomalies()
+		p.alertNewVersions()
+		p.alertSLAs()
 	}
 }
 
-func (p *Plugin) updateAlerts(packageName string, userID string, updatedReviews []*androidpublisher.Review, newReviews []*androidpublisher.Review) {
-	for _, v := range p.alerts.NewReviewsAlerts[userID] {
+// updateAlerts adds the reviews to the pending alerts. It must be called holding reviewsMutex.
+func (p *Plugin) updateAlerts(packageName string, userID string, updatedReviews []*Review, newReviews []*Review) {
+	alerts := p.state.getAlerts()
+	for _, v := range alerts.NewReviewsAlerts[userID] {
 		if v.PackageName == packageName {
-			v.count += len(newReviews)
+			v.newReviews = append(filterReviewsByTag(newReviews, v.Tag), v.newReviews...)
 		}
 	}
-	for _, v := range p.alerts.NewUpdatesAlerts[userID] {
+	for _, v := range alerts.NewUpdatesAlerts[userID] {
 		if v.PackageName == packageName {
 			v.updatedReviews = append(updatedReviews, v.updatedReviews...)
 		}
 	}
+	for k, v := range alerts.KeywordsAlerts[userID] {
+		if v.PackageName == packageName {
+			p.updateKeywordsAlert(k, v, userID, append(newReviews, updatedReviews...))
+		}
+	}
+	newVersions := p.detectNewVersions(userID, packageName, append(newReviews, updatedReviews...))
+	for _, v := range alerts.NewVersionAlerts[userID] {
+		if v.PackageName == packageName {
+			v.newVersions = append(v.newVersions, newVersions...)
+		}
+	}
 }
 
-func (p *Plugin) testAlert(review *androidpublisher.Review) {
-	for _, alerts := range p.alerts.NewReviewsAlerts {
+func (p *Plugin) testAlert(review *Review) {
+	for userID, alerts := range p.state.getAlerts().NewReviewsAlerts {
 		for k, v := range alerts {
-			text := fmt.Sprintf("Test alert for alert named %s\n", k)
-			text += formatReview(review)
-			request := model.IncomingWebhookRequest{
-				Text: text,
-			}
-
-			b, err := json.Marshal(request)
-
-			if err != nil {
-				fmt.Print(err.Error())
-				return
-			}
-
-			_, err = http.Post(v.Webhook, "application/json", strings.NewReader(string(b)))
-			if err != nil {
-				fmt.Print(err.Error())
+			text := fmt.Sprintf("Test alert for alert named %s", k)
+			if err := p.sendWebhookPost("test", v.Webhook, text, p.getReviewCards(userID, v.PackageName, []*Review{review})); err != nil {
+				p.logError("Error sending test alert", "alert", k, "package", v.PackageName, "err", err.Error())
 				return
 			}
 		}
@@ -81,109 +83,88 @@ func (p *Plugin) testAlert(review *androidpublisher.Review) {
 }
 
 func (p *Plugin) alertNewUpdates() {
-	for _, alerts := range p.alerts.NewUpdatesAlerts {
-		for _, v := range alerts {
-			go p.sendUpdatedAlert(v)
+	for userID, alerts := range p.state.getAlerts().NewUpdatesAlerts {
+		for k, v := range alerts {
+			go p.sendUpdatedAlert(k, v, userID)
 		}
 	}
 }
 
-func (p *Plugin) sendUpdatedAlert(alert *NewUpdatesAlert) {
-	if alert.lastAlerted.Unix()+alert.Frequency > time.Now().Unix() {
-		return
-	}
-
-	if len(alert.updatedReviews) == 0 {
+func (p *Plugin) sendUpdatedAlert(name string, alert *NewUpdatesAlert, userID string) {
+	p.control.reviewsMutex.Lock()
+	if alert.lastAlerted.Unix()+alert.Frequency > time.Now().Unix() || len(alert.updatedReviews) == 0 {
+		p.control.reviewsMutex.Unlock()
 		return
 	}
 
 	text := fmt.Sprintf("## Some reviews has been updated:\n")
 
-	p.control.reviewsMutex.Lock()
-	defer func() {
-		alert.updatedReviews = []*androidpublisher.Review{}
-		p.control.reviewsMutex.Unlock()
-	}()
-
 	config := p.getConfiguration()
 	showing := min(len(alert.updatedReviews), config.MaxReviewsServed)
 
-	for _, review := range alert.updatedReviews[:showing] {
-		text += formatReview(review)
-	}
-	if showing > config.MaxReviewsServed {
+	shown := alert.updatedReviews[:showing]
+	if len(alert.updatedReviews) > showing {
 		text += fmt.Sprintf("and **%d** more not shown.", len(alert.updatedReviews)-showing)
 	}
+	alert.updatedReviews = []*Review{}
+	p.control.reviewsMutex.Unlock()
 
-	request := model.IncomingWebhookRequest{
-		Text: text,
-	}
-
-	b, err := json.Marshal(request)
-
-	if err != nil {
-		fmt.Print(err.Error())
+	attachments := p.getReviewCards(userID, alert.PackageName, shown)
+	if err := p.sendWebhookPost("newUpdates", alert.Webhook, text, attachments); err != nil {
+		p.logError("Error sending alert", "userID", userID, "package", alert.PackageName, "alert", name, "err", err.Error())
+		p.recordAlertDelivery("newUpdates", err)
 		return
 	}
-
-	_, err = http.Post(alert.Webhook, "application/json", strings.NewReader(string(b)))
-	if err != nil {
-		fmt.Print(err.Error())
-		return
-	}
-	alert.lastAlerted = time.Now()
+	p.logDebug("Alert sent", "userID", userID, "package", alert.PackageName, "alert", name)
+	p.recordAlertDelivery("newUpdates", nil)
+	p.setLastAlerted(&alert.Alert)
 }
 
 func (p *Plugin) alertNewReviews() {
-	for userID, alerts := range p.alerts.NewReviewsAlerts {
-		for _, v := range alerts {
-			go p.sendReviewsAlert(v, userID)
+	for userID, alerts := range p.state.getAlerts().NewReviewsAlerts {
+		for k, v := range alerts {
+			go p.sendReviewsAlert(k, v, userID)
 		}
 	}
 }
 
-func (p *Plugin) sendReviewsAlert(alert *NewReviewsAlert, userID string) {
-	if alert.lastAlerted.Unix()+alert.Frequency > time.Now().Unix() {
-		return
-	}
-
-	if alert.count == 0 {
+// sendReviewsAlert builds the alert holding reviewsMutex, and sends it after releasing it
+func (p *Plugin) sendReviewsAlert(name string, alert *NewReviewsAlert, userID string) {
+	p.control.reviewsMutex.Lock()
+	if alert.lastAlerted.Unix()+alert.Frequency > time.Now().Unix() || len(alert.newReviews) == 0 {
+		p.control.reviewsMutex.Unlock()
 		return
 	}
 
 	text := "## You have new reviews:\n"
-
-	p.control.reviewsMutex.Lock()
-	defer func() {
-		alert.count = 0
-		p.control.reviewsMutex.Unlock()
-	}()
+	if alert.Tag != "" {
+		text = fmt.Sprintf("## You have new reviews tagged **%s**:\n", alert.Tag)
+	}
 
 	config := p.getConfiguration()
-	showing := min(alert.count, config.MaxReviewsServed)
-
-	for _, review := range p.localReviews[userID][alert.PackageName][:showing] {
-		text += formatReview(review)
-	}
-	if showing > config.MaxReviewsServed {
-		text += fmt.Sprintf("and **%d** more not shown.", alert.count-showing)
-	}
+	showing := min(len(alert.newReviews), config.MaxReviewsServed)
 
-	request := model.IncomingWebhookRequest{
-		Text: text,
+	shown := alert.newReviews[:showing]
+	if len(alert.newReviews) > showing {
+		text += fmt.Sprintf("and **%d** more not shown.", len(alert.newReviews)-showing)
 	}
+	alert.newReviews = []*Review{}
+	p.control.reviewsMutex.Unlock()
 
-	b, err := json.Marshal(request)
-
-	if err != nil {
-		fmt.Print(err.Error())
+	attachments := p.getReviewCards(userID, alert.PackageName, shown)
+	if err := p.sendWebhookPost("newReviews", alert.Webhook, text, attachments); err != nil {
+		p.logError("Error sending alert", "userID", userID, "package", alert.PackageName, "alert", name, "err", err.Error())
+		p.recordAlertDelivery("newReviews", err)
 		return
 	}
+	p.logDebug("Alert sent", "userID", userID, "package", alert.PackageName, "alert", name)
+	p.recordAlertDelivery("newReviews", nil)
+	p.setLastAlerted(&alert.Alert)
+}
 
-	_, err = http.Post(alert.Webhook, "application/json", strings.NewReader(string(b)))
-	if err != nil {
-		fmt.Print(err.Error())
-		return
-	}
+// setLastAlerted records an alert as sent. The alert fields are owned by reviewsMutex.
+func (p *Plugin) setLastAlerted(alert *Alert) {
+	p.control.reviewsMutex.Lock()
+	defer p.control.reviewsMutex.Unlock()
 	alert.lastAlerted = time.Now()
 }
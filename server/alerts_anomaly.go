@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// minAnomalyReviews is the minimum amount of reviews on the current window to consider it an anomaly
+const minAnomalyReviews = 5
+
+// AnomalyAlert declares an alert for when the reviews of the current window deviate from the baseline.
+// Threshold is a percentage: relative for the review rate and the average stars, and in points for the share of 1-2 star reviews.
+type AnomalyAlert = struct {
+	Alert
+	WindowHours  int64
+	BaselineDays int64
+	Threshold    float64
+	// firing are the metrics already alerted, not alerted again until they recover
+	firing map[string]bool
+}
+
+const (
+	anomalyRate         = "rate"
+	anomalyAverageStars = "averageStars"
+	anomalyLowStars     = "lowStars"
+)
+
+// anomaly is a metric past the threshold, with the numbers that triggered it
+type anomaly struct {
+	metric      string
+	description string
+}
+
+type reviewMetrics struct {
+	count         int
+	rate          float64
+	averageStars  float64
+	lowStarsShare float64
+}
+
+func getReviewMetrics(reviews []*Review, from time.Time, to time.Time) reviewMetrics {
+	metrics := reviewMetrics{}
+	var stars, lowStars int64
+	for _, review := range reviews {
+		lastModified := review.LastModified
+		if lastModified.Before(from) || !lastModified.Before(to) {
+			continue
+		}
+		metrics.count++
+		stars += review.Rating
+		if review.isLowRated() {
+			lowStars++
+		}
+	}
+
+	metrics.rate = float64(metrics.count) / to.Sub(from).Hours()
+	if metrics.count > 0 {
+		metrics.averageStars = float64(stars) / float64(metrics.count)
+		metrics.lowStarsShare = float64(lowStars) * 100 / float64(metrics.count)
+	}
+	return metrics
+}
+
+// getCacheStart returns the oldest review of the cache, the earliest time the cached reviews describe
+func getCacheStart(reviews []*Review) time.Time {
+	var start time.Time
+	for _, review := range reviews {
+		if start.IsZero() || review.LastModified.Before(start) {
+			start = review.LastModified
+		}
+	}
+	return start
+}
+
+// getAnomalies compares the current window with the baseline and describes every metric past the threshold
+func getAnomalies(current reviewMetrics, baseline reviewMetrics, threshold float64) []anomaly {
+	anomalies := []anomaly{}
+	if current.count < minAnomalyReviews || baseline.count == 0 {
+		return anomalies
+	}
+
+	if deviation := (current.rate - baseline.rate) * 100 / baseline.rate; deviation > threshold {
+		anomalies = append(anomalies, anomaly{anomalyRate, fmt.Sprintf("Review rate: **%.2f** reviews/hour against **%.2f** on the baseline (**+%.0f%%**)", current.rate, baseline.rate, deviation)})
+	}
+
+	if deviation := (baseline.averageStars - current.averageStars) * 100 / baseline.averageStars; deviation > threshold {
+		anomalies = append(anomalies, anomaly{anomalyAverageStars, fmt.Sprintf("Average stars: **%.2f** against **%.2f** on the baseline (**-%.0f%%**)", current.averageStars, baseline.averageStars, deviation)})
+	}
+
+	if deviation := current.lowStarsShare - baseline.lowStarsShare; deviation > threshold {
+		anomalies = append(anomalies, anomaly{anomalyLowStars, fmt.Sprintf("1-2 star reviews: **%.0f%%** against **%.0f%%** on the baseline (**+%.0f** points)", current.lowStarsShare, baseline.lowStarsShare, deviation)})
+	}
+
+	return anomalies
+}
+
+// updateFiringAnomalies records the metrics past the threshold, telling if any of them was not already alerted.
+// Metrics back under the threshold are forgotten, so they are alerted again on their next deviation.
+func updateFiringAnomalies(alert *AnomalyAlert, anomalies []anomaly) bool {
+	firing := make(map[string]bool)
+	isNew := false
+	for _, a := range anomalies {
+		firing[a.metric] = true
+		if !alert.firing[a.metric] {
+			isNew = true
+		}
+	}
+	alert.firing = firing
+	return isNew
+}
+
+func (p *Plugin) alertAnomalies() {
+	for userID, alerts := range p.state.getAlerts().AnomalyAlerts {
+		for k, v := range alerts {
+			go p.sendAnomalyAlert(k, v, userID)
+		}
+	}
+}
+
+func (p *Plugin) sendAnomalyAlert(name string, alert *AnomalyAlert, userID string) {
+	now := time.Now()
+	window := time.Duration(alert.WindowHours) * time.Hour
+	windowStart := now.Add(-window)
+	baselineStart := windowStart.Add(-time.Duration(alert.BaselineDays) * 24 * time.Hour)
+
+	p.control.reviewsMutex.Lock()
+	if alert.lastAlerted.Unix()+alert.Frequency > now.Unix() {
+		p.control.reviewsMutex.Unlock()
+		return
+	}
+	reviews := p.localReviews[userID][alert.PackageName]
+	// The baseline rate is measured on the time the cache covers, as right after the app is added it holds less than the baseline days.
+	// Without a window worth of history there is nothing to compare with.
+	if cacheStart := getCacheStart(reviews); cacheStart.After(baselineStart) {
+		baselineStart = cacheStart
+	}
+	if windowStart.Sub(baselineStart) < window {
+		p.control.reviewsMutex.Unlock()
+		return
+	}
+	current := getReviewMetrics(reviews, windowStart, now)
+	baseline := getReviewMetrics(reviews, baselineStart, windowStart)
+	anomalies := getAnomalies(current, baseline, alert.Threshold)
+	isNew := updateFiringAnomalies(alert, anomalies)
+	p.control.reviewsMutex.Unlock()
+
+	if !isNew {
+		return
+	}
+
+	text := fmt.Sprintf("## Anomaly detected by the alert **%s** on package **%s**:\n", name, alert.PackageName)
+	text += fmt.Sprintf("Last **%d hours** (**%d** reviews) against the previous **%.1f days** (**%d** reviews):\n", alert.WindowHours, current.count, windowStart.Sub(baselineStart).Hours()/24, baseline.count)
+	for _, anomaly := range anomalies {
+		text += fmt.Sprintf("* %s\n", anomaly.description)
+	}
+
+	if err := p.sendWebhookText("anomaly", alert.Webhook, text); err != nil {
+		p.logError("Error sending anomaly alert", "userID", userID, "package", alert.PackageName, "alert", name, "err", err.Error())
+		p.recordAlertDelivery("anomaly", err)
+		return
+	}
+	p.logDebug("Anomaly alert sent", "userID", userID, "package", alert.PackageName, "alert", name)
+	p.recordAlertDelivery("anomaly", nil)
+	p.setLastAlerted(&alert.Alert)
+}
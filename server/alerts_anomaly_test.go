@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSendAnomalyAlert(t *testing.T) {
+	sent := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sent++
+	}))
+	defer server.Close()
+
+	p := newTestPlugin()
+	alert := &AnomalyAlert{Alert: Alert{Webhook: server.URL, PackageName: "com.example"}, WindowHours: 24, BaselineDays: 7, Threshold: 50}
+
+	// The cache only covers a day and a half before the window, at the same rate as the window
+	now := time.Now()
+	reviews := []*Review{}
+	for i := 0; i < 16; i++ {
+		review := newTestReview(fmt.Sprintf("review%d", i), 0, "Fine")
+		review.Rating = 5
+		review.LastModified = now.Add(-time.Duration(4*i+1) * time.Hour)
+		reviews = append(reviews, review)
+	}
+	p.localReviews["user"] = map[string][]*Review{"com.example": reviews}
+	p.sendAnomalyAlert("anomaly", alert, "user")
+	assert.Equal(t, 0, sent)
+
+	for i := 0; i < 30; i++ {
+		review := newTestReview(fmt.Sprintf("spike%d", i), 0, "Crashes")
+		review.LastModified = now.Add(-time.Minute)
+		reviews = append([]*Review{review}, reviews...)
+	}
+	p.localReviews["user"]["com.example"] = reviews
+	p.sendAnomalyAlert("anomaly", alert, "user")
+	assert.Equal(t, 1, sent)
+
+	p.sendAnomalyAlert("anomaly", alert, "user")
+	assert.Equal(t, 1, sent)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// KeywordsAlert declares an alert for reviews mentioning any of the terms, whatever the rating
+type KeywordsAlert = struct {
+	Alert
+	// Terms are matched case-insensitively. Terms written as /pattern/ are regular expressions.
+	Terms []string
+	// Language limits the alert to the reviews written on it. Empty means all languages.
+	Language string
+	// Tag limits the alert to the reviews tagged with it by the classifier. Empty means all reviews.
+	Tag            string
+	matchedReviews []*keywordsMatch
+}
+
+type keywordsMatch struct {
+	review  *Review
+	matched []string
+}
+
+func compileKeywordsTerms(terms []string) ([]*regexp.Regexp, error) {
+	patterns := []*regexp.Regexp{}
+	for _, term := range terms {
+		expression := regexp.QuoteMeta(term)
+		if len(term) > 2 && strings.HasPrefix(term, "/") && strings.HasSuffix(term, "/") {
+			expression = term[1 : len(term)-1]
+		}
+
+		pattern, err := regexp.Compile("(?i)" + expression)
+		if err != nil {
+			return nil, fmt.Errorf("%s is not a valid regular expression: %v", term, err)
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns, nil
+}
+
+func isReviewInLanguage(review *Review, language string) bool {
+	if language == "" {
+		return true
+	}
+	reviewerLanguage := strings.ToLower(review.Language)
+	return strings.HasPrefix(reviewerLanguage, strings.ToLower(language))
+}
+
+// matchKeywords returns the terms found on the text or the original text of the review
+func matchKeywords(review *Review, patterns []*regexp.Regexp) []string {
+	matched := []string{}
+	found := make(map[string]bool)
+	for _, pattern := range patterns {
+		for _, text := range []string{review.Text, review.OriginalText} {
+			for _, match := range pattern.FindAllString(text, -1) {
+				if key := strings.ToLower(match); !found[key] {
+					found[key] = true
+					matched = append(matched, match)
+				}
+			}
+		}
+	}
+	return matched
+}
+
+func highlightKeywords(text string, patterns []*regexp.Regexp) string {
+	for _, pattern := range patterns {
+		text = pattern.ReplaceAllStringFunc(text, func(match string) string {
+			return "**" + match + "**"
+		})
+	}
+	return text
+}
+
+func (p *Plugin) updateKeywordsAlert(name string, alert *KeywordsAlert, userID string, reviews []*Review) {
+	patterns, err := compileKeywordsTerms(alert.Terms)
+	if err != nil {
+		p.logError("Error compiling keywords alert terms", "userID", userID, "package", alert.PackageName, "alert", name, "err", err.Error())
+		return
+	}
+
+	for _, review := range reviews {
+		if !isReviewInLanguage(review, alert.Language) || !review.hasTag(alert.Tag) {
+			continue
+		}
+		if matched := matchKeywords(review, patterns); len(matched) > 0 {
+			alert.matchedReviews = append(alert.matchedReviews, &keywordsMatch{
+				review:  review,
+				matched: matched,
+			})
+		}
+	}
+}
+
+func (p *Plugin) alertKeywords() {
+	for userID, alerts := range p.state.getAlerts().KeywordsAlerts {
+		for k, v := range alerts {
+			go p.sendKeywordsAlert(k, v, userID)
+		}
+	}
+}
+
+func (p *Plugin) sendKeywordsAlert(name string, alert *KeywordsAlert, userID string) {
+	p.control.reviewsMutex.Lock()
+	if alert.lastAlerted.Unix()+alert.Frequency > time.Now().Unix() || len(alert.matchedReviews) == 0 {
+		p.control.reviewsMutex.Unlock()
+		return
+	}
+
+	text := fmt.Sprintf("## Some reviews mention the terms of the alert **%s**:\n", name)
+
+	matchedReviews := alert.matchedReviews
+	alert.matchedReviews = []*keywordsMatch{}
+	p.control.reviewsMutex.Unlock()
+
+	patterns, err := compileKeywordsTerms(alert.Terms)
+	if err != nil {
+		p.logError("Error compiling keywords alert terms", "userID", userID, "package", alert.PackageName, "alert", name, "err", err.Error())
+		return
+	}
+
+	config := p.getConfiguration()
+	showing := min(len(matchedReviews), config.MaxReviewsServed)
+
+	shown := []*Review{}
+	for _, match := range matchedReviews[:showing] {
+		shown = append(shown, match.review)
+	}
+	if len(matchedReviews) > showing {
+		text += fmt.Sprintf("and **%d** more not shown.", len(matchedReviews)-showing)
+	}
+
+	attachments := p.getReviewCards(userID, alert.PackageName, shown)
+	for i, match := range matchedReviews[:showing] {
+		attachments[i].Pretext = fmt.Sprintf("Matched: **%s**", strings.Join(match.matched, "**, **"))
+		attachments[i].Text = highlightKeywords(attachments[i].Text, patterns)
+	}
+	if err := p.sendWebhookPost("keywords", alert.Webhook, text, attachments); err != nil {
+		p.logError("Error sending keywords alert", "userID", userID, "package", alert.PackageName, "alert", name, "err", err.Error())
+		p.recordAlertDelivery("keywords", err)
+		return
+	}
+	p.logDebug("Keywords alert sent", "userID", userID, "package", alert.PackageName, "alert", name)
+	p.recordAlertDelivery("keywords", nil)
+	p.setLastAlerted(&alert.Alert)
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+const (
+	draftPending = "pending"
+	// draftSending marks an approved draft while it is sent to the store, so no one else can review it
+	draftSending  = "sending"
+	draftApproved = "approved"
+	draftRejected = "rejected"
+)
+
+// ApprovalsContainer contains the approvers channel of each app and the reply drafts waiting or already reviewed
+type ApprovalsContainer struct {
+	// Channels stores the approvers channel id for each app with the approval mode enabled by a system admin
+	Channels map[string]string
+	Drafts   map[string]*ReplyDraft
+}
+
+// ReplyDraft stores a reply drafted from Mattermost, waiting for approval before being sent to Google Play
+type ReplyDraft struct {
+	ID          string
+	UserID      string
+	DraftedBy   string
+	PackageName string
+	ReviewID    string
+	Text        string
+	ChannelID   string
+	PostID      string
+	Status      string
+	ReviewedBy  string
+	ReviewedAt  time.Time
+}
+
+func (p *Plugin) draftReply(userID string, packageName string, reviewID string, text string) error {
+	if err := checkReplyLength(text); err != nil {
+		return err
+	}
+
+	channelID, ok := p.state.getApprovalChannel(packageName)
+	if !ok {
+		return fmt.Errorf("approval mode is not enabled")
+	}
+
+	draft := &ReplyDraft{
+		ID:          model.NewId(),
+		UserID:      userID,
+		DraftedBy:   userID,
+		PackageName: packageName,
+		ReviewID:    reviewID,
+		Text:        text,
+		ChannelID:   channelID,
+		Status:      draftPending,
+	}
+
+	post := &model.Post{
+		UserId:    p.botUserID,
+		ChannelId: draft.ChannelID,
+	}
+	p.setDraftAttachment(post, draft)
+
+	created, appErr := p.API.CreatePost(post)
+	if appErr != nil {
+		return appErr
+	}
+
+	draft.PostID = created.Id
+	p.updateSharedState(p.LoadApprovals, p.SaveApprovals, func() {
+		p.state.setDraft(draft)
+	})
+	return nil
+}
+
+func (p *Plugin) setDraftAttachment(post *model.Post, draft *ReplyDraft) {
+	drafter := p.getUsername(draft.DraftedBy)
+
+	text := fmt.Sprintf("@%s drafted a reply to the review **%s** of **%s**:\n>%s", drafter, draft.ReviewID, draft.PackageName, draft.Text)
+
+	attachment := &model.SlackAttachment{
+		Fallback: fmt.Sprintf("Reply drafted by @%s waiting for approval", drafter),
+		Text:     text,
+	}
+	attachments := []*model.SlackAttachment{attachment}
+	if review, err := p.getReview(draft.UserID, draft.PackageName, draft.ReviewID); err == nil {
+		card := p.getReviewCards(draft.UserID, draft.PackageName, []*Review{review})[0]
+		card.Pretext = "Replying to:"
+		attachments = append(attachments, card)
+	}
+
+	switch draft.Status {
+	case draftPending:
+		attachment.Actions = []*model.PostAction{
+			p.getDraftAction("Approve", "/approval/approve", draft),
+			p.getDraftAction("Edit", "/approval/edit", draft),
+			p.getDraftAction("Reject", "/approval/reject", draft),
+		}
+	case draftApproved:
+		attachment.Color = "#3db887"
+		attachment.Footer = fmt.Sprintf("Approved and sent by @%s", p.getUsername(draft.ReviewedBy))
+	case draftRejected:
+		attachment.Color = "#d24b4e"
+		attachment.Footer = fmt.Sprintf("Rejected by @%s", p.getUsername(draft.ReviewedBy))
+	}
+
+	model.ParseSlackAttachment(post, attachments)
+}
+
+func (p *Plugin) getDraftAction(name string, path string, draft *ReplyDraft) *model.PostAction {
+	return &model.PostAction{
+		Name: name,
+		Type: model.POST_ACTION_TYPE_BUTTON,
+		Integration: &model.PostActionIntegration{
+			URL: p.getPluginURL() + path,
+			Context: map[string]interface{}{
+				"draft_id": draft.ID,
+			},
+		},
+	}
+}
+
+func (p *Plugin) getUsername(userID string) string {
+	user, appErr := p.API.GetUser(userID)
+	if appErr != nil {
+		return userID
+	}
+	return user.Username
+}
+
+// canApprove checks that the user is a member of the approvers channel of the draft, other than its author
+func (p *Plugin) canApprove(userID string, draft *ReplyDraft) bool {
+	if userID == draft.DraftedBy {
+		return false
+	}
+	_, appErr := p.API.GetChannelMember(draft.ChannelID, userID)
+	return appErr == nil
+}
+
+// getPendingDraft reads the integration request and returns the pending draft the approver is allowed to review,
+// with the approver id
+func (p *Plugin) getPendingDraft(w http.ResponseWriter, r *http.Request) (*model.PostActionIntegrationRequest, string, *ReplyDraft, bool) {
+	userID := r.Header.Get("Mattermost-User-ID")
+	if userID == "" {
+		http.Error(w, "Not authorized", http.StatusUnauthorized)
+		return nil, "", nil, false
+	}
+
+	request := model.PostActionIntegrationRequestFromJson(r.Body)
+	if request == nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return nil, "", nil, false
+	}
+
+	draftID, _ := request.Context["draft_id"].(string)
+	draft, ok := p.state.getDraft(draftID)
+	if !ok {
+		writePostActionResponse(w, "This draft no longer exists.")
+		return nil, "", nil, false
+	}
+
+	if !p.canApprove(userID, draft) {
+		writePostActionResponse(w, "Only members of the approvers channel other than the author can review drafts.")
+		return nil, "", nil, false
+	}
+
+	if draft.Status != draftPending {
+		writeDraftReviewedResponse(w, draft)
+		return nil, "", nil, false
+	}
+
+	return request, userID, draft, true
+}
+
+func writeDraftReviewedResponse(w http.ResponseWriter, draft *ReplyDraft) {
+	if draft.Status == draftSending {
+		writePostActionResponse(w, "This draft is being sent.")
+		return
+	}
+	writePostActionResponse(w, fmt.Sprintf("This draft has already been %s.", draft.Status))
+}
+
+func (p *Plugin) approveDraft(w http.ResponseWriter, r *http.Request) {
+	_, userID, draft, ok := p.getPendingDraft(w, r)
+	if !ok {
+		return
+	}
+
+	// Two approvers may click at once, even on different nodes, so the draft is claimed on the KV store before sending it
+	var current ReplyDraft
+	var claimed *ReplyDraft
+	err := p.updateSharedState(p.LoadApprovals, p.SaveApprovals, func() {
+		claimed, ok = p.state.editDraft(draft.ID, func(stored *ReplyDraft) bool {
+			current = *stored
+			if stored.Status != draftPending {
+				return false
+			}
+			stored.Status = draftSending
+			stored.ReviewedBy = userID
+			return true
+		})
+	})
+	if err != nil {
+		// The claim was not stored, so the draft is left as the other nodes see it
+		p.LoadApprovals()
+		writePostActionResponse(w, "Error approving the draft, please try again.")
+		return
+	}
+	if !ok {
+		writeDraftReviewedResponse(w, &current)
+		return
+	}
+
+	if err := p.replyToReview(claimed.UserID, claimed.PackageName, claimed.ReviewID, claimed.Text); err != nil {
+		p.updateSharedState(p.LoadApprovals, p.SaveApprovals, func() {
+			p.state.editDraft(claimed.ID, func(stored *ReplyDraft) bool {
+				if stored.Status != draftSending || stored.ReviewedBy != userID {
+					return false
+				}
+				stored.Status = draftPending
+				stored.ReviewedBy = ""
+				return true
+			})
+		})
+		writePostActionResponse(w, fmt.Sprintf("Error replying to the review %s: %s", claimed.ReviewID, err.Error()))
+		return
+	}
+
+	p.closeDraft(w, claimed, draftApproved, userID)
+}
+
+func (p *Plugin) rejectDraft(w http.ResponseWriter, r *http.Request) {
+	_, userID, draft, ok := p.getPendingDraft(w, r)
+	if !ok {
+		return
+	}
+
+	p.closeDraft(w, draft, draftRejected, userID)
+}
+
+// closeDraft stores the review of the draft, if it is still pending or being sent by the same reviewer
+func (p *Plugin) closeDraft(w http.ResponseWriter, draft *ReplyDraft, status string, reviewerID string) {
+	var current ReplyDraft
+	draftID := draft.ID
+	ok := false
+	p.updateSharedState(p.LoadApprovals, p.SaveApprovals, func() {
+		draft, ok = p.state.editDraft(draftID, func(stored *ReplyDraft) bool {
+			current = *stored
+			if stored.Status != draftPending && (stored.Status != draftSending || stored.ReviewedBy != reviewerID) {
+				return false
+			}
+			stored.Status = status
+			stored.ReviewedBy = reviewerID
+			stored.ReviewedAt = time.Now()
+			return true
+		})
+	})
+	if !ok {
+		writeDraftReviewedResponse(w, &current)
+		return
+	}
+
+	p.notifyDrafter(draft)
+
+	post := &model.Post{}
+	p.setDraftAttachment(post, draft)
+	response := &model.PostActionIntegrationResponse{
+		Update: post,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response.ToJson())
+}
+
+func (p *Plugin) notifyDrafter(draft *ReplyDraft) {
+	channel, appErr := p.API.GetDirectChannel(draft.DraftedBy, p.botUserID)
+	if appErr != nil {
+		return
+	}
+
+	p.API.CreatePost(&model.Post{
+		UserId:    p.botUserID,
+		ChannelId: channel.Id,
+		Message:   fmt.Sprintf("Your reply to the review **%s** of **%s** has been %s by @%s.", draft.ReviewID, draft.PackageName, draft.Status, p.getUsername(draft.ReviewedBy)),
+	})
+}
+
+func (p *Plugin) openEditDraftDialog(w http.ResponseWriter, r *http.Request) {
+	request, _, draft, ok := p.getPendingDraft(w, r)
+	if !ok {
+		return
+	}
+
+	dialog := model.OpenDialogRequest{
+		TriggerId: request.TriggerId,
+		URL:       p.getPluginURL() + "/approval/edit/submit",
+		Dialog: model.Dialog{
+			Title:       "Edit reply",
+			SubmitLabel: "Save",
+			State:       draft.ID,
+			Elements: []model.DialogElement{{
+				DisplayName: "Reply",
+				Name:        "text",
+				Type:        "textarea",
+				Default:     draft.Text,
+				MaxLength:   maxReplyLength,
+			}},
+		},
+	}
+
+	if appErr := p.API.OpenInteractiveDialog(dialog); appErr != nil {
+		writePostActionResponse(w, "Error opening the edit dialog: "+appErr.Error())
+		return
+	}
+	writePostActionResponse(w, "")
+}
+
+func (p *Plugin) submitEditDraftDialog(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-ID")
+	if userID == "" {
+		http.Error(w, "Not authorized", http.StatusUnauthorized)
+		return
+	}
+
+	request := model.SubmitDialogRequestFromJson(r.Body)
+	if request == nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	text, _ := request.Submission["text"].(string)
+	if err := checkReplyLength(text); err != nil {
+		writeSubmitDialogError(w, err.Error())
+		return
+	}
+
+	draft, ok := p.state.getDraft(request.State)
+	if !ok || !p.canApprove(userID, draft) {
+		writeSubmitDialogError(w, "This draft can no longer be edited.")
+		return
+	}
+
+	draftID := draft.ID
+	p.updateSharedState(p.LoadApprovals, p.SaveApprovals, func() {
+		draft, ok = p.state.editDraft(draftID, func(stored *ReplyDraft) bool {
+			if stored.Status != draftPending {
+				return false
+			}
+			stored.Text = text
+			return true
+		})
+	})
+	if !ok {
+		writeSubmitDialogError(w, "This draft can no longer be edited.")
+		return
+	}
+
+	if post, appErr := p.API.GetPost(draft.PostID); appErr == nil {
+		p.setDraftAttachment(post, draft)
+		p.API.UpdatePost(post)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func writePostActionResponse(w http.ResponseWriter, ephemeralText string) {
+	response := &model.PostActionIntegrationResponse{
+		EphemeralText: ephemeralText,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response.ToJson())
+}
+
+func writeSubmitDialogError(w http.ResponseWriter, message string) {
+	writeSubmitDialogErrors(w, map[string]string{"text": message})
+}
+
+// writeSubmitDialogErrors shows the errors under the dialog fields, keeping the dialog open
+func writeSubmitDialogErrors(w http.ResponseWriter, errors map[string]string) {
+	response := &model.SubmitDialogResponse{
+		Errors: errors,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response.ToJson())
+}
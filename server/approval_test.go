@@ -0,0 +1,145 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func newDraftActionRequest(headerUserID string, bodyUserID string) *http.Request {
+	request := &model.PostActionIntegrationRequest{
+		UserId:  bodyUserID,
+		Context: map[string]interface{}{"draft_id": "draft"},
+	}
+	r := httptest.NewRequest(http.MethodPost, "/approval/reject", strings.NewReader(string(request.ToJson())))
+	if headerUserID != "" {
+		r.Header.Set("Mattermost-User-ID", headerUserID)
+	}
+	return r
+}
+
+func TestRejectDraft(t *testing.T) {
+	p := newTestPlugin()
+	api := p.API.(*plugintest.API)
+	config := &model.Config{}
+	config.SetDefaults()
+	api.On("GetConfig").Return(config)
+	api.On("GetUser", mock.Anything).Return(&model.User{Username: "username"}, nil)
+	api.On("GetChannelMember", "approvers", mock.Anything).Return(&model.ChannelMember{}, nil)
+	api.On("GetDirectChannel", mock.Anything, mock.Anything).Return(&model.Channel{Id: "direct"}, nil)
+	api.On("CreatePost", mock.Anything).Return(&model.Post{}, nil)
+
+	packageInfo := PackageInfo{Name: "com.example", UserID: "drafter"}
+	p.state.addPackage(packageInfo)
+	p.storeReviews(packageInfo, []*Review{newTestReview("review", 1, "It crashes")})
+	p.state.setDraft(&ReplyDraft{
+		ID:          "draft",
+		UserID:      "drafter",
+		DraftedBy:   "drafter",
+		PackageName: "com.example",
+		ReviewID:    "review",
+		Text:        "Thanks",
+		ChannelID:   "approvers",
+		Status:      draftPending,
+	})
+
+	w := httptest.NewRecorder()
+	p.rejectDraft(w, newDraftActionRequest("", "approver"))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = httptest.NewRecorder()
+	p.rejectDraft(w, newDraftActionRequest("drafter", "approver"))
+	response := model.PostActionIntegrationResponseFromJson(w.Body)
+	require.NotNil(t, response)
+	assert.Contains(t, response.EphemeralText, "other than the author", "the user is taken from the header, and cannot review its own draft")
+	draft, _ := p.state.getDraft("draft")
+	assert.Equal(t, draftPending, draft.Status)
+
+	w = httptest.NewRecorder()
+	p.rejectDraft(w, newDraftActionRequest("approver", "approver"))
+	draft, _ = p.state.getDraft("draft")
+	assert.Equal(t, draftRejected, draft.Status)
+	assert.Equal(t, "approver", draft.ReviewedBy)
+
+	w = httptest.NewRecorder()
+	p.approveDraft(w, newDraftActionRequest("other", "other"))
+	response = model.PostActionIntegrationResponseFromJson(w.Body)
+	require.NotNil(t, response)
+	assert.Equal(t, "This draft has already been rejected.", response.EphemeralText)
+}
+
+func TestApprovalModeNeedsSystemAdmin(t *testing.T) {
+	p := newTestPlugin()
+	api := p.API.(*plugintest.API)
+	api.On("HasPermissionTo", "user", model.PERMISSION_MANAGE_SYSTEM).Return(false)
+	p.state.addPackage(PackageInfo{Name: "com.example", UserID: "user"})
+	p.state.setApprovalChannel("com.example", "approvers")
+
+	for _, command := range []string{"/gpreviews approval enable com.example ~town-square", "/gpreviews approval disable com.example"} {
+		response, _ := p.routeRoot(strings.Fields(command), "user", "channel", "")
+		assert.Equal(t, ":x:Only system admins can enable or disable the approval mode.", response.Text, command)
+	}
+	channelID, ok := p.state.getApprovalChannel("com.example")
+	assert.True(t, ok)
+	assert.Equal(t, "approvers", channelID)
+}
+
+func TestApproveDraftOnSeveralNodes(t *testing.T) {
+	api := &plugintest.API{}
+	newTestKVStore(api)
+	config := &model.Config{}
+	config.SetDefaults()
+	api.On("GetConfig").Return(config)
+	api.On("GetChannelMember", "approvers", mock.Anything).Return(&model.ChannelMember{}, nil)
+	first := newTestClusterNode(api)
+	second := newTestClusterNode(api)
+
+	first.state.addPackage(PackageInfo{Name: "com.example", UserID: "drafter"})
+	require.NoError(t, first.SavePackages())
+	first.state.setDraft(&ReplyDraft{
+		ID:          "draft",
+		UserID:      "drafter",
+		DraftedBy:   "drafter",
+		PackageName: "com.example",
+		ReviewID:    "review",
+		Text:        "Thanks",
+		ChannelID:   "approvers",
+		Status:      draftPending,
+	})
+	require.NoError(t, first.SaveApprovals())
+	second.loadSharedState()
+
+	// The app has no credentials, so the reply fails and the claim is released
+	w := httptest.NewRecorder()
+	first.approveDraft(w, newDraftActionRequest("approver", "approver"))
+	response := model.PostActionIntegrationResponseFromJson(w.Body)
+	require.NotNil(t, response)
+	assert.Contains(t, response.EphemeralText, "Error replying to the review")
+	second.LoadApprovals()
+	draft, _ := second.state.getDraft("draft")
+	assert.Equal(t, draftPending, draft.Status, "other approvers can try again")
+	assert.Empty(t, draft.ReviewedBy)
+
+	// The first node claims the draft while the second one still sees it pending
+	require.NoError(t, first.updateSharedState(first.LoadApprovals, first.SaveApprovals, func() {
+		first.state.editDraft("draft", func(stored *ReplyDraft) bool {
+			stored.Status = draftSending
+			stored.ReviewedBy = "approver"
+			return true
+		})
+	}))
+	w = httptest.NewRecorder()
+	second.approveDraft(w, newDraftActionRequest("other", "other"))
+	response = model.PostActionIntegrationResponseFromJson(w.Body)
+	require.NotNil(t, response)
+	assert.Equal(t, "This draft is being sent.", response.EphemeralText, "the draft is only sent once")
+	draft, _ = second.state.getDraft("draft")
+	assert.Equal(t, "approver", draft.ReviewedBy)
+}
@@ -0,0 +1,430 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+const (
+	appStoreBaseURL = "https://api.appstoreconnect.apple.com"
+	// appStoreTokenTime is how long the API tokens are valid. App Store Connect rejects tokens valid for more than 20 minutes.
+	appStoreTokenTime = 15 * time.Minute
+	// AppStoreCredentialsKey denotes the key on the KVStore for the App Store Connect API keys
+	AppStoreCredentialsKey = "_appstorecredentials"
+	// AppStoreAccountsKey denotes the key on the KVStore for the App Store Connect connections of a user
+	AppStoreAccountsKey = "_appstoreaccounts"
+)
+
+// storeAPIError is an error answered by the API of a store other than Google Play
+type storeAPIError struct {
+	Code    int
+	Message string
+}
+
+func (e *storeAPIError) Error() string {
+	return fmt.Sprintf("store API error %d: %s", e.Code, e.Message)
+}
+
+// appStoreSource fetches the reviews through the App Store Connect API, authenticated with an API key
+type appStoreSource struct {
+	baseURL  string
+	issuerID string
+	keyID    string
+	key      *ecdsa.PrivateKey
+	client   *http.Client
+}
+
+func newAppStoreSource(baseURL string, issuerID string, keyID string, privateKey []byte) (*appStoreSource, error) {
+	block, _ := pem.Decode(privateKey)
+	if block == nil {
+		return nil, errors.New("the private key is not PEM encoded")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := parsed.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("the private key is not an ECDSA key")
+	}
+
+	return &appStoreSource{
+		baseURL:  baseURL,
+		issuerID: issuerID,
+		keyID:    keyID,
+		key:      key,
+		client:   &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// getToken signs a JWT with ES256, as App Store Connect requires
+func (s *appStoreSource) getToken() (string, error) {
+	now := time.Now()
+	header, err := json.Marshal(map[string]string{"alg": "ES256", "kid": s.keyID, "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]interface{}{
+		"iss": s.issuerID,
+		"iat": now.Unix(),
+		"exp": now.Add(appStoreTokenTime).Unix(),
+		"aud": "appstoreconnect-v1",
+	})
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signingInput))
+	r, sig, err := ecdsa.Sign(rand.Reader, s.key, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	signature := make([]byte, 64)
+	copyPadded(signature[:32], r)
+	copyPadded(signature[32:], sig)
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func copyPadded(dst []byte, n *big.Int) {
+	b := n.Bytes()
+	copy(dst[len(dst)-len(b):], b)
+}
+
+func (s *appStoreSource) do(method string, path string, body interface{}, result interface{}) error {
+	token, err := s.getToken()
+	if err != nil {
+		return err
+	}
+
+	var requestBody *bytes.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		requestBody = bytes.NewReader(b)
+	} else {
+		requestBody = bytes.NewReader(nil)
+	}
+
+	request, err := http.NewRequest(method, s.baseURL+path, requestBody)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Authorization", "Bearer "+token)
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := s.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	b, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return &storeAPIError{Code: response.StatusCode, Message: getAppStoreErrorMessage(b)}
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(b, result)
+}
+
+func getAppStoreErrorMessage(body []byte) string {
+	var response struct {
+		Errors []struct {
+			Title  string `json:"title"`
+			Detail string `json:"detail"`
+		} `json:"errors"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil || len(response.Errors) == 0 {
+		return string(body)
+	}
+	return response.Errors[0].Title + ": " + response.Errors[0].Detail
+}
+
+type appStoreResourceID struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+type appStoreResource struct {
+	appStoreResourceID
+	Attributes struct {
+		Rating           int64  `json:"rating"`
+		Title            string `json:"title"`
+		Body             string `json:"body"`
+		ReviewerNickname string `json:"reviewerNickname"`
+		CreatedDate      string `json:"createdDate"`
+		Territory        string `json:"territory"`
+		ResponseBody     string `json:"responseBody"`
+		LastModifiedDate string `json:"lastModifiedDate"`
+	} `json:"attributes"`
+	Relationships struct {
+		Response struct {
+			Data *appStoreResourceID `json:"data"`
+		} `json:"response"`
+	} `json:"relationships"`
+}
+
+type appStoreListResponse struct {
+	Data     []appStoreResource `json:"data"`
+	Included []appStoreResource `json:"included"`
+}
+
+type appStoreGetResponse struct {
+	Data     appStoreResource   `json:"data"`
+	Included []appStoreResource `json:"included"`
+}
+
+// ListReviews ignores the language, App Store Connect does not translate the reviews
+func (s *appStoreSource) ListReviews(appID string, language string) ([]*Review, error) {
+	var response appStoreListResponse
+	path := fmt.Sprintf("/v1/apps/%s/customerReviews?sort=-createdDate&limit=100&include=response", url.PathEscape(appID))
+	if err := s.do(http.MethodGet, path, nil, &response); err != nil {
+		return nil, err
+	}
+
+	reviews := []*Review{}
+	for _, resource := range response.Data {
+		reviews = append(reviews, mapAppStoreReview(resource, response.Included))
+	}
+	return reviews, nil
+}
+
+func (s *appStoreSource) GetReview(appID string, reviewID string, language string) (*Review, error) {
+	var response appStoreGetResponse
+	path := fmt.Sprintf("/v1/customerReviews/%s?include=response", url.PathEscape(reviewID))
+	if err := s.do(http.MethodGet, path, nil, &response); err != nil {
+		return nil, err
+	}
+	return mapAppStoreReview(response.Data, response.Included), nil
+}
+
+func (s *appStoreSource) Reply(appID string, reviewID string, text string) (*Reply, error) {
+	request := map[string]interface{}{
+		"data": map[string]interface{}{
+			"type":       "customerReviewResponses",
+			"attributes": map[string]string{"responseBody": text},
+			"relationships": map[string]interface{}{
+				"review": map[string]interface{}{
+					"data": appStoreResourceID{Type: "customerReviews", ID: reviewID},
+				},
+			},
+		},
+	}
+
+	var response appStoreGetResponse
+	if err := s.do(http.MethodPost, "/v1/customerReviewResponses", request, &response); err != nil {
+		return nil, err
+	}
+	return &Reply{
+		Text:         response.Data.Attributes.ResponseBody,
+		LastModified: parseAppStoreDate(response.Data.Attributes.LastModifiedDate),
+	}, nil
+}
+
+// mapAppStoreReview converts an App Store customer review, with its response if any
+func mapAppStoreReview(resource appStoreResource, included []appStoreResource) *Review {
+	attributes := resource.Attributes
+	text := attributes.Body
+	if attributes.Title != "" {
+		text = attributes.Title + "\t" + attributes.Body
+	}
+
+	review := &Review{
+		ID:           resource.ID,
+		Author:       attributes.ReviewerNickname,
+		Rating:       attributes.Rating,
+		Text:         text,
+		LastModified: parseAppStoreDate(attributes.CreatedDate),
+	}
+
+	if response := resource.Relationships.Response.Data; response != nil {
+		for _, item := range included {
+			if item.Type == response.Type && item.ID == response.ID {
+				review.Reply = &Reply{
+					Text:         item.Attributes.ResponseBody,
+					LastModified: parseAppStoreDate(item.Attributes.LastModifiedDate),
+				}
+			}
+		}
+	}
+	return review
+}
+
+func parseAppStoreDate(date string) time.Time {
+	t, err := time.Parse(time.RFC3339, date)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// AppStoreCredentials stores the App Store Connect API key of a connection on the KVStore
+type AppStoreCredentials struct {
+	UserID      string
+	AccountName string
+	IssuerID    string
+	KeyID       string
+	// PrivateKey is the encrypted .p8 key
+	PrivateKey string
+}
+
+// getAppStoreCredentialsKey hashes the user and the account name, as they do not fit on the KV key, keeping
+// AppStoreCredentialsKey on it to be found on key rotations
+func getAppStoreCredentialsKey(userID string, accountName string) string {
+	return getHashedKey(AppStoreCredentialsKey+"_", userID+"/"+getAccountName(accountName))
+}
+
+func (p *Plugin) getAppStoreSource(userID string, accountName string) (ReviewSource, error) {
+	b, appErr := p.API.KVGet(getAppStoreCredentialsKey(userID, accountName))
+	if appErr != nil {
+		return nil, appErr
+	}
+	if b == nil {
+		return nil, fmt.Errorf("App Store account %s is not connected", getAccountName(accountName))
+	}
+
+	var credentials AppStoreCredentials
+	if err := json.Unmarshal(b, &credentials); err != nil {
+		return nil, err
+	}
+	privateKey, _, err := p.getEncryptionKeyring().decrypt(credentials.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	return newAppStoreSource(appStoreBaseURL, credentials.IssuerID, credentials.KeyID, []byte(privateKey))
+}
+
+func (p *Plugin) storeAppStoreCredentials(credentials *AppStoreCredentials, privateKey string) error {
+	encryptedKey, err := p.getEncryptionKeyring().encrypt(privateKey)
+	if err != nil {
+		return err
+	}
+
+	stored := *credentials
+	stored.PrivateKey = encryptedKey
+	b, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+	if appErr := p.API.KVSet(getAppStoreCredentialsKey(credentials.UserID, credentials.AccountName), b); appErr != nil {
+		return appErr
+	}
+	return p.addNamedAccount(credentials.UserID+AppStoreAccountsKey, credentials.AccountName)
+}
+
+func (p *Plugin) removeAppStoreAccount(userID string, accountName string) error {
+	if appErr := p.API.KVDelete(getAppStoreCredentialsKey(userID, accountName)); appErr != nil {
+		return appErr
+	}
+	return p.removeNamedAccount(userID+AppStoreAccountsKey, accountName)
+}
+
+func (p *Plugin) openAppStoreConnectDialog(triggerID string, accountName string) error {
+	dialog := model.OpenDialogRequest{
+		TriggerId: triggerID,
+		URL:       p.getPluginURL() + "/appstore/connect/submit",
+		Dialog: model.Dialog{
+			Title:       "Connect App Store account " + accountName,
+			SubmitLabel: "Connect",
+			State:       accountName,
+			Elements: []model.DialogElement{{
+				DisplayName: "Issuer ID",
+				Name:        "issuer_id",
+				Type:        "text",
+			}, {
+				DisplayName: "Key ID",
+				Name:        "key_id",
+				Type:        "text",
+			}, {
+				DisplayName: "Private key",
+				Name:        "private_key",
+				Type:        "textarea",
+				HelpText:    "Contents of the .p8 file downloaded from App Store Connect. It is stored encrypted.",
+				MaxLength:   3000,
+			}},
+		},
+	}
+	if appErr := p.API.OpenInteractiveDialog(dialog); appErr != nil {
+		return appErr
+	}
+	return nil
+}
+
+func (p *Plugin) submitAppStoreConnectDialog(w http.ResponseWriter, r *http.Request) {
+	request := model.SubmitDialogRequestFromJson(r.Body)
+	if request == nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	// The dialog request is built by the client, so the user is taken from the header set by the server
+	userID := r.Header.Get("Mattermost-User-ID")
+	if userID == "" || userID != request.UserId {
+		http.Error(w, "Not authorized", http.StatusUnauthorized)
+		return
+	}
+
+	accountName := request.State
+	if !isValidAccountName(accountName) {
+		http.Error(w, "invalid account name", http.StatusBadRequest)
+		return
+	}
+	issuerID, _ := request.Submission["issuer_id"].(string)
+	keyID, _ := request.Submission["key_id"].(string)
+	privateKey, _ := request.Submission["private_key"].(string)
+	issuerID = strings.TrimSpace(issuerID)
+	keyID = strings.TrimSpace(keyID)
+
+	source, err := newAppStoreSource(appStoreBaseURL, issuerID, keyID, []byte(privateKey))
+	if err != nil {
+		writeSubmitDialogErrors(w, map[string]string{"private_key": "Invalid private key: " + err.Error()})
+		return
+	}
+	if err := source.do(http.MethodGet, "/v1/apps?limit=1", nil, nil); err != nil {
+		writeSubmitDialogErrors(w, map[string]string{"key_id": "App Store Connect rejected the key: " + err.Error()})
+		return
+	}
+
+	credentials := &AppStoreCredentials{
+		UserID:      userID,
+		AccountName: accountName,
+		IssuerID:    issuerID,
+		KeyID:       keyID,
+	}
+	if err := p.storeAppStoreCredentials(credentials, privateKey); err != nil {
+		p.logError("Error storing App Store credentials", "userID", userID, "account", accountName, "err", err.Error())
+		writeSubmitDialogErrors(w, map[string]string{"private_key": "Unable to store the key."})
+		return
+	}
+
+	p.API.SendEphemeralPost(userID, &model.Post{
+		UserId:    p.botUserID,
+		ChannelId: request.ChannelId,
+		Message:   fmt.Sprintf(":white_check_mark:App Store account connected as **%s**.", accountName),
+	})
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestAppStoreKey(t *testing.T) (*ecdsa.PrivateKey, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	return key, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+// checkAppStoreToken verifies the request is signed with the key, as App Store Connect does
+func checkAppStoreToken(t *testing.T, r *http.Request, key *ecdsa.PrivateKey) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	parts := strings.Split(token, ".")
+	require.Len(t, parts, 3)
+
+	var header map[string]string
+	b, err := base64.RawURLEncoding.DecodeString(parts[0])
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(b, &header))
+	assert.Equal(t, map[string]string{"alg": "ES256", "kid": "KEY123", "typ": "JWT"}, header)
+
+	var claims map[string]interface{}
+	b, err = base64.RawURLEncoding.DecodeString(parts[1])
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(b, &claims))
+	assert.Equal(t, "issuer", claims["iss"])
+	assert.Equal(t, "appstoreconnect-v1", claims["aud"])
+	assert.LessOrEqual(t, claims["exp"].(float64)-claims["iat"].(float64), float64(20*60))
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	require.NoError(t, err)
+	require.Len(t, signature, 64)
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	r1 := new(big.Int).SetBytes(signature[:32])
+	s1 := new(big.Int).SetBytes(signature[32:])
+	assert.True(t, ecdsa.Verify(&key.PublicKey, digest[:], r1, s1))
+}
+
+func TestAppStoreSource(t *testing.T) {
+	key, pemKey := newTestAppStoreKey(t)
+
+	var replyRequest map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		checkAppStoreToken(t, r, key)
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/v1/apps/123/customerReviews":
+			assert.Equal(t, "-createdDate", r.URL.Query().Get("sort"))
+			w.Write([]byte(`{
+				"data": [{
+					"type": "customerReviews", "id": "r1",
+					"attributes": {"rating": 2, "title": "Crashes", "body": "On start", "reviewerNickname": "ann", "createdDate": "2020-01-02T10:00:00-07:00"},
+					"relationships": {"response": {"data": {"type": "customerReviewResponses", "id": "p1"}}}
+				}, {
+					"type": "customerReviews", "id": "r2",
+					"attributes": {"rating": 5, "body": "Great", "reviewerNickname": "bob", "createdDate": "2020-01-01T10:00:00Z"}
+				}],
+				"included": [{
+					"type": "customerReviewResponses", "id": "p1",
+					"attributes": {"responseBody": "Fixed", "lastModifiedDate": "2020-01-03T10:00:00Z"}
+				}]
+			}`))
+		case r.Method == http.MethodGet && r.URL.Path == "/v1/customerReviews/missing":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors": [{"title": "Not found", "detail": "No review"}]}`))
+		case r.Method == http.MethodPost && r.URL.Path == "/v1/customerReviewResponses":
+			b, _ := ioutil.ReadAll(r.Body)
+			require.NoError(t, json.Unmarshal(b, &replyRequest))
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"data": {"type": "customerReviewResponses", "id": "p2", "attributes": {"responseBody": "Thanks", "lastModifiedDate": "2020-01-04T10:00:00Z"}}}`))
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+		}
+	}))
+	defer server.Close()
+
+	source, err := newAppStoreSource(server.URL, "issuer", "KEY123", pemKey)
+	require.NoError(t, err)
+
+	reviews, err := source.ListReviews("123", "")
+	require.NoError(t, err)
+	require.Len(t, reviews, 2)
+	assert.Equal(t, "r1", reviews[0].ID)
+	assert.Equal(t, "ann", reviews[0].Author)
+	assert.Equal(t, "Crashes\tOn start", reviews[0].Text)
+	assert.Equal(t, int64(2), reviews[0].Rating)
+	assert.Equal(t, int64(1577984400), reviews[0].LastModified.Unix())
+	require.NotNil(t, reviews[0].Reply)
+	assert.Equal(t, "Fixed", reviews[0].Reply.Text)
+	assert.Equal(t, "Great", reviews[1].Text)
+	assert.Nil(t, reviews[1].Reply)
+
+	_, err = source.GetReview("123", "missing", "")
+	require.Error(t, err)
+	assert.Equal(t, http.StatusNotFound, getHTTPStatus(err))
+	assert.Contains(t, err.Error(), "No review")
+
+	result, err := source.Reply("123", "r2", "Thanks")
+	require.NoError(t, err)
+	assert.Equal(t, "Thanks", result.Text)
+	data := replyRequest["data"].(map[string]interface{})
+	assert.Equal(t, "Thanks", data["attributes"].(map[string]interface{})["responseBody"])
+	review := data["relationships"].(map[string]interface{})["review"].(map[string]interface{})["data"].(map[string]interface{})
+	assert.Equal(t, "r2", review["id"])
+}
+
+func TestNewAppStoreSourceInvalidKey(t *testing.T) {
+	_, err := newAppStoreSource(appStoreBaseURL, "issuer", "KEY123", []byte("not a key"))
+	assert.Error(t, err)
+}
+
+func TestSubmitAppStoreConnectDialogChecksUser(t *testing.T) {
+	p := newTestPlugin()
+	submit := func(userID string, request *model.SubmitDialogRequest) int {
+		r := httptest.NewRequest(http.MethodPost, "/appstore/connect/submit", strings.NewReader(string(request.ToJson())))
+		r.Header.Set("Mattermost-User-ID", userID)
+		w := httptest.NewRecorder()
+		p.submitAppStoreConnectDialog(w, r)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, submit("attacker", &model.SubmitDialogRequest{UserId: "user", State: "work"}))
+	assert.Equal(t, http.StatusUnauthorized, submit("", &model.SubmitDialogRequest{UserId: "user", State: "work"}))
+	assert.Equal(t, http.StatusBadRequest, submit("user", &model.SubmitDialogRequest{UserId: "user", State: "../other user"}))
+}
+
+func TestStoreAppStoreCredentials(t *testing.T) {
+	p := newTestPlugin()
+	newTestKVStore(p.API.(*plugintest.API))
+	p.configuration.EncryptionKey = testKeyA
+	_, privateKey := newTestAppStoreKey(t)
+
+	userID := model.NewId()
+	accountName := strings.Repeat("a", 32)
+	credentials := &AppStoreCredentials{UserID: userID, AccountName: accountName, IssuerID: "issuer", KeyID: "KEY123"}
+	require.NoError(t, p.storeAppStoreCredentials(credentials, string(privateKey)))
+	_, err := p.getAppStoreSource(userID, accountName)
+	require.NoError(t, err)
+	_, err = p.getAppStoreSource(userID, "")
+	assert.Error(t, err, "the default account is not connected")
+
+	accounts, _, err := p.getNamedAccounts(userID + AppStoreAccountsKey)
+	require.NoError(t, err)
+	assert.Equal(t, []string{accountName}, accounts)
+
+	reencrypted, failed, err := p.reencryptTokens()
+	require.NoError(t, err)
+	assert.Equal(t, 1, reencrypted, "the key rotation finds the credentials")
+	assert.Equal(t, 0, failed)
+
+	require.NoError(t, p.removeAppStoreAccount(userID, accountName))
+	_, err = p.getAppStoreSource(userID, accountName)
+	assert.Error(t, err)
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+// maxCardTextLength is the amount of characters of the review text shown on a card. Longer texts are truncated,
+// offering a button to show the full review.
+const maxCardTextLength = 300
+
+// ratingColors are the colors of the cards by star rating, from red to green. Reviews without rating are grey.
+var ratingColors = [...]string{"#8a8a8a", "#d24b4e", "#e97f3d", "#f5c342", "#8bc34a", "#3db887"}
+
+func formatStars(rating int64) string {
+	return strings.Repeat("★", int(rating)) + strings.Repeat("☆", 5-int(rating))
+}
+
+// formatRelativeTime tells how long ago the time was, or its date if it was more than a month ago
+func formatRelativeTime(t time.Time, now time.Time) string {
+	if t.IsZero() {
+		return "unknown date"
+	}
+	elapsed := now.Sub(t)
+	switch {
+	case elapsed < time.Minute:
+		return "just now"
+	case elapsed < time.Hour:
+		return formatTimeAgo(int(elapsed/time.Minute), "minute")
+	case elapsed < 24*time.Hour:
+		return formatTimeAgo(int(elapsed/time.Hour), "hour")
+	case elapsed < 30*24*time.Hour:
+		return formatTimeAgo(int(elapsed/(24*time.Hour)), "day")
+	default:
+		return "on " + t.Local().Format("Jan 2, 2006")
+	}
+}
+
+func formatTimeAgo(amount int, unit string) string {
+	if amount == 1 {
+		return fmt.Sprintf("1 %s ago", unit)
+	}
+	return fmt.Sprintf("%d %ss ago", amount, unit)
+}
+
+// truncateText cuts the text to the given amount of characters, telling if it was cut
+func truncateText(text string, maxLength int) (string, bool) {
+	runes := []rune(text)
+	if len(runes) <= maxLength {
+		return text, false
+	}
+	return strings.TrimSpace(string(runes[:maxLength])) + "…", true
+}
+
+// newReviewCard renders the review as an attachment colored by its rating. Unless full is set, long texts are
+// truncated and the card offers a button to show the full review.
+func (p *Plugin) newReviewCard(userID string, packageName string, teamLanguage string, review *Review, full bool) *model.SlackAttachment {
+	text, truncated := review.Text, false
+	if !full {
+		text, truncated = truncateText(review.Text, maxCardTextLength)
+	}
+
+	language := review.Language
+	if language == "" {
+		language = "Unknown"
+	}
+	card := &model.SlackAttachment{
+		Fallback:   fmt.Sprintf("%d-star review by %s: %s", review.getRating(), review.Author, text),
+		Color:      ratingColors[review.getRating()],
+		AuthorName: review.Author,
+		Title:      formatStars(review.getRating()),
+		Text:       text,
+		Fields: []*model.SlackAttachmentField{
+			{Title: "Version", Value: getAppVersion(review), Short: true},
+			{Title: "Device", Value: getDeviceKey(review), Short: true},
+			{Title: "OS", Value: getOSVersionKey(review), Short: true},
+			{Title: "Language", Value: language, Short: true},
+		},
+		Footer: fmt.Sprintf("ReviewId: %s · %s", review.ID, formatRelativeTime(review.LastModified, time.Now())),
+	}
+
+	if review.OriginalText != "" {
+		original, originalTruncated := review.OriginalText, false
+		if !full {
+			original, originalTruncated = truncateText(review.OriginalText, maxCardTextLength)
+		}
+		truncated = truncated || originalTruncated
+		card.Fields = append(card.Fields, &model.SlackAttachmentField{Title: fmt.Sprintf("Original text (%s)", review.Language), Value: original})
+	}
+	if len(review.Tags) > 0 {
+		card.Fields = append(card.Fields, &model.SlackAttachmentField{Title: "Tags", Value: strings.Join(review.Tags, ", "), Short: true})
+	}
+	if review.IssueURL != "" {
+		card.Fields = append(card.Fields, &model.SlackAttachmentField{Title: "Issue", Value: review.IssueURL, Short: true})
+	}
+
+	if review.OriginalText == "" && review.Language != "" && !isSameLanguage(review.Language, teamLanguage) {
+		card.Actions = append(card.Actions, p.getReviewAction("Translate", "/reviews/translate", userID, packageName, review))
+	}
+	if action := p.getCreateIssueAction(userID, packageName, review); action != nil {
+		card.Actions = append(card.Actions, action)
+	}
+	if truncated {
+		card.Actions = append(card.Actions, p.getReviewAction("Show full review", "/reviews/expand", userID, packageName, review))
+	}
+	return card
+}
+
+// getReviewCards renders the reviews of the app with the language its team reads
+func (p *Plugin) getReviewCards(userID string, packageName string, reviews []*Review) []*model.SlackAttachment {
+	packageInfo, _ := p.state.getPackage(userID, packageName)
+	teamLanguage := p.getTeamLanguage(packageInfo)
+	cards := []*model.SlackAttachment{}
+	for _, review := range reviews {
+		cards = append(cards, p.newReviewCard(userID, packageName, teamLanguage, review, false))
+	}
+	return cards
+}
+
+func (p *Plugin) getReviewAction(name string, path string, userID string, packageName string, review *Review) *model.PostAction {
+	return &model.PostAction{
+		Name: name,
+		Type: model.POST_ACTION_TYPE_BUTTON,
+		Integration: &model.PostActionIntegration{
+			URL: p.getPluginURL() + path,
+			Context: map[string]interface{}{
+				"user_id":      userID,
+				"package_name": packageName,
+				"review_id":    review.ID,
+			},
+		},
+	}
+}
+
+// sendEphemeralCard shows the card only to the user, on the channel the button was clicked
+func (p *Plugin) sendEphemeralCard(userID string, channelID string, message string, card *model.SlackAttachment) {
+	post := &model.Post{
+		UserId:    p.botUserID,
+		ChannelId: channelID,
+		Message:   message,
+	}
+	model.ParseSlackAttachment(post, []*model.SlackAttachment{card})
+	p.API.SendEphemeralPost(userID, post)
+}
+
+// expandReview answers the Show full review button with the card of the cached review without truncating it
+func (p *Plugin) expandReview(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-ID")
+	if userID == "" {
+		http.Error(w, "Not authorized", http.StatusUnauthorized)
+		return
+	}
+
+	request := model.PostActionIntegrationRequestFromJson(r.Body)
+	if request == nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if _, appErr := p.API.GetChannelMember(request.ChannelId, userID); appErr != nil {
+		writePostActionResponse(w, "Only members of the channel can read its reviews.")
+		return
+	}
+
+	ownerID, _ := request.Context["user_id"].(string)
+	reviewID, _ := request.Context["review_id"].(string)
+	packageName, review, ok := p.findCachedReview(ownerID, reviewID)
+	if !ok {
+		writePostActionResponse(w, fmt.Sprintf("Review **%s** is no longer cached.", reviewID))
+		return
+	}
+
+	packageInfo, _ := p.state.getPackage(ownerID, packageName)
+	p.sendEphemeralCard(userID, request.ChannelId, "", p.newReviewCard(ownerID, packageName, p.getTeamLanguage(packageInfo), review, true))
+	writePostActionResponse(w, "")
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatRelativeTime(t *testing.T) {
+	now := time.Date(2020, 3, 15, 12, 0, 0, 0, time.UTC)
+	assert.Equal(t, "just now", formatRelativeTime(now.Add(-30*time.Second), now))
+	assert.Equal(t, "1 minute ago", formatRelativeTime(now.Add(-time.Minute), now))
+	assert.Equal(t, "5 hours ago", formatRelativeTime(now.Add(-5*time.Hour), now))
+	assert.Equal(t, "3 days ago", formatRelativeTime(now.Add(-3*24*time.Hour), now))
+	assert.True(t, strings.HasPrefix(formatRelativeTime(now.Add(-60*24*time.Hour), now), "on Jan "))
+	assert.Equal(t, "unknown date", formatRelativeTime(time.Time{}, now))
+}
+
+func TestNewReviewCard(t *testing.T) {
+	p := newTestPlugin()
+	config := &model.Config{}
+	config.SetDefaults()
+	config.ServiceSettings.SiteURL = model.NewString("https://mm.example.com")
+	p.API.(*plugintest.API).On("GetConfig").Return(config)
+
+	review := newTestReview("long", 1, strings.Repeat("ñ", maxCardTextLength+10))
+	review.Rating = 4
+	review.AppVersionName = "1.2"
+	review.AppVersionCode = 12
+
+	card := p.newReviewCard("user", "com.example", "en", review, false)
+	assert.Equal(t, ratingColors[4], card.Color)
+	assert.Equal(t, "★★★★☆", card.Title)
+	assert.Equal(t, "Author", card.AuthorName)
+	assert.Equal(t, maxCardTextLength+1, len([]rune(card.Text)))
+	assert.Equal(t, &model.SlackAttachmentField{Title: "Version", Value: "1.2 (12)", Short: true}, card.Fields[0])
+	assert.True(t, strings.HasPrefix(card.Footer, "ReviewId: long · "))
+	require.Len(t, card.Actions, 1)
+	assert.Equal(t, "https://mm.example.com/plugins/"+manifest.Id+"/reviews/expand", card.Actions[0].Integration.URL)
+
+	card = p.newReviewCard("user", "com.example", "en", review, true)
+	assert.Equal(t, review.Text, card.Text)
+	assert.Empty(t, card.Actions)
+}
+
+func TestExpandReviewUsesRequestUser(t *testing.T) {
+	p := newTestPlugin()
+	api := p.API.(*plugintest.API)
+	api.On("GetChannelMember", "channel", "member").Return(&model.ChannelMember{}, nil)
+	api.On("GetChannelMember", "channel", "outsider").Return(nil, &model.AppError{Message: "not a member"})
+
+	request := &model.PostActionIntegrationRequest{UserId: "member", ChannelId: "channel", Context: map[string]interface{}{"user_id": "user", "review_id": "review"}}
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/reviews/expand", bytes.NewReader(request.ToJson()))
+	r.Header.Set("Mattermost-User-ID", "outsider")
+	p.expandReview(w, r)
+	response := model.PostActionIntegrationResponseFromJson(w.Body)
+	require.NotNil(t, response)
+	assert.Equal(t, "Only members of the channel can read its reviews.", response.EphemeralText)
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodPost, "/reviews/expand", bytes.NewReader(request.ToJson()))
+	p.expandReview(w, r)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
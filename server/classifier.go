@@ -0,0 +1,336 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+const (
+	sentimentPositive = "positive"
+	sentimentNegative = "negative"
+	sentimentNeutral  = "neutral"
+	// Reviews scoring beyond sentimentThreshold, on a -1 to 1 scale, are positive or negative
+	sentimentThreshold = 0.25
+	// negationScope is how many words after a negation have their sentiment inverted
+	negationScope = 3
+)
+
+// ReviewClassifier tags the reviews by sentiment and topic
+type ReviewClassifier interface {
+	// Classify returns the sentiment score of the text, from -1 to 1, and the topics it talks about
+	Classify(text string) (sentiment float64, topics []string)
+	// Topics returns all the topics the classifier can find, sorted by name
+	Topics() []string
+}
+
+// defaultTopics are the topic dictionaries of the built-in classifier. Terms ending in * match any word starting with them.
+var defaultTopics = map[string][]string{
+	"performance": {"slow", "slower", "lag*", "freez*", "hangs", "hanging", "loading", "battery", "drain*", "performance", "sluggish", "memory", "heavy"},
+	"crashes":     {"crash*", "bug", "bugs", "buggy", "error", "errors", "broken", "glitch*", "closes", "closing", "unusable"},
+	"ui":          {"ui", "ux", "interface", "design*", "layout", "button*", "screen*", "font*", "menu*", "theme*", "dark", "icon*", "navigation", "colors", "colours"},
+	"pricing":     {"price*", "pricing", "expensive", "cheap*", "subscri*", "pay", "paid", "paying", "payment*", "refund*", "cost*", "money", "premium", "ads", "purchase*"},
+	"login":       {"login", "logged", "logout", "signin", "signup", "password*", "account*", "auth*", "otp", "verification", "verify", "credentials"},
+}
+
+// sentimentLexicon weights the words expressing an opinion
+var sentimentLexicon = map[string]float64{
+	"amazing": 2, "awesome": 2, "beautiful": 2, "best": 2, "excellent": 2, "fantastic": 2, "great": 2, "love": 2,
+	"loved": 2, "loves": 2, "perfect": 2, "wonderful": 2, "cool": 1, "easy": 1, "fast": 1, "fine": 1, "good": 1,
+	"happy": 1, "helpful": 1, "intuitive": 1, "nice": 1, "recommend": 1, "reliable": 1, "smooth": 1, "thank": 1,
+	"thanks": 1, "useful": 1, "works": 1,
+	"awful": -2, "broken": -2, "buggy": -2, "crash": -2, "crashed": -2, "crashes": -2, "crashing": -2,
+	"disappointed": -2, "disappointing": -2, "frustrating": -2, "garbage": -2, "hate": -2, "horrible": -2,
+	"scam": -2, "terrible": -2, "unusable": -2, "useless": -2, "waste": -2, "worse": -2, "worst": -2,
+	"annoying": -1, "bad": -1, "bug": -1, "bugs": -1, "error": -1, "expensive": -1, "fail": -1, "failed": -1,
+	"fails": -1, "freezes": -1, "issue": -1, "issues": -1, "laggy": -1, "poor": -1, "problem": -1,
+	"problems": -1, "refund": -1, "slow": -1, "stuck": -1, "uninstall": -1, "uninstalled": -1,
+}
+
+// negations invert the sentiment of the words following them
+var negations = map[string]bool{
+	"not": true, "no": true, "never": true, "nothing": true, "dont": true, "doesnt": true, "didnt": true,
+	"isnt": true, "wasnt": true, "cant": true, "cannot": true, "wont": true, "without": true,
+}
+
+// lexiconClassifier is the built-in offline classifier. It scores the sentiment with a word lexicon and finds the
+// topics with term dictionaries, so reviews are expected to be written, or translated, in English.
+type lexiconClassifier struct {
+	topics map[string][]string
+}
+
+// newLexiconClassifier returns a classifier with the default topics, replaced or extended by the configured ones
+func newLexiconClassifier(configured map[string][]string) *lexiconClassifier {
+	topics := make(map[string][]string)
+	for topic, terms := range defaultTopics {
+		topics[topic] = terms
+	}
+	for topic, terms := range configured {
+		if len(terms) == 0 {
+			delete(topics, topic)
+			continue
+		}
+		topics[topic] = terms
+	}
+	return &lexiconClassifier{topics: topics}
+}
+
+func (c *lexiconClassifier) Classify(text string) (float64, []string) {
+	words := splitWords(text)
+
+	var score float64
+	matches := 0
+	negated := 0
+	for _, word := range words {
+		if negations[word] {
+			negated = negationScope
+			continue
+		}
+		if weight, ok := sentimentLexicon[word]; ok {
+			if negated > 0 {
+				weight = -weight
+			}
+			score += weight
+			matches++
+		}
+		if negated > 0 {
+			negated--
+		}
+	}
+	sentiment := 0.0
+	if matches > 0 {
+		sentiment = score / float64(matches) / 2
+	}
+
+	topics := []string{}
+	for _, topic := range c.Topics() {
+		if matchesAnyTerm(words, c.topics[topic]) {
+			topics = append(topics, topic)
+		}
+	}
+	return sentiment, topics
+}
+
+func (c *lexiconClassifier) Topics() []string {
+	topics := []string{}
+	for topic := range c.topics {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+func matchesAnyTerm(words []string, terms []string) bool {
+	for _, term := range terms {
+		prefix := strings.TrimSuffix(term, "*")
+		for _, word := range words {
+			if word == term || (prefix != term && strings.HasPrefix(word, prefix)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parseTopicDictionaries reads the topics configured as "topic=term1,term2;other=term3". A topic without terms removes a default one.
+func parseTopicDictionaries(dictionaries string) (map[string][]string, error) {
+	topics := make(map[string][]string)
+	for _, dictionary := range strings.Split(dictionaries, ";") {
+		if strings.TrimSpace(dictionary) == "" {
+			continue
+		}
+		parts := strings.SplitN(dictionary, "=", 2)
+		topic := strings.ToLower(strings.TrimSpace(parts[0]))
+		if len(parts) != 2 || topic == "" || strings.ContainsAny(topic, " \t") {
+			return nil, fmt.Errorf("%s is not a well formed topic dictionary. Please use topic=term1,term2", strings.TrimSpace(dictionary))
+		}
+		if isSentimentTag(topic) {
+			return nil, fmt.Errorf("%s is a sentiment and cannot be used as topic", topic)
+		}
+
+		terms := []string{}
+		for _, term := range strings.Split(parts[1], ",") {
+			if term = strings.ToLower(strings.TrimSpace(term)); term != "" {
+				terms = append(terms, term)
+			}
+		}
+		topics[topic] = terms
+	}
+	return topics, nil
+}
+
+func getSentimentTag(sentiment float64) string {
+	if sentiment >= sentimentThreshold {
+		return sentimentPositive
+	}
+	if sentiment <= -sentimentThreshold {
+		return sentimentNegative
+	}
+	return sentimentNeutral
+}
+
+func isSentimentTag(tag string) bool {
+	return tag == sentimentPositive || tag == sentimentNegative || tag == sentimentNeutral
+}
+
+// getAvailableTags returns the sentiments and the topics the classifier can tag the reviews with
+func getAvailableTags(classifier ReviewClassifier) []string {
+	return append([]string{sentimentPositive, sentimentNeutral, sentimentNegative}, classifier.Topics()...)
+}
+
+func isAvailableTag(classifier ReviewClassifier, tag string) bool {
+	for _, available := range getAvailableTags(classifier) {
+		if available == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func formatUnknownTag(classifier ReviewClassifier, tag string) string {
+	return fmt.Sprintf(":x:**%s** is not a known tag. Available tags are **%s**.", tag, strings.Join(getAvailableTags(classifier), "**, **"))
+}
+
+// getClassifier returns the classifier tagging the reviews. The built-in one uses the configured topic dictionaries.
+func (p *Plugin) getClassifier() ReviewClassifier {
+	return newLexiconClassifier(p.getConfiguration().TopicDictionaries)
+}
+
+// classifyReviews tags the reviews with their sentiment and topics. The reviews must not be shared yet.
+func classifyReviews(classifier ReviewClassifier, reviews []*Review) {
+	for _, review := range reviews {
+		sentiment, topics := classifier.Classify(review.Text)
+		review.Sentiment = sentiment
+		review.Tags = append([]string{getSentimentTag(sentiment)}, topics...)
+	}
+}
+
+// reclassifyCachedReviews tags again the cached reviews of the app when they were tagged with other topic dictionaries,
+// or before the plugin started. The alerts render the cached reviews without holding the lock, so the reviews are
+// replaced by tagged copies instead of changed, and they are tagged outside of the lock.
+func (p *Plugin) reclassifyCachedReviews(packageInfo PackageInfo, classifier ReviewClassifier, dictionaries map[string][]string) {
+	p.control.reviewsMutex.RLock()
+	classifiedWith, ok := p.classifiedWith[packageInfo.UserID][packageInfo.Name]
+	cached := append([]*Review(nil), p.localReviews[packageInfo.UserID][packageInfo.Name]...)
+	p.control.reviewsMutex.RUnlock()
+	if ok && reflect.DeepEqual(classifiedWith, dictionaries) {
+		return
+	}
+
+	tagged := make(map[*Review]*Review, len(cached))
+	for _, review := range cached {
+		reviewCopy := *review
+		tagged[review] = &reviewCopy
+		classifyReviews(classifier, []*Review{&reviewCopy})
+	}
+
+	p.control.reviewsMutex.Lock()
+	defer p.control.reviewsMutex.Unlock()
+
+	// Reviews merged or replied meanwhile are kept as they are
+	local := make([]*Review, len(p.localReviews[packageInfo.UserID][packageInfo.Name]))
+	for i, review := range p.localReviews[packageInfo.UserID][packageInfo.Name] {
+		local[i] = review
+		if reviewCopy, ok := tagged[review]; ok && reviewCopy.Reply == review.Reply && reviewCopy.IssueURL == review.IssueURL {
+			local[i] = reviewCopy
+		}
+	}
+	if len(local) > 0 {
+		p.localReviews[packageInfo.UserID][packageInfo.Name] = local
+	}
+
+	if _, ok := p.classifiedWith[packageInfo.UserID]; !ok {
+		p.classifiedWith[packageInfo.UserID] = make(map[string]map[string][]string)
+	}
+	p.classifiedWith[packageInfo.UserID][packageInfo.Name] = dictionaries
+}
+
+// getTagsReport breaks down the reviews by tag. Reviews count on every tag they have.
+func getTagsReport(packageName string, reviews []*Review, tags []string) string {
+	if len(reviews) == 0 {
+		return fmt.Sprintf("There are no reviews cached for **%s** yet.", packageName)
+	}
+
+	var stars int64
+	entries := make(map[string]*breakdownEntry)
+	for _, tag := range tags {
+		entries[tag] = &breakdownEntry{name: tag}
+	}
+	for _, review := range reviews {
+		stars += review.Rating
+		for _, tag := range review.Tags {
+			entry, ok := entries[tag]
+			if !ok {
+				continue
+			}
+			entry.count++
+			entry.stars += review.Rating
+			if review.isLowRated() {
+				entry.lowStars++
+			}
+		}
+	}
+	appAverage := float64(stars) / float64(len(reviews))
+
+	text := fmt.Sprintf("## Tags report for **%s** (**%d** reviews, **%.2f** average stars):\n", packageName, len(reviews), appAverage)
+	text += "| Tag | Reviews | Share | 1-2 star reviews | Average stars |\n|:--|:--|:--|:--|:--|\n"
+	for _, tag := range tags {
+		entry := entries[tag]
+		if entry.count == 0 {
+			text += fmt.Sprintf("| %s | 0 | 0%% | 0 | - |\n", tag)
+			continue
+		}
+		text += fmt.Sprintf("| %s | %d | %.0f%% | %d | %.2f |\n", tag, entry.count, 100*float64(entry.count)/float64(len(reviews)), entry.lowStars, entry.averageStars())
+	}
+	return text
+}
+
+// hasTag checks if the review was tagged with the tag. An empty tag matches all the reviews.
+func (r *Review) hasTag(tag string) bool {
+	if tag == "" {
+		return true
+	}
+	for _, reviewTag := range r.Tags {
+		if reviewTag == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// filterReviewsByTag returns the reviews tagged with the tag
+func filterReviewsByTag(reviews []*Review, tag string) []*Review {
+	filtered := []*Review{}
+	for _, review := range reviews {
+		if review.hasTag(tag) {
+			filtered = append(filtered, review)
+		}
+	}
+	return filtered
+}
+
+func (p *Plugin) serveTags(args []string, userID string) (*model.CommandResponse, *model.AppError) {
+	var message string
+
+	if len(args) != 3 {
+		message += fmt.Sprintf(":x:Wrong use: `%s %s packageName_or_alias`", args[0], args[1])
+		return commandErrorResponse(message)
+	}
+
+	packageName, ok := p.state.resolvePackageName(args[2], userID)
+	if !ok {
+		message += fmt.Sprintf(":x:Package **%s** is not yet registered.", args[2])
+		return commandErrorResponse(message)
+	}
+
+	tags := getAvailableTags(p.getClassifier())
+
+	p.control.reviewsMutex.RLock()
+	defer p.control.reviewsMutex.RUnlock()
+
+	message += getTagsReport(packageName, p.localReviews[userID][packageName], tags)
+	return commandStatusResponse(message)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLexiconClassifier(t *testing.T) {
+	classifier := newLexiconClassifier(nil)
+
+	sentiment, topics := classifier.Classify("Great app, I love the new dark theme!")
+	assert.Equal(t, sentimentPositive, getSentimentTag(sentiment))
+	assert.Equal(t, []string{"ui"}, topics)
+
+	sentiment, topics = classifier.Classify("It crashes after login and the subscription is too expensive")
+	assert.Equal(t, sentimentNegative, getSentimentTag(sentiment))
+	assert.Equal(t, []string{"crashes", "login", "pricing"}, topics)
+
+	sentiment, _ = classifier.Classify("Not good, not useful at all")
+	assert.Equal(t, sentimentNegative, getSentimentTag(sentiment))
+
+	sentiment, topics = classifier.Classify("Ok")
+	assert.Equal(t, sentimentNeutral, getSentimentTag(sentiment))
+	assert.Empty(t, topics)
+}
+
+func TestParseTopicDictionaries(t *testing.T) {
+	topics, err := parseTopicDictionaries(" Sync = sync*, Offline ;login=")
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]string{"sync": {"sync*", "offline"}, "login": {}}, topics)
+
+	classifier := newLexiconClassifier(topics)
+	assert.Equal(t, []string{"crashes", "performance", "pricing", "sync", "ui"}, classifier.Topics())
+	_, found := classifier.Classify("It never synchronizes when offline, and I have to login again")
+	assert.Equal(t, []string{"sync"}, found)
+
+	_, err = parseTopicDictionaries("sync")
+	assert.Error(t, err)
+	_, err = parseTopicDictionaries("negative=bad")
+	assert.Error(t, err)
+}
+
+func TestNewReviewsAlertTag(t *testing.T) {
+	p := newTestPlugin()
+	p.state.addPackage(PackageInfo{Name: "com.example", UserID: "user"})
+	alert := &NewReviewsAlert{Alert: Alert{PackageName: "com.example"}, Tag: "crashes"}
+	p.state.editAlerts(func(alerts *AlertsContainer) {
+		alerts.NewReviewsAlerts["user"] = map[string]*NewReviewsAlert{"crashes": alert}
+	})
+
+	crash := newTestReview("crash", 2, "It crashes on start")
+	praise := newTestReview("praise", 1, "Works great")
+	p.storeReviews(PackageInfo{Name: "com.example", UserID: "user"}, []*Review{crash, praise})
+
+	assert.Equal(t, []string{sentimentNegative, "crashes"}, crash.Tags)
+	assert.Equal(t, []string{sentimentPositive}, praise.Tags)
+	assert.Equal(t, []*Review{crash}, alert.newReviews)
+}
+
+func TestReclassifyCachedReviews(t *testing.T) {
+	p := newTestPlugin()
+	packageInfo := PackageInfo{Name: "com.example", UserID: "user"}
+	cached := newTestReview("cached", 1, "It never syncs")
+	p.storeReviews(packageInfo, []*Review{cached})
+	assert.Equal(t, []string{sentimentNeutral}, cached.Tags)
+
+	p.configuration.TopicDictionaries = "sync=sync*"
+	p.storeReviews(packageInfo, []*Review{newTestReview("new", 2, "Sync is slow")})
+
+	// The alerts may still be rendering the previous review, so it is replaced instead of changed
+	assert.Equal(t, []string{sentimentNeutral}, cached.Tags)
+	reviews := p.localReviews["user"]["com.example"]
+	require.Len(t, reviews, 2)
+	assert.Contains(t, reviews[0].Tags, "sync")
+	assert.Equal(t, "cached", reviews[1].ID)
+	assert.Equal(t, []string{sentimentNeutral, "sync"}, reviews[1].Tags)
+}
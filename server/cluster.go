@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+const (
+	leaderLeaseKey = "cluster_leader_lease"
+	// leaderLeaseTime is how long a node keeps the leadership without renewing it
+	leaderLeaseTime = 30 * time.Second
+	// leaderRenewTime is how often the leader renews the lease, and the other nodes try to take it
+	leaderRenewTime = 10 * time.Second
+	// refreshRequestsKey stores the apps asked to refresh on the other nodes, until the leader takes them
+	refreshRequestsKey = "cluster_refresh_requests"
+	// maxRefreshRequestAttempts is how many times a node tries to add its request while others change the key
+	maxRefreshRequestAttempts = 5
+)
+
+// leaderLease is stored on the KV store by the node running the background jobs.
+// The expiry is kept on the value instead of using the KV store expiry, because
+// an expired key still blocks the atomic insert until the server cleans it up.
+type leaderLease struct {
+	NodeID    string
+	ExpiresAt int64
+}
+
+// leaderElector makes sure only one node of the cluster syncs reviews and sends alerts
+type leaderElector struct {
+	nodeID string
+	leader int32
+}
+
+func newLeaderElector() *leaderElector {
+	return &leaderElector{
+		nodeID: model.NewId(),
+	}
+}
+
+func (p *Plugin) isLeader() bool {
+	return atomic.LoadInt32(&p.elector.leader) == 1
+}
+
+func (p *Plugin) setLeader(leader bool) {
+	value := int32(0)
+	if leader {
+		value = 1
+	}
+	if atomic.SwapInt32(&p.elector.leader, value) != value {
+		if leader {
+			p.logInfo("This node is now running the background jobs", "node", p.elector.nodeID)
+		} else {
+			p.logInfo("This node stopped running the background jobs", "node", p.elector.nodeID)
+		}
+	}
+}
+
+// watchLeadership keeps acquiring or renewing the lease, so another node takes over when the leader stops renewing it
+func (p *Plugin) watchLeadership() {
+	for {
+		leader, err := p.acquireLeaderLease()
+		if err != nil {
+			p.logError("Error acquiring the leader lease", "node", p.elector.nodeID, "err", err.Error())
+		}
+		if leader && !p.isLeader() {
+			// The previous leader kept syncing after this node loaded the reviews
+			p.LoadAll()
+		}
+		p.setLeader(leader)
+		time.Sleep(leaderRenewTime)
+	}
+}
+
+// acquireLeaderLease takes the lease if it is free, expired or already ours, extending its expiry
+func (p *Plugin) acquireLeaderLease() (bool, *model.AppError) {
+	now := time.Now()
+	newLease, _ := json.Marshal(leaderLease{
+		NodeID:    p.elector.nodeID,
+		ExpiresAt: now.Add(leaderLeaseTime).Unix(),
+	})
+
+	current, appErr := p.API.KVGet(leaderLeaseKey)
+	if appErr != nil {
+		return false, appErr
+	}
+
+	if current == nil {
+		return p.API.KVSetWithOptions(leaderLeaseKey, newLease, model.PluginKVSetOptions{Atomic: true, OldValue: nil})
+	}
+
+	var lease leaderLease
+	if err := json.Unmarshal(current, &lease); err == nil && lease.NodeID != p.elector.nodeID && lease.ExpiresAt > now.Unix() {
+		return false, nil
+	}
+
+	return p.API.KVCompareAndSet(leaderLeaseKey, current, newLease)
+}
+
+// releaseLeaderLease frees the lease held by this node, so another node takes over without waiting for it to expire
+func (p *Plugin) releaseLeaderLease() {
+	if !p.isLeader() {
+		return
+	}
+	p.setLeader(false)
+
+	current, appErr := p.API.KVGet(leaderLeaseKey)
+	if appErr != nil || current == nil {
+		return
+	}
+	var lease leaderLease
+	if err := json.Unmarshal(current, &lease); err != nil || lease.NodeID != p.elector.nodeID {
+		return
+	}
+	if _, appErr := p.API.KVCompareAndDelete(leaderLeaseKey, current); appErr != nil {
+		p.logError("Error releasing the leader lease", "node", p.elector.nodeID, "err", appErr.Error())
+	}
+}
+
+// requestRefresh makes the apps due to sync now. The leader triggers them directly, the other nodes leave them on
+// the KV store for the leader to take on its next tick.
+func (p *Plugin) requestRefresh(keys []string) error {
+	if p.isLeader() {
+		p.scheduler.trigger(keys)
+		return nil
+	}
+
+	for attempt := 0; attempt < maxRefreshRequestAttempts; attempt++ {
+		current, appErr := p.API.KVGet(refreshRequestsKey)
+		if appErr != nil {
+			return appErr
+		}
+		requested := []string{}
+		if current != nil {
+			if err := json.Unmarshal(current, &requested); err != nil {
+				p.logWarn("Discarding malformed refresh requests", "err", err.Error())
+				requested = []string{}
+			}
+		}
+
+		b, _ := json.Marshal(append(requested, keys...))
+		ok, appErr := p.API.KVSetWithOptions(refreshRequestsKey, b, model.PluginKVSetOptions{Atomic: true, OldValue: current})
+		if appErr != nil {
+			return appErr
+		}
+		if ok {
+			return nil
+		}
+	}
+	return errors.New("other nodes kept changing the refresh requests")
+}
+
+// takeRefreshRequests removes and returns the apps the other nodes asked to refresh
+func (p *Plugin) takeRefreshRequests() []string {
+	current, appErr := p.API.KVGet(refreshRequestsKey)
+	if appErr != nil {
+		p.logError("Error getting the refresh requests", "err", appErr.Error())
+		return nil
+	}
+	if current == nil {
+		return nil
+	}
+
+	// If another node added a request meanwhile, they are taken on the next tick
+	if ok, appErr := p.API.KVCompareAndDelete(refreshRequestsKey, current); appErr != nil || !ok {
+		return nil
+	}
+
+	keys := []string{}
+	if err := json.Unmarshal(current, &keys); err != nil {
+		p.logWarn("Discarding malformed refresh requests", "err", err.Error())
+		return nil
+	}
+	return keys
+}
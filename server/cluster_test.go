@@ -0,0 +1,104 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestClusterNode creates a node of a cluster whose nodes share the API, and so its KV store
+func newTestClusterNode(api *plugintest.API) *Plugin {
+	p := newTestPlugin()
+	p.API = api
+	p.persistency = newKVPersistency(api)
+	p.persistency.Init(p)
+	return p
+}
+
+func TestSharedStateAcrossNodes(t *testing.T) {
+	api := &plugintest.API{}
+	newTestKVStore(api)
+	follower := newTestClusterNode(api)
+	leader := newTestClusterNode(api)
+	leader.setLeader(true)
+
+	run := func(p *Plugin, command string) string {
+		response, appErr := p.ExecuteCommand(nil, &model.CommandArgs{Command: command, UserId: "user", ChannelId: "channel"})
+		require.Nil(t, appErr)
+		return response.Text
+	}
+
+	packageInfo := PackageInfo{Name: "com.example", UserID: "user"}
+	follower.state.addPackage(packageInfo)
+	follower.SavePackages()
+	assert.Contains(t, run(follower, "/gpreviews add alert newReviews alert https://example.com com.example 60"), ":white_check_mark:")
+
+	leader.loadSharedState()
+	assert.True(t, leader.state.hasPackage(packageInfo), "the leader syncs the apps added on other nodes")
+	alert, ok := leader.state.getAlerts().NewReviewsAlerts["user"]["alert"]
+	require.True(t, ok, "the leader sends the alerts added on other nodes")
+
+	leader.storeReviews(packageInfo, []*Review{newTestReview("review", 1, "It crashes")})
+	leader.loadSharedState()
+	assert.Equal(t, alert, leader.state.getAlerts().NewReviewsAlerts["user"]["alert"], "reloading keeps the pending reviews of the alerts")
+	assert.Len(t, alert.newReviews, 1)
+
+	assert.Contains(t, run(follower, "/gpreviews status"), "| com.example | Never | None | Never | 1 |", "other nodes show the reviews synced by the leader")
+	assert.Contains(t, run(follower, "/gpreviews refresh com.example"), ":arrows_counterclockwise:")
+	assert.Equal(t, []string{"user/com.example"}, leader.takeRefreshRequests())
+
+	assert.Contains(t, run(follower, "/gpreviews remove alert newReviews alert"), ":white_check_mark:")
+	leader.loadSharedState()
+	assert.Empty(t, leader.state.getAlerts().NewReviewsAlerts["user"], "alerts removed on other nodes are not sent")
+}
+
+func TestSetPollIntervalOnFollower(t *testing.T) {
+	api := &plugintest.API{}
+	newTestKVStore(api)
+	follower := newTestClusterNode(api)
+	leader := newTestClusterNode(api)
+	leader.setLeader(true)
+
+	follower.state.addPackage(PackageInfo{Name: "com.example", UserID: "user"})
+	follower.SavePackages()
+	response, appErr := follower.ExecuteCommand(nil, &model.CommandArgs{Command: "/gpreviews set interval com.example 60", UserId: "user", ChannelId: "channel"})
+	require.Nil(t, appErr)
+	assert.Contains(t, response.Text, ":white_check_mark:")
+
+	leader.loadSharedState()
+	packageInfo, ok := leader.state.getPackage("user", "com.example")
+	require.True(t, ok)
+	assert.Equal(t, int64(60), packageInfo.PollInterval)
+	assert.Equal(t, []string{"user/com.example"}, leader.takeRefreshRequests(), "the leader applies the new interval now")
+}
+
+func TestChangesOnSeveralNodes(t *testing.T) {
+	api := &plugintest.API{}
+	newTestKVStore(api)
+	follower := newTestClusterNode(api)
+	leader := newTestClusterNode(api)
+
+	follower.state.addPackage(PackageInfo{Name: "com.example", UserID: "user"})
+	require.NoError(t, follower.SavePackages())
+
+	attempts := 0
+	err := leader.updateSharedState(leader.LoadSLAs, leader.SaveSLAs, func() {
+		attempts++
+		if attempts == 1 {
+			// The follower stores its rule after the leader loaded the rules and before it stores its own
+			response, appErr := follower.ExecuteCommand(nil, &model.CommandArgs{Command: "/gpreviews add sla follower https://example.com com.example 1 60", UserId: "user", ChannelId: "channel"})
+			require.Nil(t, appErr)
+			require.Contains(t, response.Text, ":white_check_mark:")
+		}
+		leader.state.addSLA("user", "leader", &SLARule{PackageName: "com.example", MinStars: 1, MaxStars: 1, Hours: 60})
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 2, attempts, "the leader applies its change again on the rules of the follower")
+
+	follower.loadSharedState()
+	assert.Contains(t, follower.state.getAllSLAs()["user"], "follower")
+	assert.Contains(t, follower.state.getAllSLAs()["user"], "leader", "the rule stored by the leader keeps the one of the follower")
+}
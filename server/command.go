@@ -2,7 +2,9 @@ package main
 
 import (
 	"fmt"
+	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/mattermost/mattermost-server/v5/model"
@@ -11,19 +13,23 @@ import (
 func (p *Plugin) removeNewReviewsAlert(args []string, userID string) (*model.CommandResponse, *model.AppError) {
 	var message string
 
-	alertName := args[4]
-
 	if len(args) != 5 {
 		message += fmt.Sprintf(":x:Wrong use: `%s %s %s %s unique_name`.", args[0], args[1], args[2], args[3])
 		return commandErrorResponse(message)
 	}
-	if _, ok := p.alerts.NewReviewsAlerts[userID][alertName]; !ok {
+	alertName := args[4]
+
+	found := false
+	p.updateSharedState(p.LoadAlerts, p.SaveAlerts, func() {
+		p.state.editAlerts(func(alerts *AlertsContainer) {
+			_, found = alerts.NewReviewsAlerts[userID][alertName]
+			delete(alerts.NewReviewsAlerts[userID], alertName)
+		})
+	})
+	if !found {
 		message += fmt.Sprintf(":x:There no alert named **%s**.", alertName)
 		return commandErrorResponse(message)
 	}
-
-	delete(p.alerts.NewReviewsAlerts[userID], alertName)
-	p.SaveAlerts()
 	message += fmt.Sprintf(":white_check_mark:Alert **%s** removed.", alertName)
 	return commandStatusResponse(message)
 }
@@ -32,8 +38,12 @@ func (p *Plugin) serveListNewReviewsAlerts(args []string, userID string) (*model
 	var message string
 
 	message += "## Here are all the alerts you have registered:\n"
-	for k, v := range p.alerts.NewReviewsAlerts[userID] {
-		message += fmt.Sprintf("* Alert **\"%s\"**: From package **%s** every **%v seconds** at most on webhook **%s**\n", k, v.PackageName, v.Frequency, v.Webhook)
+	for k, v := range p.state.getAlerts().NewReviewsAlerts[userID] {
+		message += fmt.Sprintf("* Alert **\"%s\"**: From package **%s**", k, v.PackageName)
+		if v.Tag != "" {
+			message += fmt.Sprintf(" tagged **%s**", v.Tag)
+		}
+		message += fmt.Sprintf(" every **%v seconds** at most on webhook **%s**\n", v.Frequency, v.Webhook)
 	}
 	return commandStatusResponse(message)
 }
@@ -41,6 +51,106 @@ func (p *Plugin) serveListNewReviewsAlerts(args []string, userID string) (*model
 func (p *Plugin) addNewReviewsAlert(args []string, userID string) (*model.CommandResponse, *model.AppError) {
 	var message string
 
+	if (len(args) != 8 && len(args) != 10) || (len(args) == 10 && args[8] != "--tag") {
+		message += fmt.Sprintf(":x:Wrong use: `%s %s %s %s unique_name webhook packageName_or_alias minimum_frequency_in_seconds [--tag tag]`", args[0], args[1], args[2], args[3])
+		return commandErrorResponse(message)
+	}
+	uniqueName := args[4]
+	webhook := args[5]
+	packageNameOrAlias := args[6]
+	minimumFrequency := args[7]
+	tag := ""
+	if len(args) == 10 {
+		tag = strings.ToLower(args[9])
+	}
+
+	packageName, ok := p.state.resolvePackageName(packageNameOrAlias, userID)
+	if !ok {
+		message += fmt.Sprintf(":x:Package **%s** is not yet registered.", packageNameOrAlias)
+		return commandErrorResponse(message)
+	}
+
+	frequency, err := strconv.ParseInt(minimumFrequency, 10, 64)
+	if err != nil || frequency <= 0 {
+		message += fmt.Sprintf(":x:**%s** is not a well formed frequency. Please use a positive number.", minimumFrequency)
+		return commandErrorResponse(message)
+	}
+
+	if tag != "" && !isAvailableTag(p.getClassifier(), tag) {
+		message += formatUnknownTag(p.getClassifier(), tag)
+		return commandErrorResponse(message)
+	}
+
+	alert := &NewReviewsAlert{
+		Alert: Alert{
+			Webhook:     webhook,
+			PackageName: packageName,
+			Frequency:   frequency,
+			lastAlerted: time.Now(),
+		},
+		Tag: tag,
+	}
+
+	added := false
+	p.updateSharedState(p.LoadAlerts, p.SaveAlerts, func() {
+		added = false
+		p.state.editAlerts(func(alerts *AlertsContainer) {
+			if _, ok := alerts.NewReviewsAlerts[userID][uniqueName]; ok {
+				return
+			}
+			if _, ok := alerts.NewReviewsAlerts[userID]; !ok {
+				alerts.NewReviewsAlerts[userID] = make(map[string]*NewReviewsAlert)
+			}
+			alerts.NewReviewsAlerts[userID][uniqueName] = alert
+			added = true
+		})
+	})
+	if !added {
+		message += fmt.Sprintf(":x:There is already an alert named **%s**.", uniqueName)
+		return commandErrorResponse(message)
+	}
+
+	message += fmt.Sprintf(":white_check_mark:Alert **%s** registered.", uniqueName)
+	return commandStatusResponse(message)
+}
+
+func (p *Plugin) removeNewVersionAlert(args []string, userID string) (*model.CommandResponse, *model.AppError) {
+	var message string
+
+	if len(args) != 5 {
+		message += fmt.Sprintf(":x:Wrong use: `%s %s %s %s unique_name`.", args[0], args[1], args[2], args[3])
+		return commandErrorResponse(message)
+	}
+	alertName := args[4]
+
+	found := false
+	p.updateSharedState(p.LoadAlerts, p.SaveAlerts, func() {
+		p.state.editAlerts(func(alerts *AlertsContainer) {
+			_, found = alerts.NewVersionAlerts[userID][alertName]
+			delete(alerts.NewVersionAlerts[userID], alertName)
+		})
+	})
+	if !found {
+		message += fmt.Sprintf(":x:There no alert named **%s**.", alertName)
+		return commandErrorResponse(message)
+	}
+	message += fmt.Sprintf(":white_check_mark:Alert **%s** removed.", alertName)
+	return commandStatusResponse(message)
+}
+
+func (p *Plugin) serveListNewVersionAlerts(args []string, userID string) (*model.CommandResponse, *model.AppError) {
+	var message string
+
+	message += "## Here are all the new version alerts you have registered:\n"
+	for k, v := range p.state.getAlerts().NewVersionAlerts[userID] {
+		message += fmt.Sprintf("* Alert **\"%s\"**: From package **%s** every **%v seconds** at most on webhook **%s**\n", k, v.PackageName, v.Frequency, v.Webhook)
+	}
+	return commandStatusResponse(message)
+}
+
+func (p *Plugin) addNewVersionAlert(args []string, userID string) (*model.CommandResponse, *model.AppError) {
+	var message string
+
 	if len(args) != 8 {
 		message += fmt.Sprintf(":x:Wrong use: `%s %s %s %s unique_name webhook packageName_or_alias minimum_frequency_in_seconds`", args[0], args[1], args[2], args[3])
 		return commandErrorResponse(message)
@@ -50,12 +160,640 @@ func (p *Plugin) addNewReviewsAlert(args []string, userID string) (*model.Comman
 	packageNameOrAlias := args[6]
 	minimumFrequency := args[7]
 
-	if _, ok := p.alerts.NewReviewsAlerts[userID][uniqueName]; ok {
+	packageName, ok := p.state.resolvePackageName(packageNameOrAlias, userID)
+	if !ok {
+		message += fmt.Sprintf(":x:Package **%s** is not yet registered.", packageNameOrAlias)
+		return commandErrorResponse(message)
+	}
+
+	frequency, err := strconv.ParseInt(minimumFrequency, 10, 64)
+	if err != nil || frequency <= 0 {
+		message += fmt.Sprintf(":x:**%s** is not a well formed frequency. Please use a positive number.", minimumFrequency)
+		return commandErrorResponse(message)
+	}
+
+	alert := &NewVersionAlert{
+		Alert: Alert{
+			Webhook:     webhook,
+			PackageName: packageName,
+			Frequency:   frequency,
+			lastAlerted: time.Now(),
+		},
+	}
+
+	added := false
+	p.updateSharedState(p.LoadAlerts, p.SaveAlerts, func() {
+		added = false
+		p.state.editAlerts(func(alerts *AlertsContainer) {
+			if _, ok := alerts.NewVersionAlerts[userID][uniqueName]; ok {
+				return
+			}
+			if _, ok := alerts.NewVersionAlerts[userID]; !ok {
+				alerts.NewVersionAlerts[userID] = make(map[string]*NewVersionAlert)
+			}
+			alerts.NewVersionAlerts[userID][uniqueName] = alert
+			added = true
+		})
+	})
+	if !added {
 		message += fmt.Sprintf(":x:There is already an alert named **%s**.", uniqueName)
 		return commandErrorResponse(message)
 	}
 
-	packageName, ok := getPackageNameFromArgs(packageNameOrAlias, userID, p.packageList, p.aliases[userID])
+	message += fmt.Sprintf(":white_check_mark:Alert **%s** registered.", uniqueName)
+	return commandStatusResponse(message)
+}
+
+func (p *Plugin) serveCompare(args []string, userID string) (*model.CommandResponse, *model.AppError) {
+	var message string
+
+	if len(args) != 5 {
+		message += fmt.Sprintf(":x:Wrong use: `%s %s packageName_or_alias versionA versionB`. Versions can be given by code or by name.", args[0], args[1])
+		return commandErrorResponse(message)
+	}
+
+	packageName, ok := p.state.resolvePackageName(args[2], userID)
+	if !ok {
+		message += fmt.Sprintf(":x:Package **%s** is not yet registered.", args[2])
+		return commandErrorResponse(message)
+	}
+
+	p.control.reviewsMutex.RLock()
+	defer p.control.reviewsMutex.RUnlock()
+
+	message += compareVersions(packageName, p.localReviews[userID][packageName], args[3], args[4])
+	return commandStatusResponse(message)
+}
+
+func (p *Plugin) serveDevices(args []string, userID string) (*model.CommandResponse, *model.AppError) {
+	var message string
+
+	if len(args) != 3 {
+		message += fmt.Sprintf(":x:Wrong use: `%s %s packageName_or_alias`", args[0], args[1])
+		return commandErrorResponse(message)
+	}
+
+	packageName, ok := p.state.resolvePackageName(args[2], userID)
+	if !ok {
+		message += fmt.Sprintf(":x:Package **%s** is not yet registered.", args[2])
+		return commandErrorResponse(message)
+	}
+
+	p.control.reviewsMutex.RLock()
+	defer p.control.reviewsMutex.RUnlock()
+
+	message += getDevicesReport(packageName, p.localReviews[userID][packageName])
+	return commandStatusResponse(message)
+}
+
+func (p *Plugin) serveStatus(args []string, userID string) (*model.CommandResponse, *model.AppError) {
+	return commandStatusResponse(p.getSyncStatusReport(userID))
+}
+
+func (p *Plugin) refresh(args []string, userID string) (*model.CommandResponse, *model.AppError) {
+	var message string
+
+	if len(args) > 3 {
+		message += fmt.Sprintf(":x:Wrong use: `%s %s [packageName_or_alias]`", args[0], args[1])
+		return commandErrorResponse(message)
+	}
+
+	keys := []string{}
+	if len(args) == 3 {
+		packageName, ok := p.state.resolvePackageName(args[2], userID)
+		if !ok {
+			message += fmt.Sprintf(":x:Package **%s** is not yet registered.", args[2])
+			return commandErrorResponse(message)
+		}
+		keys = append(keys, getPackageKey(userID, packageName))
+	} else {
+		for _, packageInfo := range p.state.getUserPackages(userID) {
+			keys = append(keys, getPackageKey(userID, packageInfo.Name))
+		}
+	}
+
+	if err := p.requestRefresh(keys); err != nil {
+		message += fmt.Sprintf(":x:Error refreshing the apps: **%s**", err.Error())
+		return commandErrorResponse(message)
+	}
+
+	message += fmt.Sprintf(":arrows_counterclockwise:Refreshing **%d** apps. Check the result with `%s status`.", len(keys), args[0])
+	return commandStatusResponse(message)
+}
+
+func (p *Plugin) setPollInterval(args []string, userID string) (*model.CommandResponse, *model.AppError) {
+	var message string
+
+	if len(args) != 5 {
+		message += fmt.Sprintf(":x:Wrong use: `%s %s %s packageName_or_alias seconds`. Use 0 seconds to go back to the configured refresh time.", args[0], args[1], args[2])
+		return commandErrorResponse(message)
+	}
+
+	packageName, ok := p.state.resolvePackageName(args[3], userID)
+	if !ok {
+		message += fmt.Sprintf(":x:Package **%s** is not yet registered.", args[3])
+		return commandErrorResponse(message)
+	}
+
+	interval, err := strconv.ParseInt(args[4], 10, 64)
+	if err != nil || (interval != 0 && interval < 15) {
+		message += fmt.Sprintf(":x:**%s** is not a well formed interval. Please use at least 15 seconds, or 0 to use the configured refresh time.", args[4])
+		return commandErrorResponse(message)
+	}
+
+	p.updateSharedState(p.LoadPackages, p.SavePackages, func() {
+		p.state.setPollInterval(userID, packageName, interval)
+	})
+	// The leader syncs the app now, scheduling the next sync with the new interval
+	if err := p.requestRefresh([]string{getPackageKey(userID, packageName)}); err != nil {
+		p.logWarn("Error requesting the sync of the app, the interval is used after the next one", "userID", userID, "package", packageName, "err", err.Error())
+	}
+
+	message += fmt.Sprintf(":white_check_mark:Reviews of **%s** will be fetched every **%s**.", packageName, p.getPollInterval(PackageInfo{Name: packageName, UserID: userID, PollInterval: interval}))
+	return commandStatusResponse(message)
+}
+
+func (p *Plugin) addSLA(args []string, userID string) (*model.CommandResponse, *model.AppError) {
+	var message string
+
+	if len(args) != 8 && len(args) != 9 {
+		message += fmt.Sprintf(":x:Wrong use: `%s %s %s unique_name webhook packageName_or_alias stars hours [warning_hours]`", args[0], args[1], args[2])
+		return commandErrorResponse(message)
+	}
+	uniqueName := args[3]
+	webhook := args[4]
+	packageNameOrAlias := args[5]
+	starRange := args[6]
+	replyHours := args[7]
+
+	packageName, ok := p.state.resolvePackageName(packageNameOrAlias, userID)
+	if !ok {
+		message += fmt.Sprintf(":x:Package **%s** is not yet registered.", packageNameOrAlias)
+		return commandErrorResponse(message)
+	}
+
+	minStars, maxStars, ok := parseStarRange(starRange)
+	if !ok {
+		message += fmt.Sprintf(":x:**%s** is not a well formed star rating. Please use a rating (`1`) or a range (`1-2`) between 1 and 5.", starRange)
+		return commandErrorResponse(message)
+	}
+
+	hours, err := strconv.ParseInt(replyHours, 10, 64)
+	if err != nil || hours <= 0 {
+		message += fmt.Sprintf(":x:**%s** is not a well formed amount of hours. Please use a positive number.", replyHours)
+		return commandErrorResponse(message)
+	}
+
+	// By default, warn when a quarter of the time is left
+	warningHours := (hours + 3) / 4
+	if len(args) == 9 {
+		warningHours, err = strconv.ParseInt(args[8], 10, 64)
+		if err != nil || warningHours < 0 || warningHours >= hours {
+			message += fmt.Sprintf(":x:**%s** is not a well formed amount of warning hours. Please use a positive number lower than the SLA hours.", args[8])
+			return commandErrorResponse(message)
+		}
+	}
+
+	rule := &SLARule{
+		Webhook:      webhook,
+		PackageName:  packageName,
+		MinStars:     minStars,
+		MaxStars:     maxStars,
+		Hours:        hours,
+		WarningHours: warningHours,
+		Escalations:  make(map[string]int),
+	}
+	added := false
+	p.updateSharedState(p.LoadSLAs, p.SaveSLAs, func() {
+		added = p.state.addSLA(userID, uniqueName, rule)
+	})
+	if !added {
+		message += fmt.Sprintf(":x:There is already an SLA named **%s**.", uniqueName)
+		return commandErrorResponse(message)
+	}
+
+	message += fmt.Sprintf(":white_check_mark:SLA **%s** registered.", uniqueName)
+	return commandStatusResponse(message)
+}
+
+func (p *Plugin) removeSLA(args []string, userID string) (*model.CommandResponse, *model.AppError) {
+	var message string
+
+	if len(args) != 4 {
+		message += fmt.Sprintf(":x:Wrong use: `%s %s %s unique_name`.", args[0], args[1], args[2])
+		return commandErrorResponse(message)
+	}
+	slaName := args[3]
+
+	removed := false
+	p.updateSharedState(p.LoadSLAs, p.SaveSLAs, func() {
+		removed = p.state.removeSLA(userID, slaName)
+	})
+	if !removed {
+		message += fmt.Sprintf(":x:There no SLA named **%s**.", slaName)
+		return commandErrorResponse(message)
+	}
+	message += fmt.Sprintf(":white_check_mark:SLA **%s** removed.", slaName)
+	return commandStatusResponse(message)
+}
+
+func (p *Plugin) serveListSLAs(args []string, userID string) (*model.CommandResponse, *model.AppError) {
+	var message string
+
+	message += "## Here are all the SLAs you have registered:\n"
+	for k, v := range p.state.getSLAs(userID) {
+		message += fmt.Sprintf("* SLA **\"%s\"**: Reply to %s reviews from package **%s** within **%d hours**, warning **%d hours** before, on webhook **%s**\n", k, formatStarRange(v.MinStars, v.MaxStars), v.PackageName, v.Hours, v.WarningHours, v.Webhook)
+	}
+	return commandStatusResponse(message)
+}
+
+func (p *Plugin) serveSLAReport(args []string, userID string) (*model.CommandResponse, *model.AppError) {
+	var message string
+
+	if len(args) > 4 {
+		message += fmt.Sprintf(":x:Wrong use: `%s %s %s [packageName_or_alias]`", args[0], args[1], args[2])
+		return commandErrorResponse(message)
+	}
+
+	packageName := ""
+	if len(args) == 4 {
+		var ok bool
+		packageName, ok = p.state.resolvePackageName(args[3], userID)
+		if !ok {
+			message += fmt.Sprintf(":x:Package **%s** is not yet registered.", args[3])
+			return commandErrorResponse(message)
+		}
+	}
+
+	p.control.reviewsMutex.RLock()
+	defer p.control.reviewsMutex.RUnlock()
+
+	now := time.Now()
+	message += "## SLA report over the cached reviews:\n"
+	for k, v := range p.state.getSLAs(userID) {
+		if packageName != "" && v.PackageName != packageName {
+			continue
+		}
+		message += getSLAReport(k, v, p.localReviews[userID][v.PackageName], now)
+	}
+	return commandStatusResponse(message)
+}
+
+func (p *Plugin) addTemplate(args []string, userID string) (*model.CommandResponse, *model.AppError) {
+	var message string
+
+	if len(args) < 5 {
+		message += fmt.Sprintf(":x:Wrong use: `%s %s %s unique_name template_text`", args[0], args[1], args[2])
+		return commandErrorResponse(message)
+	}
+	uniqueName := args[3]
+	text := strings.Join(args[4:], " ")
+
+	if err := validateReplyTemplate(uniqueName, text); err != nil {
+		message += fmt.Sprintf(":x:Template **%s** is not valid: **%s**. Available placeholders are `{{author}}`, `{{app}}`, `{{version}}` and `{{stars}}`.", uniqueName, err.Error())
+		return commandErrorResponse(message)
+	}
+
+	added := false
+	p.updateSharedState(p.LoadTemplates, p.SaveTemplates, func() {
+		added = p.state.addTemplate(userID, uniqueName, text)
+	})
+	if !added {
+		message += fmt.Sprintf(":x:There is already a template named **%s**.", uniqueName)
+		return commandErrorResponse(message)
+	}
+
+	message += fmt.Sprintf(":white_check_mark:Template **%s** registered.", uniqueName)
+	return commandStatusResponse(message)
+}
+
+func (p *Plugin) removeTemplate(args []string, userID string) (*model.CommandResponse, *model.AppError) {
+	var message string
+
+	if len(args) != 4 {
+		message += fmt.Sprintf(":x:Wrong use: `%s %s %s unique_name`.", args[0], args[1], args[2])
+		return commandErrorResponse(message)
+	}
+	templateName := args[3]
+
+	removed := false
+	p.updateSharedState(p.LoadTemplates, p.SaveTemplates, func() {
+		removed = p.state.removeTemplate(userID, templateName)
+	})
+	if !removed {
+		message += fmt.Sprintf(":x:There no template named **%s**.", templateName)
+		return commandErrorResponse(message)
+	}
+	message += fmt.Sprintf(":white_check_mark:Template **%s** removed.", templateName)
+	return commandStatusResponse(message)
+}
+
+func (p *Plugin) serveListTemplates(args []string, userID string) (*model.CommandResponse, *model.AppError) {
+	var message string
+
+	message += "## Here are all the reply templates you have registered:\n"
+	for k, v := range p.state.getTemplates(userID) {
+		message += fmt.Sprintf("* Template **\"%s\"**: `%s`\n", k, v)
+	}
+	return commandStatusResponse(message)
+}
+
+func (p *Plugin) reply(args []string, userID string) (*model.CommandResponse, *model.AppError) {
+	var message string
+
+	if len(args) < 5 || (args[4] == "--template" && len(args) != 6) {
+		message += fmt.Sprintf(":x:Wrong use: `%s %s packageName_or_alias reviewId reply_text` or `%s %s packageName_or_alias reviewId --template template_name`", args[0], args[1], args[0], args[1])
+		return commandErrorResponse(message)
+	}
+	packageNameOrAlias := args[2]
+	reviewID := args[3]
+
+	packageName, ok := p.state.resolvePackageName(packageNameOrAlias, userID)
+	if !ok {
+		message += fmt.Sprintf(":x:Package **%s** is not yet registered.", packageNameOrAlias)
+		return commandErrorResponse(message)
+	}
+
+	text := strings.Join(args[4:], " ")
+	if args[4] == "--template" {
+		templateName := args[5]
+		templateText, ok := p.state.getTemplate(userID, templateName)
+		if !ok {
+			message += fmt.Sprintf(":x:There no template named **%s**.", templateName)
+			return commandErrorResponse(message)
+		}
+
+		review, err := p.getReview(userID, packageName, reviewID)
+		if err != nil {
+			message += fmt.Sprintf(":x:Error getting the review **%s**: **%s**", reviewID, err.Error())
+			return commandErrorResponse(message)
+		}
+
+		text, err = renderReplyTemplate(templateName, templateText, packageName, review)
+		if err != nil {
+			message += fmt.Sprintf(":x:Error rendering the template **%s**: **%s**", templateName, err.Error())
+			return commandErrorResponse(message)
+		}
+	}
+
+	if _, ok := p.state.getApprovalChannel(packageName); ok {
+		if err := p.draftReply(userID, packageName, reviewID, text); err != nil {
+			message += fmt.Sprintf(":x:Error sending the reply to the review **%s** for approval: **%s**", reviewID, err.Error())
+			return commandErrorResponse(message)
+		}
+		message += fmt.Sprintf(":hourglass:Reply to the review **%s** sent for approval:\n>%s", reviewID, text)
+		return commandStatusResponse(message)
+	}
+
+	if err := p.replyToReview(userID, packageName, reviewID, text); err != nil {
+		message += fmt.Sprintf(":x:Error replying to the review **%s**: **%s**", reviewID, err.Error())
+		return commandErrorResponse(message)
+	}
+
+	message += fmt.Sprintf(":white_check_mark:Replied to the review **%s**:\n>%s", reviewID, text)
+	return commandStatusResponse(message)
+}
+
+// resolveApprovalPackage gets the app by the alias of the admin, or by its name if any user registered it
+func (p *Plugin) resolveApprovalPackage(packageNameOrAlias string, userID string) (string, bool) {
+	if packageName, ok := p.state.resolvePackageName(packageNameOrAlias, userID); ok {
+		return packageName, true
+	}
+	return packageNameOrAlias, p.state.isRegisteredPackage(packageNameOrAlias)
+}
+
+func (p *Plugin) enableApproval(args []string, userID string, channelID string) (*model.CommandResponse, *model.AppError) {
+	var message string
+
+	if len(args) != 5 {
+		message += fmt.Sprintf(":x:Wrong use: `%s %s %s packageName_or_alias ~channel`", args[0], args[1], args[2])
+		return commandErrorResponse(message)
+	}
+	packageNameOrAlias := args[3]
+
+	packageName, ok := p.resolveApprovalPackage(packageNameOrAlias, userID)
+	if !ok {
+		message += fmt.Sprintf(":x:Package **%s** is not yet registered.", packageNameOrAlias)
+		return commandErrorResponse(message)
+	}
+
+	channel, err := p.getRouteChannel(args[4], channelID, userID)
+	if err != nil {
+		message += fmt.Sprintf(":x:Cannot send the replies for approval to **%s**: **%s**", args[4], err.Error())
+		return commandErrorResponse(message)
+	}
+
+	p.updateSharedState(p.LoadApprovals, p.SaveApprovals, func() {
+		p.state.setApprovalChannel(packageName, channel.Id)
+	})
+
+	message += fmt.Sprintf(":white_check_mark:Approval mode enabled. Replies to the reviews of **%s** will be sent to **~%s** for approval.", packageName, channel.Name)
+	return commandStatusResponse(message)
+}
+
+func (p *Plugin) disableApproval(args []string, userID string) (*model.CommandResponse, *model.AppError) {
+	var message string
+
+	if len(args) != 4 {
+		message += fmt.Sprintf(":x:Wrong use: `%s %s %s packageName_or_alias`", args[0], args[1], args[2])
+		return commandErrorResponse(message)
+	}
+	packageName, _ := p.resolveApprovalPackage(args[3], userID)
+
+	removed := false
+	p.updateSharedState(p.LoadApprovals, p.SaveApprovals, func() {
+		removed = p.state.removeApprovalChannel(packageName)
+	})
+	if !removed {
+		message += fmt.Sprintf(":x:Approval mode is not enabled for **%s**.", packageName)
+		return commandErrorResponse(message)
+	}
+
+	message += fmt.Sprintf(":white_check_mark:Approval mode disabled. Replies to the reviews of **%s** will be published directly.", packageName)
+	return commandStatusResponse(message)
+}
+
+func (p *Plugin) serveListApprovals(args []string, userID string) (*model.CommandResponse, *model.AppError) {
+	var message string
+
+	message += "## Here are all the apps whose replies need approval:\n"
+	for packageName, channelID := range p.state.getApprovals().Channels {
+		message += fmt.Sprintf("* App **%s**: approved on **%s**\n", packageName, p.getChannelDisplayName(channelID))
+	}
+	return commandStatusResponse(message)
+}
+
+func (p *Plugin) removeKeywordsAlert(args []string, userID string) (*model.CommandResponse, *model.AppError) {
+	var message string
+
+	if len(args) != 5 {
+		message += fmt.Sprintf(":x:Wrong use: `%s %s %s %s unique_name`.", args[0], args[1], args[2], args[3])
+		return commandErrorResponse(message)
+	}
+	alertName := args[4]
+
+	found := false
+	p.updateSharedState(p.LoadAlerts, p.SaveAlerts, func() {
+		p.state.editAlerts(func(alerts *AlertsContainer) {
+			_, found = alerts.KeywordsAlerts[userID][alertName]
+			delete(alerts.KeywordsAlerts[userID], alertName)
+		})
+	})
+	if !found {
+		message += fmt.Sprintf(":x:There no alert named **%s**.", alertName)
+		return commandErrorResponse(message)
+	}
+	message += fmt.Sprintf(":white_check_mark:Alert **%s** removed.", alertName)
+	return commandStatusResponse(message)
+}
+
+func (p *Plugin) serveListKeywordsAlerts(args []string, userID string) (*model.CommandResponse, *model.AppError) {
+	var message string
+
+	message += "## Here are all the keywords alerts you have registered:\n"
+	for k, v := range p.state.getAlerts().KeywordsAlerts[userID] {
+		message += fmt.Sprintf("* Alert **\"%s\"**: Terms `%s` from package **%s**", k, strings.Join(v.Terms, "` `"), v.PackageName)
+		if v.Language != "" {
+			message += fmt.Sprintf(" in language **%s**", v.Language)
+		}
+		if v.Tag != "" {
+			message += fmt.Sprintf(" tagged **%s**", v.Tag)
+		}
+		message += fmt.Sprintf(" every **%v seconds** at most on webhook **%s**\n", v.Frequency, v.Webhook)
+	}
+	return commandStatusResponse(message)
+}
+
+func (p *Plugin) addKeywordsAlert(args []string, userID string) (*model.CommandResponse, *model.AppError) {
+	var message string
+
+	wrongUse := fmt.Sprintf(":x:Wrong use: `%s %s %s %s unique_name webhook packageName_or_alias minimum_frequency_in_seconds [--language language] [--tag tag] term1 [term2 /regular_expression/ ...]`", args[0], args[1], args[2], args[3])
+	if len(args) < 9 {
+		return commandErrorResponse(wrongUse)
+	}
+	uniqueName := args[4]
+	webhook := args[5]
+	packageNameOrAlias := args[6]
+	minimumFrequency := args[7]
+	language := ""
+	tag := ""
+	terms := args[8:]
+	for len(terms) > 0 && (terms[0] == "--language" || terms[0] == "--tag") {
+		if len(terms) < 3 {
+			return commandErrorResponse(wrongUse)
+		}
+		if terms[0] == "--language" {
+			language = terms[1]
+		} else {
+			tag = strings.ToLower(terms[1])
+		}
+		terms = terms[2:]
+	}
+
+	packageName, ok := p.state.resolvePackageName(packageNameOrAlias, userID)
+	if !ok {
+		message += fmt.Sprintf(":x:Package **%s** is not yet registered.", packageNameOrAlias)
+		return commandErrorResponse(message)
+	}
+
+	frequency, err := strconv.ParseInt(minimumFrequency, 10, 64)
+	if err != nil || frequency <= 0 {
+		message += fmt.Sprintf(":x:**%s** is not a well formed frequency. Please use a positive number.", minimumFrequency)
+		return commandErrorResponse(message)
+	}
+
+	if _, err := compileKeywordsTerms(terms); err != nil {
+		message += fmt.Sprintf(":x:%s.", err.Error())
+		return commandErrorResponse(message)
+	}
+
+	if tag != "" && !isAvailableTag(p.getClassifier(), tag) {
+		message += formatUnknownTag(p.getClassifier(), tag)
+		return commandErrorResponse(message)
+	}
+
+	alert := &KeywordsAlert{
+		Alert: Alert{
+			Webhook:     webhook,
+			PackageName: packageName,
+			Frequency:   frequency,
+			lastAlerted: time.Now(),
+		},
+		Terms:    terms,
+		Language: language,
+		Tag:      tag,
+	}
+
+	added := false
+	p.updateSharedState(p.LoadAlerts, p.SaveAlerts, func() {
+		added = false
+		p.state.editAlerts(func(alerts *AlertsContainer) {
+			if _, ok := alerts.KeywordsAlerts[userID][uniqueName]; ok {
+				return
+			}
+			if _, ok := alerts.KeywordsAlerts[userID]; !ok {
+				alerts.KeywordsAlerts[userID] = make(map[string]*KeywordsAlert)
+			}
+			alerts.KeywordsAlerts[userID][uniqueName] = alert
+			added = true
+		})
+	})
+	if !added {
+		message += fmt.Sprintf(":x:There is already an alert named **%s**.", uniqueName)
+		return commandErrorResponse(message)
+	}
+
+	message += fmt.Sprintf(":white_check_mark:Alert **%s** registered.", uniqueName)
+	return commandStatusResponse(message)
+}
+
+func (p *Plugin) removeAnomalyAlert(args []string, userID string) (*model.CommandResponse, *model.AppError) {
+	var message string
+
+	if len(args) != 5 {
+		message += fmt.Sprintf(":x:Wrong use: `%s %s %s %s unique_name`.", args[0], args[1], args[2], args[3])
+		return commandErrorResponse(message)
+	}
+	alertName := args[4]
+
+	found := false
+	p.updateSharedState(p.LoadAlerts, p.SaveAlerts, func() {
+		p.state.editAlerts(func(alerts *AlertsContainer) {
+			_, found = alerts.AnomalyAlerts[userID][alertName]
+			delete(alerts.AnomalyAlerts[userID], alertName)
+		})
+	})
+	if !found {
+		message += fmt.Sprintf(":x:There no alert named **%s**.", alertName)
+		return commandErrorResponse(message)
+	}
+	message += fmt.Sprintf(":white_check_mark:Alert **%s** removed.", alertName)
+	return commandStatusResponse(message)
+}
+
+func (p *Plugin) serveListAnomalyAlerts(args []string, userID string) (*model.CommandResponse, *model.AppError) {
+	var message string
+
+	message += "## Here are all the anomaly alerts you have registered:\n"
+	for k, v := range p.state.getAlerts().AnomalyAlerts[userID] {
+		message += fmt.Sprintf("* Alert **\"%s\"**: From package **%s**, last **%d hours** against the previous **%d days** with a **%v%%** threshold, every **%v seconds** at most on webhook **%s**\n", k, v.PackageName, v.WindowHours, v.BaselineDays, v.Threshold, v.Frequency, v.Webhook)
+	}
+	return commandStatusResponse(message)
+}
+
+func (p *Plugin) addAnomalyAlert(args []string, userID string) (*model.CommandResponse, *model.AppError) {
+	var message string
+
+	if len(args) != 11 {
+		message += fmt.Sprintf(":x:Wrong use: `%s %s %s %s unique_name webhook packageName_or_alias minimum_frequency_in_seconds window_hours baseline_days threshold_percent`", args[0], args[1], args[2], args[3])
+		return commandErrorResponse(message)
+	}
+	uniqueName := args[4]
+	webhook := args[5]
+	packageNameOrAlias := args[6]
+	minimumFrequency := args[7]
+
+	packageName, ok := p.state.resolvePackageName(packageNameOrAlias, userID)
 	if !ok {
 		message += fmt.Sprintf(":x:Package **%s** is not yet registered.", packageNameOrAlias)
 		return commandErrorResponse(message)
@@ -67,19 +805,54 @@ func (p *Plugin) addNewReviewsAlert(args []string, userID string) (*model.Comman
 		return commandErrorResponse(message)
 	}
 
-	if _, ok := p.alerts.NewReviewsAlerts[userID]; !ok {
-		p.alerts.NewReviewsAlerts[userID] = make(map[string]*NewReviewsAlert)
+	windowHours, err := strconv.ParseInt(args[8], 10, 64)
+	if err != nil || windowHours <= 0 {
+		message += fmt.Sprintf(":x:**%s** is not a well formed amount of hours. Please use a positive number.", args[8])
+		return commandErrorResponse(message)
+	}
+
+	baselineDays, err := strconv.ParseInt(args[9], 10, 64)
+	if err != nil || baselineDays <= 0 {
+		message += fmt.Sprintf(":x:**%s** is not a well formed amount of days. Please use a positive number.", args[9])
+		return commandErrorResponse(message)
+	}
+
+	threshold, err := strconv.ParseFloat(args[10], 64)
+	if err != nil || threshold <= 0 {
+		message += fmt.Sprintf(":x:**%s** is not a well formed threshold. Please use a positive percentage.", args[10])
+		return commandErrorResponse(message)
 	}
 
-	p.alerts.NewReviewsAlerts[userID][uniqueName] = &NewReviewsAlert{
+	alert := &AnomalyAlert{
 		Alert: Alert{
 			Webhook:     webhook,
 			PackageName: packageName,
 			Frequency:   frequency,
 			lastAlerted: time.Now(),
 		},
+		WindowHours:  windowHours,
+		BaselineDays: baselineDays,
+		Threshold:    threshold,
+	}
+
+	added := false
+	p.updateSharedState(p.LoadAlerts, p.SaveAlerts, func() {
+		added = false
+		p.state.editAlerts(func(alerts *AlertsContainer) {
+			if _, ok := alerts.AnomalyAlerts[userID][uniqueName]; ok {
+				return
+			}
+			if _, ok := alerts.AnomalyAlerts[userID]; !ok {
+				alerts.AnomalyAlerts[userID] = make(map[string]*AnomalyAlert)
+			}
+			alerts.AnomalyAlerts[userID][uniqueName] = alert
+			added = true
+		})
+	})
+	if !added {
+		message += fmt.Sprintf(":x:There is already an alert named **%s**.", uniqueName)
+		return commandErrorResponse(message)
 	}
-	p.SaveAlerts()
 
 	message += fmt.Sprintf(":white_check_mark:Alert **%s** registered.", uniqueName)
 	return commandStatusResponse(message)
@@ -88,30 +861,44 @@ func (p *Plugin) addNewReviewsAlert(args []string, userID string) (*model.Comman
 func (p *Plugin) addApp(args []string, userID string) (*model.CommandResponse, *model.AppError) {
 	var message string
 
-	if len(args) != 4 {
-		message += fmt.Sprintf(":x:Wrong use: `%s %s %s packageName`", args[0], args[1], args[2])
+	store, args := getStoreFromArgs(args, 3)
+	if len(args) != 4 && len(args) != 5 {
+		message += fmt.Sprintf(":x:Wrong use: `%s %s %s [appstore] packageName [accountName]`", args[0], args[1], args[2])
 		return commandErrorResponse(message)
 	}
 
 	packageName := args[3]
-	packageInfo := PackageInfo{Name: packageName, UserID: userID}
+	accountName := defaultAccountName
+	if len(args) == 5 {
+		accountName = args[4]
+	}
+	packageInfo := PackageInfo{Name: packageName, UserID: userID, Account: accountName, Store: store}
 
-	if contains(p.packageList, packageInfo) {
+	if p.state.hasPackage(packageInfo) {
 		message += fmt.Sprintf(":x:Package **%s** already registered.", packageName)
 		return commandErrorResponse(message)
 	}
 
-	service := p.getService(userID)
-
-	_, err := service.List(packageName).Do()
+	source, err := p.getSource(userID, store, accountName)
 	if err != nil {
+		message += fmt.Sprintf(":x:%s account **%s** is not connected. Connect it with `%s connect %s`.", getStoreTitle(store), accountName, args[0], strings.TrimSpace(store+" "+accountName))
+		return commandErrorResponse(message)
+	}
+
+	if _, err = source.ListReviews(packageName, ""); err != nil {
 		message += fmt.Sprintf(":x:Error registering the app **%s**: **%v**", packageName, err.Error())
 		return commandErrorResponse(message)
 	}
 
-	p.packageList = append(p.packageList, packageInfo)
-	p.SavePackages()
-	message += fmt.Sprintf(":white_check_mark:Package **%s** added to the system.", packageName)
+	added := false
+	p.updateSharedState(p.LoadPackages, p.SavePackages, func() {
+		added = p.state.addPackage(packageInfo)
+	})
+	if !added {
+		message += fmt.Sprintf(":x:Package **%s** already registered.", packageName)
+		return commandErrorResponse(message)
+	}
+	message += fmt.Sprintf(":white_check_mark:Package **%s** added to the system using the %s account **%s**.", packageName, getStoreTitle(store), accountName)
 	return commandStatusResponse(message)
 }
 
@@ -126,22 +913,20 @@ func (p *Plugin) addAlias(args []string, userID string) (*model.CommandResponse,
 	aliasName := args[3]
 	packageName := args[4]
 
-	if !contains(p.packageList, PackageInfo{Name: packageName, UserID: userID}) {
+	if !p.state.hasPackage(PackageInfo{Name: packageName, UserID: userID}) {
 		message += fmt.Sprintf(":x:App **%s** not registered.", packageName)
 		return commandErrorResponse(message)
 	}
 
-	if packageName, ok := p.aliases[aliasName]; ok {
-		message += fmt.Sprintf(":x:Alias **%s** already set for app **%s**.", aliasName, packageName)
+	var existing string
+	added := false
+	p.updateSharedState(p.LoadAliases, p.SaveAliases, func() {
+		existing, added = p.state.addAlias(userID, aliasName, packageName)
+	})
+	if !added {
+		message += fmt.Sprintf(":x:Alias **%s** already set for app **%s**.", aliasName, existing)
 		return commandErrorResponse(message)
 	}
-
-	if _, ok := p.aliases[userID]; !ok {
-		p.aliases[userID] = *new(map[string]string)
-	}
-
-	p.aliases[userID][aliasName] = packageName
-	p.SaveAliases()
 	message += fmt.Sprintf(":white_check_mark:Alias **%s** added for app **%s**.", aliasName, packageName)
 	return commandStatusResponse(message)
 }
@@ -150,17 +935,16 @@ func (p *Plugin) serveAppList(args []string, userID string) (*model.CommandRespo
 	var message string
 
 	message += "## Here are all the apps you have registered:\n"
-	for _, packageInfo := range p.packageList {
-		if userID == packageInfo.UserID {
-			message += fmt.Sprintf("* **%s**", packageInfo.Name)
-			if al := getAliasesForPackage(packageInfo.Name, p.aliases[userID]); len(al) > 0 {
-				message += " AKA"
-				for _, alias := range al {
-					message += fmt.Sprintf(" **_%s_**", alias)
-				}
+	aliases := p.state.getAliases(userID)
+	for _, packageInfo := range p.state.getUserPackages(userID) {
+		message += fmt.Sprintf("* **%s** on %s account **%s**", packageInfo.Name, getStoreTitle(packageInfo.Store), getAccountName(packageInfo.Account))
+		if al := getAliasesForPackage(packageInfo.Name, aliases); len(al) > 0 {
+			message += " AKA"
+			for _, alias := range al {
+				message += fmt.Sprintf(" **_%s_**", alias)
 			}
-			message += "\n"
 		}
+		message += "\n"
 	}
 	return commandStatusResponse(message)
 }
@@ -168,48 +952,187 @@ func (p *Plugin) serveAppList(args []string, userID string) (*model.CommandRespo
 func (p *Plugin) serveList(args []string, userID string) (*model.CommandResponse, *model.AppError) {
 	var message string
 
+	wrongUse := fmt.Sprintf(":x:Wrong use: `%s %s %s [packageName_or_alias] [--tag tag]`", args[0], args[1], args[2])
+	options := args[3:]
+	tag := ""
+	if len(options) >= 2 && options[len(options)-2] == "--tag" {
+		tag = strings.ToLower(options[len(options)-1])
+		options = options[:len(options)-2]
+	}
+	if len(options) > 1 {
+		return commandErrorResponse(wrongUse)
+	}
+	if tag != "" && !isAvailableTag(p.getClassifier(), tag) {
+		message += formatUnknownTag(p.getClassifier(), tag)
+		return commandErrorResponse(message)
+	}
+
+	packageName := ""
+	if len(options) == 1 {
+		var ok bool
+		if packageName, ok = p.state.resolvePackageName(options[0], userID); !ok {
+			message += fmt.Sprintf(":x:Package **%s** is not yet registered.", options[0])
+			return commandErrorResponse(message)
+		}
+	}
+
 	config := p.getConfiguration()
 
-	message += fmt.Sprintf("## Here are the %d latest reviews from each app:\n", config.MaxReviewsServed)
+	if tag != "" {
+		message += fmt.Sprintf("## Here are the %d latest reviews tagged **%s** from each app:\n", config.MaxReviewsServed, tag)
+	} else {
+		message += fmt.Sprintf("## Here are the %d latest reviews from each app:\n", config.MaxReviewsServed)
+	}
 	p.control.reviewsMutex.RLock()
 	defer p.control.reviewsMutex.RUnlock()
+	cards := []*model.SlackAttachment{}
 	for key, reviewList := range p.localReviews[userID] {
-		message += fmt.Sprintf("* Package Id: %s\n", key)
-		for i, review := range reviewList {
-			if i >= config.MaxReviewsServed {
-				break
-			}
-			message += formatReview(review)
+		if packageName != "" && key != packageName {
+			continue
 		}
+		filtered := filterReviewsByTag(reviewList, tag)
+		if len(filtered) == 0 {
+			message += fmt.Sprintf("* Package Id: %s has no reviews to show.\n", key)
+			continue
+		}
+		packageCards := p.getReviewCards(userID, key, filtered[:min(len(filtered), config.MaxReviewsServed)])
+		packageCards[0].Pretext = fmt.Sprintf("#### Package Id: %s", key)
+		cards = append(cards, packageCards...)
+	}
+	return commandCardsResponse(message, cards)
+}
+
+func (p *Plugin) reencrypt(args []string, userID string) (*model.CommandResponse, *model.AppError) {
+	var message string
+
+	if len(args) != 3 {
+		message += fmt.Sprintf(":x:Wrong use: `%s %s %s`", args[0], args[1], args[2])
+		return commandErrorResponse(message)
+	}
+
+	reencrypted, failed, err := p.reencryptTokens()
+	if err != nil {
+		message += fmt.Sprintf(":x:Error listing the stored tokens: **%v**. **%d** tokens were re-encrypted before the error.", err.Error(), reencrypted)
+		return commandErrorResponse(message)
+	}
+	if failed > 0 {
+		message += fmt.Sprintf(":warning:**%d** tokens re-encrypted with key **%s**, **%d** failed. Check the server logs and keep the previous keys until they are fixed.", reencrypted, p.getEncryptionKeyring().currentID, failed)
+		return commandStatusResponse(message)
 	}
+
+	message += fmt.Sprintf(":white_check_mark:**%d** tokens re-encrypted with key **%s**. The previous keys can be removed.", reencrypted, p.getEncryptionKeyring().currentID)
 	return commandStatusResponse(message)
 }
 
-func (p *Plugin) connect(userID string) (*model.CommandResponse, *model.AppError) {
+func (p *Plugin) connect(args []string, userID string, channelID string, triggerID string) (*model.CommandResponse, *model.AppError) {
+	var message string
+
+	store, args := getStoreFromArgs(args, 2)
+	if len(args) > 3 {
+		message += fmt.Sprintf(":x:Wrong use: `%s %s [appstore] [accountName]`", args[0], args[1])
+		return commandErrorResponse(message)
+	}
+
 	config := p.API.GetConfig()
 	if config.ServiceSettings.SiteURL == nil {
-		return commandErrorResponse("Encountered an error connecting to Google Play: SiteURL is not set-up.")
+		return commandErrorResponse(fmt.Sprintf("Encountered an error connecting to %s: SiteURL is not set-up.", getStoreTitle(store)))
+	}
+
+	accountName := defaultAccountName
+	if len(args) == 3 {
+		accountName = args[2]
+	}
+	if !isValidAccountName(accountName) {
+		message += fmt.Sprintf(":x:**%s** is not a valid account name. Please use up to 32 letters, numbers, `-` or `_`.", accountName)
+		return commandErrorResponse(message)
 	}
 
-	siteURL := *config.ServiceSettings.SiteURL
-	if i := len(siteURL); i > 0 && siteURL[i-1] == '/' {
-		siteURL = siteURL[:i-1]
+	if store == storeAppStore {
+		if err := p.openAppStoreConnectDialog(triggerID, accountName); err != nil {
+			p.logError("Error opening the App Store connection dialog", "userID", userID, "err", err.Error())
+			return commandErrorResponse(":x:Error opening the App Store connection dialog.")
+		}
+		return &model.CommandResponse{}, nil
 	}
-	userInfo, _ := p.getGooglePlayUserInfo(userID)
+
+	userInfo, _ := p.getGooglePlayUserInfo(userID, accountName)
 	if userInfo == nil {
-		return commandStatusResponse(fmt.Sprintf("[Click here to link your Google Play account.](%s/plugins/com.mattermost.google-play-reviews/oauth/connect)", siteURL))
+		return commandStatusResponse(fmt.Sprintf("[Click here to link your Google Play account as **%s**.](%s/oauth/connect?channel_id=%s&account=%s)", accountName, p.getPluginURL(), url.QueryEscape(channelID), url.QueryEscape(accountName)))
 	}
 
-	return commandStatusResponse("Google Play Reviews connected and running.")
+	return commandStatusResponse(fmt.Sprintf("Google Play account **%s** connected and running.", accountName))
 }
 
-func (p *Plugin) disconnect(userID string) (*model.CommandResponse, *model.AppError) {
-	userInfo, _ := p.getGooglePlayUserInfo(userID)
-	if userInfo != nil {
-		p.API.KVDelete(userID + GooglePlayTokenKey)
+func (p *Plugin) disconnect(args []string, userID string) (*model.CommandResponse, *model.AppError) {
+	var message string
+
+	store, args := getStoreFromArgs(args, 2)
+	if len(args) > 3 {
+		message += fmt.Sprintf(":x:Wrong use: `%s %s [appstore] [accountName]`", args[0], args[1])
+		return commandErrorResponse(message)
+	}
+
+	accountName := defaultAccountName
+	if len(args) == 3 {
+		accountName = args[2]
+	}
+
+	var err error
+	if store == storeAppStore {
+		err = p.removeAppStoreAccount(userID, accountName)
+	} else {
+		err = p.removeGooglePlayAccount(userID, accountName)
+	}
+	if err != nil {
+		p.logError("Error disconnecting account", "userID", userID, "store", getStoreName(store), "account", accountName, "err", err.Error())
+		message += fmt.Sprintf(":x:Error disconnecting the %s account **%s**.", getStoreTitle(store), accountName)
+		return commandErrorResponse(message)
 	}
 
-	return commandStatusResponse("Correctly disconnected from Google Play.")
+	message += fmt.Sprintf("Correctly disconnected the %s account **%s**.", getStoreTitle(store), accountName)
+	bound := []string{}
+	for _, packageInfo := range p.state.getUserPackages(userID) {
+		if getStoreName(packageInfo.Store) == getStoreName(store) && getAccountName(packageInfo.Account) == accountName {
+			bound = append(bound, packageInfo.Name)
+		}
+	}
+	if len(bound) > 0 {
+		message += fmt.Sprintf(" :warning:The apps **%s** use it, and will not sync until it is connected again.", strings.Join(bound, "**, **"))
+	}
+	return commandStatusResponse(message)
+}
+
+func (p *Plugin) serveListConnections(args []string, userID string) (*model.CommandResponse, *model.AppError) {
+	var message string
+
+	googlePlayAccounts, err := p.getGooglePlayAccounts(userID)
+	if err != nil {
+		p.logError("Error listing Google Play accounts", "userID", userID, "err", err.Error())
+		return commandErrorResponse(":x:Error listing your Google Play accounts.")
+	}
+	appStoreAccounts, err := p.getAppStoreAccounts(userID)
+	if err != nil {
+		p.logError("Error listing App Store accounts", "userID", userID, "err", err.Error())
+		return commandErrorResponse(":x:Error listing your App Store accounts.")
+	}
+
+	message += "## Here are all your store accounts:\n"
+	for _, store := range []string{storeGooglePlay, storeAppStore} {
+		accounts := googlePlayAccounts
+		if store == storeAppStore {
+			accounts = appStoreAccounts
+		}
+		for _, accountName := range accounts {
+			count := 0
+			for _, packageInfo := range p.state.getUserPackages(userID) {
+				if getStoreName(packageInfo.Store) == store && getAccountName(packageInfo.Account) == accountName {
+					count++
+				}
+			}
+			message += fmt.Sprintf("* %s **%s** used by **%d** apps\n", getStoreTitle(store), accountName, count)
+		}
+	}
+	return commandStatusResponse(message)
 }
 
 func commandStatusResponse(message string) (*model.CommandResponse, *model.AppError) {
@@ -221,3 +1144,10 @@ func commandStatusResponse(message string) (*model.CommandResponse, *model.AppEr
 	}
 	return response, nil
 }
+
+// commandCardsResponse shows the message followed by the review cards
+func commandCardsResponse(message string, cards []*model.SlackAttachment) (*model.CommandResponse, *model.AppError) {
+	response, appErr := commandStatusResponse(message)
+	response.Attachments = cards
+	return response, appErr
+}
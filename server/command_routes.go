@@ -8,21 +8,62 @@ import (
 	"github.com/mattermost/mattermost-server/v5/plugin"
 )
 
-const commandHelp = `* |/gpreviews connect| - Connect your Mattermost account to your Google Play Developer account
-* |/gpreviews disconnect| - Disconnect your Mattermost account from your Google Play Developer account
-* |/gpreviews add app packageId| - Add a packageId to the plugin
+const commandHelp = `* |/gpreviews connect [accountName]| - Connect your Mattermost account to a Google Play Developer account. Without name it is the |default| account
+* |/gpreviews connect appstore [accountName]| - Connect an App Store Connect API key, asked on a dialog
+* |/gpreviews disconnect [appstore] [accountName]| - Disconnect your Mattermost account from a Google Play Developer or App Store account
+* |/gpreviews list connections| - List your connected Google Play Developer and App Store accounts
+* |/gpreviews add app packageId [accountName]| - Add a packageId to the plugin, fetched with the Google Play account given
+* |/gpreviews add app appstore appId [accountName]| - Add an App Store app to the plugin, fetched with the App Store account given
 * |/gpreviews add alias aliasName packageId| - Add aliases for your apps
 * |/gpreviews list apps| - List your registered apps on the plugin
-* |/gpreviews list reviews [packageId_or_alias] - List your most recent reviews. If no package is stated, show from all packages registered
-* |/gpreviews add alert alert_type name webhook packageId_or_alias frequency_in_seconds| - Configure an alert on a incoming webhook for the alert type
+* |/gpreviews list reviews [packageId_or_alias] [--tag tag]| - List your most recent reviews, only the ones with the tag if given. If no package is stated, show from all packages registered
+* |/gpreviews add alert alert_type name webhook packageId_or_alias frequency_in_seconds| - Configure an alert on a incoming webhook for the alert type. Add |--tag tag| to a newReviews alert to only alert on the reviews with the tag
   * |alert_type| is the type of alert you want to add
 	* newReviews - tell you when there are new reviews
+	* newVersion - tell you when reviews arrive from a new app version
+* |/gpreviews add alert keywords name webhook packageId_or_alias frequency_in_seconds [--language language] [--tag tag] term1 [term2 /regular_expression/ ...]| - Configure an alert on a incoming webhook for reviews mentioning any of the terms
+* |/gpreviews add alert anomaly name webhook packageId_or_alias frequency_in_seconds window_hours baseline_days threshold_percent| - Configure an alert on a incoming webhook for when the review rate, the average stars or the share of 1-2 star reviews of the last hours deviate from the previous days past the threshold
 * |/gpreviews list alert alert_type| - List alerts of alert_type
   * |alert_type| is the type of alert you want to add
     * newReviews - tell you when there are new reviews
+    * keywords - tell you when reviews mention some terms
+    * anomaly - tell you when the recent reviews deviate from the baseline
+    * newVersion - tell you when reviews arrive from a new app version
 * |/gpreviews remove alert alert_type alertName| - Remove one alert
   * |alert_type| is the type of alert you want to add
-    * newReviews - tell you when there are new reviews`
+    * newReviews - tell you when there are new reviews
+    * keywords - tell you when reviews mention some terms
+    * anomaly - tell you when the recent reviews deviate from the baseline
+    * newVersion - tell you when reviews arrive from a new app version
+* |/gpreviews compare packageId_or_alias versionA versionB| - Compare the reviews of two app versions, given by code or by name
+* |/gpreviews devices packageId_or_alias| - Rank devices, manufacturers and Android versions by the amount of 1-2 star reviews
+* |/gpreviews tags packageId_or_alias| - Break down the reviews by sentiment and topic tags
+* |/gpreviews themes packageId_or_alias [--since days]| - Group the recent 1-2 star reviews describing the same thing. Use |48h| for hours, 30 days by default
+* |/gpreviews set interval packageId_or_alias seconds| - Set how often the reviews of an app are fetched. Use 0 to go back to the configured refresh time
+* |/gpreviews set language packageId_or_alias language| - Translate the reviews of an app to the language. Use none to stop translating them
+* |/gpreviews route add packageId_or_alias ~channel language [language ...]| - Post the new reviews of an app in the languages on the channel
+* |/gpreviews route fallback packageId_or_alias ~channel| - Post the new reviews in any other language on the channel. Use none to not post them
+* |/gpreviews route remove packageId_or_alias language| - Remove the route of the language, or the fallback channel with |fallback|
+* |/gpreviews route list| - List your language routes
+* |/gpreviews status| - Show the sync status of your apps
+* |/gpreviews refresh [packageId_or_alias]| - Sync now the reviews of one app, or all your apps if none is stated
+* |/gpreviews add sla name webhook packageId_or_alias stars hours [warning_hours]| - Escalate on a incoming webhook reviews in the star rating band (|1| or |1-2|) not replied within the hours
+* |/gpreviews list sla| - List your SLAs
+* |/gpreviews remove sla name| - Remove one SLA
+* |/gpreviews sla report [packageId_or_alias]| - Show how the SLAs are being met
+* |/gpreviews reply packageId_or_alias reviewId reply_text| - Reply to a review
+* |/gpreviews reply packageId_or_alias reviewId --template name| - Reply to a review using a template
+* |/gpreviews issue reviewId| - Create an issue from a review on the configured issue tracker
+* |/gpreviews template add name template_text| - Add a reply template. Available placeholders are |{{author}}|, |{{app}}|, |{{version}}| and |{{stars}}|
+* |/gpreviews template list| - List your reply templates
+* |/gpreviews template remove name| - Remove one reply template
+* |/gpreviews approval enable packageId_or_alias ~channel| - Send the replies to the reviews of an app for approval to the channel before publishing them. Only for system admins
+* |/gpreviews approval disable packageId_or_alias| - Publish the replies to the reviews of an app directly. Only for system admins
+* |/gpreviews approval list| - List the apps whose replies need approval
+* |/gpreviews admin reencrypt| - Encrypt all the stored tokens with the current encryption key. Only for system admins
+* |/gpreviews admin deadletters [id]| - List the alert payloads not delivered to their webhooks, or show the payload of one. Only for system admins
+* |/gpreviews admin replay id_or_all| - Deliver again one or all the undelivered payloads. Only for system admins
+* |/gpreviews admin discard id_or_all| - Discard one or all the undelivered payloads. Only for system admins`
 
 func getCommand() *model.Command {
 	return &model.Command{
@@ -30,19 +71,22 @@ func getCommand() *model.Command {
 		DisplayName:      "Google Play Reviews",
 		Description:      "Integration with Google Play Reviews.",
 		AutoComplete:     true,
-		AutoCompleteDesc: "Available commands: connect, disconnect, add, list, remove",
+		AutoCompleteDesc: "Available commands: connect, disconnect, add, list, set, remove, sla, reply, issue, template, approval, compare, devices, tags, themes, status, refresh, route, admin",
 		AutoCompleteHint: "[command]",
 	}
 }
 
 // ExecuteCommand triggers when a command is executed on Mattermost
 func (p *Plugin) ExecuteCommand(c *plugin.Context, args *model.CommandArgs) (*model.CommandResponse, *model.AppError) {
+	// Other nodes of the cluster may have changed the state
+	p.loadSharedState()
+
 	split := strings.Fields(args.Command)
-	return p.routeRoot(split, args.UserId)
+	return p.routeRoot(split, args.UserId, args.ChannelId, args.TriggerId)
 }
 
-func (p *Plugin) routeRoot(args []string, userID string) (*model.CommandResponse, *model.AppError) {
-	availableCommands := "Available commands are:\n* `list`\n* `set`\n* `add`\n* `remove`"
+func (p *Plugin) routeRoot(args []string, userID string, channelID string, triggerID string) (*model.CommandResponse, *model.AppError) {
+	availableCommands := "Available commands are:\n* `list`\n* `set`\n* `add`\n* `remove`\n* `sla`\n* `reply`\n* `issue`\n* `template`\n* `approval`\n* `compare`\n* `devices`\n* `tags`\n* `themes`\n* `status`\n* `refresh`\n* `route`\n* `admin`"
 	if len(args) < 2 {
 		message := fmt.Sprintf(":x:Program `\"%s\"` needs a command. %s", args[0], availableCommands)
 		return commandErrorResponse(message)
@@ -51,14 +95,42 @@ func (p *Plugin) routeRoot(args []string, userID string) (*model.CommandResponse
 	switch args[1] {
 	case "list":
 		return p.routeList(args, userID)
+	case "set":
+		return p.routeSet(args, userID)
 	case "add":
 		return p.routeAdd(args, userID)
 	case "remove":
 		return p.routeRemove(args, userID)
+	case "sla":
+		return p.routeSLA(args, userID)
+	case "reply":
+		return p.reply(args, userID)
+	case "issue":
+		return p.createIssueCommand(args, userID)
+	case "template":
+		return p.routeTemplate(args, userID)
+	case "approval":
+		return p.routeApproval(args, userID, channelID)
+	case "compare":
+		return p.serveCompare(args, userID)
+	case "devices":
+		return p.serveDevices(args, userID)
+	case "tags":
+		return p.serveTags(args, userID)
+	case "themes":
+		return p.serveThemes(args, userID)
+	case "status":
+		return p.serveStatus(args, userID)
+	case "refresh":
+		return p.refresh(args, userID)
+	case "admin":
+		return p.routeAdmin(args, userID)
+	case "route":
+		return p.routeLanguageRoutes(args, userID, channelID)
 	case "connect":
-		return p.connect(userID)
+		return p.connect(args, userID, channelID, triggerID)
 	case "disconnect":
-		return p.disconnect(userID)
+		return p.disconnect(args, userID)
 	default:
 		message := fmt.Sprintf(":x:Command `\"%s\"` not found. %s", args[1], availableCommands)
 		return commandErrorResponse(message)
@@ -66,7 +138,7 @@ func (p *Plugin) routeRoot(args []string, userID string) (*model.CommandResponse
 }
 
 func (p *Plugin) routeList(args []string, userID string) (*model.CommandResponse, *model.AppError) {
-	availableLists := "Available lists are:\n* `apps`\n* `alerts`\n* `reviews`"
+	availableLists := "Available lists are:\n* `apps`\n* `alerts`\n* `reviews`\n* `sla`\n* `connections`"
 	if len(args) < 3 {
 		message := fmt.Sprintf(":x:Command `\"%s %s\"` need something to list. %s", args[0], args[1], availableLists)
 		return commandErrorResponse(message)
@@ -78,6 +150,10 @@ func (p *Plugin) routeList(args []string, userID string) (*model.CommandResponse
 		return p.routeListAlerts(args, userID)
 	case "reviews":
 		return p.serveList(args, userID)
+	case "sla":
+		return p.serveListSLAs(args, userID)
+	case "connections":
+		return p.serveListConnections(args, userID)
 	default:
 		message := fmt.Sprintf(":x:Nothing called `\"%s\"` can be listed. %s", args[2], availableLists)
 		return commandErrorResponse(message)
@@ -85,7 +161,7 @@ func (p *Plugin) routeList(args []string, userID string) (*model.CommandResponse
 }
 
 func (p *Plugin) routeListAlerts(args []string, userID string) (*model.CommandResponse, *model.AppError) {
-	alertTypes := "Available types are:\n* `newReviews`"
+	alertTypes := "Available types are:\n* `newReviews`\n* `keywords`\n* `anomaly`\n* `newVersion`"
 	if len(args) < 4 {
 		message := fmt.Sprintf(":x:Command `\"%s %s %s\"` needs the alert type. %s", args[0], args[1], args[2], alertTypes)
 		return commandErrorResponse(message)
@@ -93,14 +169,37 @@ func (p *Plugin) routeListAlerts(args []string, userID string) (*model.CommandRe
 	switch args[3] {
 	case "newReviews":
 		return p.serveListNewReviewsAlerts(args, userID)
+	case "keywords":
+		return p.serveListKeywordsAlerts(args, userID)
+	case "anomaly":
+		return p.serveListAnomalyAlerts(args, userID)
+	case "newVersion":
+		return p.serveListNewVersionAlerts(args, userID)
 	default:
 		message := fmt.Sprintf(":x:Alert type `\"%s\"` not found. %s", args[3], alertTypes)
 		return commandErrorResponse(message)
 	}
 }
 
+func (p *Plugin) routeSet(args []string, userID string) (*model.CommandResponse, *model.AppError) {
+	availableSets := "Available things to set are:\n* `interval`\n* `language`"
+	if len(args) < 3 {
+		message := fmt.Sprintf(":x:Command `\"%s %s\"` need something to set. %s", args[0], args[1], availableSets)
+		return commandErrorResponse(message)
+	}
+	switch args[2] {
+	case "interval":
+		return p.setPollInterval(args, userID)
+	case "language":
+		return p.setLanguage(args, userID)
+	default:
+		message := fmt.Sprintf(":x:Nothing named `\"%s\"` can be set. %s", args[2], availableSets)
+		return commandErrorResponse(message)
+	}
+}
+
 func (p *Plugin) routeAdd(args []string, userID string) (*model.CommandResponse, *model.AppError) {
-	availableAdds := "Available things to add are:\n* `app`\n* `alert`\n* `alias`"
+	availableAdds := "Available things to add are:\n* `app`\n* `alert`\n* `alias`\n* `sla`"
 	if len(args) < 3 {
 		message := fmt.Sprintf(":x:Command `\"%s %s\"` need something to add. %s", args[0], args[1], availableAdds)
 		return commandErrorResponse(message)
@@ -112,6 +211,8 @@ func (p *Plugin) routeAdd(args []string, userID string) (*model.CommandResponse,
 		return p.routeAddAlert(args, userID)
 	case "alias":
 		return p.addAlias(args, userID)
+	case "sla":
+		return p.addSLA(args, userID)
 	default:
 		message := fmt.Sprintf(":x:Nothing named `\"%s\"` can be added. %s", args[2], availableAdds)
 		return commandErrorResponse(message)
@@ -119,7 +220,7 @@ func (p *Plugin) routeAdd(args []string, userID string) (*model.CommandResponse,
 }
 
 func (p *Plugin) routeAddAlert(args []string, userID string) (*model.CommandResponse, *model.AppError) {
-	alertTypes := "Available types are:\n* `newReviews`"
+	alertTypes := "Available types are:\n* `newReviews`\n* `keywords`\n* `anomaly`\n* `newVersion`"
 	if len(args) < 4 {
 		message := fmt.Sprintf(":x:Command `\"%s %s %s\"` needs the alert type. %s", args[0], args[1], args[2], alertTypes)
 		return commandErrorResponse(message)
@@ -127,6 +228,12 @@ func (p *Plugin) routeAddAlert(args []string, userID string) (*model.CommandResp
 	switch args[3] {
 	case "newReviews":
 		return p.addNewReviewsAlert(args, userID)
+	case "keywords":
+		return p.addKeywordsAlert(args, userID)
+	case "anomaly":
+		return p.addAnomalyAlert(args, userID)
+	case "newVersion":
+		return p.addNewVersionAlert(args, userID)
 	default:
 		message := fmt.Sprintf(":x:Alert type `\"%s\"` not found. %s", args[3], alertTypes)
 		return commandErrorResponse(message)
@@ -134,7 +241,7 @@ func (p *Plugin) routeAddAlert(args []string, userID string) (*model.CommandResp
 }
 
 func (p *Plugin) routeRemove(args []string, userID string) (*model.CommandResponse, *model.AppError) {
-	availableRemoves := "Available things to remove are:\n* `alert`"
+	availableRemoves := "Available things to remove are:\n* `alert`\n* `sla`"
 	if len(args) < 3 {
 		message := fmt.Sprintf(":x:Command `\"%s %s\"` needs the alert type. %s", args[0], args[1], availableRemoves)
 		return commandErrorResponse(message)
@@ -142,6 +249,8 @@ func (p *Plugin) routeRemove(args []string, userID string) (*model.CommandRespon
 	switch args[2] {
 	case "alert":
 		return p.routeRemoveAlert(args, userID)
+	case "sla":
+		return p.removeSLA(args, userID)
 	default:
 		message := fmt.Sprintf(":x:Nothing named `\"%s\"` can be removed. %s", args[2], availableRemoves)
 		return commandErrorResponse(message)
@@ -149,7 +258,7 @@ func (p *Plugin) routeRemove(args []string, userID string) (*model.CommandRespon
 }
 
 func (p *Plugin) routeRemoveAlert(args []string, userID string) (*model.CommandResponse, *model.AppError) {
-	alertTypes := "Available types are:\n* `newReviews`"
+	alertTypes := "Available types are:\n* `newReviews`\n* `keywords`\n* `anomaly`\n* `newVersion`"
 	if len(args) < 4 {
 		message := fmt.Sprintf(":x:Command `\"%s %s %s\"` needs the alert type. %s", args[0], args[1], args[2], alertTypes)
 		return commandErrorResponse(message)
@@ -157,12 +266,120 @@ func (p *Plugin) routeRemoveAlert(args []string, userID string) (*model.CommandR
 	switch args[3] {
 	case "newReviews":
 		return p.removeNewReviewsAlert(args, userID)
+	case "keywords":
+		return p.removeKeywordsAlert(args, userID)
+	case "anomaly":
+		return p.removeAnomalyAlert(args, userID)
+	case "newVersion":
+		return p.removeNewVersionAlert(args, userID)
 	default:
 		message := fmt.Sprintf(":x:Alert type `\"%s\"` not found. %s", args[3], alertTypes)
 		return commandErrorResponse(message)
 	}
 }
 
+func (p *Plugin) routeSLA(args []string, userID string) (*model.CommandResponse, *model.AppError) {
+	availableSLACommands := "Available SLA commands are:\n* `report`"
+	if len(args) < 3 {
+		message := fmt.Sprintf(":x:Command `\"%s %s\"` needs a command. %s", args[0], args[1], availableSLACommands)
+		return commandErrorResponse(message)
+	}
+	switch args[2] {
+	case "report":
+		return p.serveSLAReport(args, userID)
+	default:
+		message := fmt.Sprintf(":x:SLA command `\"%s\"` not found. %s", args[2], availableSLACommands)
+		return commandErrorResponse(message)
+	}
+}
+
+func (p *Plugin) routeTemplate(args []string, userID string) (*model.CommandResponse, *model.AppError) {
+	availableTemplateCommands := "Available template commands are:\n* `add`\n* `list`\n* `remove`"
+	if len(args) < 3 {
+		message := fmt.Sprintf(":x:Command `\"%s %s\"` needs a command. %s", args[0], args[1], availableTemplateCommands)
+		return commandErrorResponse(message)
+	}
+	switch args[2] {
+	case "add":
+		return p.addTemplate(args, userID)
+	case "list":
+		return p.serveListTemplates(args, userID)
+	case "remove":
+		return p.removeTemplate(args, userID)
+	default:
+		message := fmt.Sprintf(":x:Template command `\"%s\"` not found. %s", args[2], availableTemplateCommands)
+		return commandErrorResponse(message)
+	}
+}
+
+func (p *Plugin) routeLanguageRoutes(args []string, userID string, channelID string) (*model.CommandResponse, *model.AppError) {
+	availableRouteCommands := "Available route commands are:\n* `add`\n* `fallback`\n* `remove`\n* `list`"
+	if len(args) < 3 {
+		message := fmt.Sprintf(":x:Command `\"%s %s\"` needs a command. %s", args[0], args[1], availableRouteCommands)
+		return commandErrorResponse(message)
+	}
+	switch args[2] {
+	case "add":
+		return p.addLanguageRoute(args, userID, channelID)
+	case fallbackRoute:
+		return p.setFallbackRoute(args, userID, channelID)
+	case "remove":
+		return p.removeLanguageRoute(args, userID)
+	case "list":
+		return p.serveListLanguageRoutes(args, userID)
+	default:
+		message := fmt.Sprintf(":x:Route command `\"%s\"` not found. %s", args[2], availableRouteCommands)
+		return commandErrorResponse(message)
+	}
+}
+
+func (p *Plugin) routeApproval(args []string, userID string, channelID string) (*model.CommandResponse, *model.AppError) {
+	availableApprovalCommands := "Available approval commands are:\n* `enable`\n* `disable`\n* `list`"
+	if len(args) < 3 {
+		message := fmt.Sprintf(":x:Command `\"%s %s\"` needs a command. %s", args[0], args[1], availableApprovalCommands)
+		return commandErrorResponse(message)
+	}
+	// Otherwise the drafters could pick their own approvers channel, or stop asking for approval
+	if (args[2] == "enable" || args[2] == "disable") && !p.API.HasPermissionTo(userID, model.PERMISSION_MANAGE_SYSTEM) {
+		return commandErrorResponse(":x:Only system admins can enable or disable the approval mode.")
+	}
+	switch args[2] {
+	case "enable":
+		return p.enableApproval(args, userID, channelID)
+	case "disable":
+		return p.disableApproval(args, userID)
+	case "list":
+		return p.serveListApprovals(args, userID)
+	default:
+		message := fmt.Sprintf(":x:Approval command `\"%s\"` not found. %s", args[2], availableApprovalCommands)
+		return commandErrorResponse(message)
+	}
+}
+
+func (p *Plugin) routeAdmin(args []string, userID string) (*model.CommandResponse, *model.AppError) {
+	availableAdminCommands := "Available admin commands are:\n* `reencrypt`\n* `deadletters`\n* `replay`\n* `discard`"
+	if len(args) < 3 {
+		message := fmt.Sprintf(":x:Command `\"%s %s\"` needs a command. %s", args[0], args[1], availableAdminCommands)
+		return commandErrorResponse(message)
+	}
+	if !p.API.HasPermissionTo(userID, model.PERMISSION_MANAGE_SYSTEM) {
+		return commandErrorResponse(":x:Only system admins can run admin commands.")
+	}
+	switch args[2] {
+	case "reencrypt":
+		return p.reencrypt(args, userID)
+	case "deadletters":
+		return p.serveDeadLetters(args, userID)
+	case "replay":
+		return p.replayDeadLetters(args, userID)
+	case "discard":
+		return p.discardDeadLetters(args, userID)
+	default:
+		message := fmt.Sprintf(":x:Admin command `\"%s\"` not found. %s", args[2], availableAdminCommands)
+		return commandErrorResponse(message)
+	}
+}
+
 func commandErrorResponse(message string) (*model.CommandResponse, *model.AppError) {
 	response := &model.CommandResponse{
 		ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
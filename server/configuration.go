@@ -4,10 +4,18 @@ import (
 	"fmt"
 	"reflect"
 	"strconv"
+	"strings"
 
 	"github.com/pkg/errors"
 )
 
+const (
+	// defaultMaxConcurrentSyncs and defaultAPIRequestsPerMinute are the manifest defaults, used when the
+	// configuration was saved before the settings existed
+	defaultMaxConcurrentSyncs   = 4
+	defaultAPIRequestsPerMinute = 60
+)
+
 // configuration captures the plugin's external configuration as exposed in the Mattermost server
 // configuration, as well as values computed from the configuration. Any public fields will be
 // deserialized from the Mattermost server configuration in OnConfigurationChange.
@@ -23,18 +31,38 @@ type configuration struct {
 	GetListTime                 string
 	AlertWatcherTime            string
 	MaxReviewsServed            string
+	MaxConcurrentSyncs          string
+	APIRequestsPerMinute        string
 	EncryptionKey               string
+	PreviousEncryptionKeys      string
 	GooglePlayOAuthClientID     string
 	GooglePlayOAuthClientSecret string
+	LogLevel                    string
+	MetricsToken                string
+	TopicDictionaries           string
+	IssueTrackerURL             string
+	IssueTrackerAuthorization   string
+	IssueTrackerTemplate        string
+	WebhookSigningSecret        string
 }
 
 type configurationProcessed struct {
 	GetListTime                 int
 	AlertWatcherTime            int
 	MaxReviewsServed            int
+	MaxConcurrentSyncs          int
+	APIRequestsPerMinute        int
 	EncryptionKey               string
+	PreviousEncryptionKeys      []string
 	GooglePlayOAuthClientID     string
 	GooglePlayOAuthClientSecret string
+	LogLevel                    string
+	MetricsToken                string
+	TopicDictionaries           map[string][]string
+	IssueTrackerURL             string
+	IssueTrackerAuthorization   string
+	IssueTrackerTemplate        string
+	WebhookSigningSecret        string
 }
 
 // Clone shallow copies the configuration. Your implementation may require a deep copy if
@@ -72,6 +100,44 @@ func (c *configuration) IsValid() error {
 		return fmt.Errorf("you should show at least 1 review. Currently set as %s", c.MaxReviewsServed)
 	}
 
+	if c.MaxConcurrentSyncs != "" {
+		if v, err = strconv.Atoi(c.MaxConcurrentSyncs); err != nil {
+			return fmt.Errorf("MaxConcurrentSyncs should be an integer. Currently set as %s", c.MaxConcurrentSyncs)
+		}
+
+		if v < 1 {
+			return fmt.Errorf("you should sync at least 1 app at a time. Currently set as %s", c.MaxConcurrentSyncs)
+		}
+	}
+
+	if c.APIRequestsPerMinute != "" {
+		if v, err = strconv.Atoi(c.APIRequestsPerMinute); err != nil {
+			return fmt.Errorf("APIRequestsPerMinute should be an integer. Currently set as %s", c.APIRequestsPerMinute)
+		}
+
+		if v < 1 {
+			return fmt.Errorf("you should allow at least 1 request per minute. Currently set as %s", c.APIRequestsPerMinute)
+		}
+	}
+
+	for _, key := range splitEncryptionKeys(c.PreviousEncryptionKeys) {
+		if len(key) != 16 && len(key) != 24 && len(key) != 32 {
+			return fmt.Errorf("previous encryption keys should be 16, 24 or 32 characters long. One of them has %d", len(key))
+		}
+	}
+
+	if _, ok := logLevels[c.LogLevel]; c.LogLevel != "" && !ok {
+		return fmt.Errorf("LogLevel should be one of debug, info, warn or error. Currently set as %s", c.LogLevel)
+	}
+
+	if _, err := parseTopicDictionaries(c.TopicDictionaries); err != nil {
+		return fmt.Errorf("TopicDictionaries is not valid: %v", err)
+	}
+
+	if _, err := parseIssueTemplate(c.IssueTrackerTemplate); err != nil {
+		return fmt.Errorf("IssueTrackerTemplate is not a valid template: %v", err)
+	}
+
 	return nil
 }
 
@@ -89,15 +155,49 @@ func (p *Plugin) getConfiguration() *configurationProcessed {
 	getListTime, _ := strconv.Atoi(p.configuration.GetListTime)
 	alertWatcherTime, _ := strconv.Atoi(p.configuration.AlertWatcherTime)
 	maxReviewsServed, _ := strconv.Atoi(p.configuration.MaxReviewsServed)
+	maxConcurrentSyncs := defaultMaxConcurrentSyncs
+	if p.configuration.MaxConcurrentSyncs != "" {
+		maxConcurrentSyncs, _ = strconv.Atoi(p.configuration.MaxConcurrentSyncs)
+	}
+	apiRequestsPerMinute := defaultAPIRequestsPerMinute
+	if p.configuration.APIRequestsPerMinute != "" {
+		apiRequestsPerMinute, _ = strconv.Atoi(p.configuration.APIRequestsPerMinute)
+	}
+	logLevel := p.configuration.LogLevel
+	if logLevel == "" {
+		logLevel = logLevelInfo
+	}
+	topicDictionaries, _ := parseTopicDictionaries(p.configuration.TopicDictionaries)
 
 	return &configurationProcessed{
 		GetListTime:                 getListTime,
 		AlertWatcherTime:            alertWatcherTime,
 		MaxReviewsServed:            maxReviewsServed,
+		MaxConcurrentSyncs:          maxConcurrentSyncs,
+		APIRequestsPerMinute:        apiRequestsPerMinute,
 		EncryptionKey:               p.configuration.EncryptionKey,
+		PreviousEncryptionKeys:      splitEncryptionKeys(p.configuration.PreviousEncryptionKeys),
 		GooglePlayOAuthClientID:     p.configuration.GooglePlayOAuthClientID,
 		GooglePlayOAuthClientSecret: p.configuration.GooglePlayOAuthClientSecret,
+		LogLevel:                    logLevel,
+		MetricsToken:                p.configuration.MetricsToken,
+		TopicDictionaries:           topicDictionaries,
+		IssueTrackerURL:             p.configuration.IssueTrackerURL,
+		IssueTrackerAuthorization:   p.configuration.IssueTrackerAuthorization,
+		IssueTrackerTemplate:        p.configuration.IssueTrackerTemplate,
+		WebhookSigningSecret:        p.configuration.WebhookSigningSecret,
+	}
+}
+
+// splitEncryptionKeys gets the keys from a comma separated list
+func splitEncryptionKeys(keys string) []string {
+	result := []string{}
+	for _, key := range strings.Split(keys, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			result = append(result, key)
+		}
 	}
+	return result
 }
 
 // setConfiguration replaces the active configuration under lock.
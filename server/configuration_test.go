@@ -0,0 +1,21 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigurationWithoutSyncSettings(t *testing.T) {
+	p := newTestPlugin()
+	p.configuration.MaxConcurrentSyncs = ""
+	p.configuration.APIRequestsPerMinute = ""
+	p.configuration.AlertWatcherTime = "60"
+
+	assert.NoError(t, p.configuration.IsValid())
+	assert.Equal(t, defaultMaxConcurrentSyncs, p.getConfiguration().MaxConcurrentSyncs)
+	assert.Equal(t, defaultAPIRequestsPerMinute, p.getConfiguration().APIRequestsPerMinute)
+
+	p.configuration.MaxConcurrentSyncs = "0"
+	assert.Error(t, p.configuration.IsValid())
+}
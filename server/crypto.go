@@ -0,0 +1,284 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/oauth2"
+)
+
+// keyIDSeparator separates the key id from the ciphertext. It is not part of the base64 URL alphabet,
+// so the values encrypted before the key ids were added never contain it.
+const keyIDSeparator = ":"
+
+// maxReencryptRetries bounds the retries when other requests change a value while it is re-encrypted
+const maxReencryptRetries = 5
+
+// encryptionKeyring encrypts with the current key, and decrypts with any of the active keys
+type encryptionKeyring struct {
+	currentID string
+	keys      map[string][]byte
+	// order has the current key first, to try the keys on the values without key id
+	order []string
+}
+
+func newEncryptionKeyring(current string, previous []string) *encryptionKeyring {
+	keyring := &encryptionKeyring{
+		keys: make(map[string][]byte),
+	}
+	for _, key := range append([]string{current}, previous...) {
+		if key == "" {
+			continue
+		}
+		id := getKeyID([]byte(key))
+		if _, ok := keyring.keys[id]; ok {
+			continue
+		}
+		if keyring.currentID == "" {
+			keyring.currentID = id
+		}
+		keyring.keys[id] = []byte(key)
+		keyring.order = append(keyring.order, id)
+	}
+	return keyring
+}
+
+// getKeyID identifies a key without revealing it
+func getKeyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:4])
+}
+
+// encrypt seals the text with AES-GCM under the current key, prefixing the key id
+func (k *encryptionKeyring) encrypt(text string) (string, error) {
+	if k.currentID == "" {
+		return "", errors.New("no encryption key configured")
+	}
+
+	gcm, err := newGCM(k.keys[k.currentID])
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	sealed := gcm.Seal(nonce, nonce, []byte(text), []byte(k.currentID))
+	return k.currentID + keyIDSeparator + base64.URLEncoding.EncodeToString(sealed), nil
+}
+
+// decrypt opens a value encrypted with any of the active keys. It also tells if the value
+// should be encrypted again, because it uses an old key or the unauthenticated CFB format.
+func (k *encryptionKeyring) decrypt(text string) (string, bool, error) {
+	parts := strings.SplitN(text, keyIDSeparator, 2)
+	if len(parts) != 2 {
+		return k.decryptLegacy(text)
+	}
+
+	id := parts[0]
+	key, ok := k.keys[id]
+	if !ok {
+		return "", false, fmt.Errorf("encryption key %s is not active", id)
+	}
+
+	sealed, err := base64.URLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", false, err
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", false, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", false, errors.New("ciphertext too short")
+	}
+
+	plain, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], []byte(id))
+	if err != nil {
+		return "", false, err
+	}
+	return string(plain), id != k.currentID, nil
+}
+
+// decryptLegacy tries the active keys on a CFB value. CFB has no integrity check, so a wrong
+// key is told apart by the padding and by the tokens being valid UTF-8.
+func (k *encryptionKeyring) decryptLegacy(text string) (string, bool, error) {
+	for _, id := range k.order {
+		if plain, err := decryptCFB(k.keys[id], text); err == nil && utf8.ValidString(plain) {
+			return plain, true, nil
+		}
+	}
+	return "", false, errors.New("unable to decrypt with any of the active keys")
+}
+
+// encryptToken returns a copy of the token with the access and refresh tokens sealed
+func (k *encryptionKeyring) encryptToken(token *oauth2.Token) (*oauth2.Token, error) {
+	encrypted := *token
+	var err error
+	if encrypted.AccessToken, err = k.encrypt(token.AccessToken); err != nil {
+		return nil, err
+	}
+	if token.RefreshToken != "" {
+		if encrypted.RefreshToken, err = k.encrypt(token.RefreshToken); err != nil {
+			return nil, err
+		}
+	}
+	return &encrypted, nil
+}
+
+// decryptToken returns a copy of the token with the access and refresh tokens opened. It also tells if the token
+// should be encrypted again. The tokens stored before AES-GCM have the access token in CFB and the refresh token
+// in plain text, so the refresh token is only kept as it is when the access token has no key id.
+func (k *encryptionKeyring) decryptToken(token *oauth2.Token) (*oauth2.Token, bool, error) {
+	decrypted := *token
+	accessToken, upgrade, err := k.decrypt(token.AccessToken)
+	if err != nil {
+		return nil, false, err
+	}
+	decrypted.AccessToken = accessToken
+
+	if token.RefreshToken == "" {
+		return &decrypted, upgrade, nil
+	}
+	if !strings.Contains(token.RefreshToken, keyIDSeparator) {
+		if strings.Contains(token.AccessToken, keyIDSeparator) {
+			return nil, false, errors.New("the refresh token is not encrypted")
+		}
+		return &decrypted, true, nil
+	}
+	refreshToken, refreshUpgrade, err := k.decrypt(token.RefreshToken)
+	if err != nil {
+		return nil, false, err
+	}
+	decrypted.RefreshToken = refreshToken
+	return &decrypted, upgrade || refreshUpgrade, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (p *Plugin) getEncryptionKeyring() *encryptionKeyring {
+	config := p.getConfiguration()
+	return newEncryptionKeyring(config.EncryptionKey, config.PreviousEncryptionKeys)
+}
+
+// reencryptTokens encrypts all the stored tokens with the current key, returning how many were
+// re-encrypted and how many failed
+func (p *Plugin) reencryptTokens() (int, int, error) {
+	keyring := p.getEncryptionKeyring()
+	reencrypted := 0
+	failed := 0
+	const perPage = 100
+	for page := 0; ; page++ {
+		keys, appErr := p.API.KVList(page, perPage)
+		if appErr != nil {
+			return reencrypted, failed, appErr
+		}
+
+		for _, key := range keys {
+			var err error
+			switch {
+			case strings.Contains(key, GooglePlayTokenKey):
+				err = p.reencryptToken(keyring, key)
+			case strings.Contains(key, AppStoreCredentialsKey):
+				err = p.reencryptAppStoreCredentials(keyring, key)
+			default:
+				continue
+			}
+			if err != nil {
+				p.logError("Error re-encrypting token", "key", key, "err", err.Error())
+				failed++
+				continue
+			}
+			reencrypted++
+		}
+
+		if len(keys) < perPage {
+			return reencrypted, failed, nil
+		}
+	}
+}
+
+func (p *Plugin) reencryptToken(keyring *encryptionKeyring, key string) error {
+	return p.reencryptValue(key, func(infoBytes []byte) ([]byte, error) {
+		var userInfo GooglePlayUserInfo
+		if err := json.Unmarshal(infoBytes, &userInfo); err != nil {
+			return nil, err
+		}
+		if userInfo.Token == nil {
+			return nil, errors.New("no token stored")
+		}
+
+		token, _, err := keyring.decryptToken(userInfo.Token)
+		if err != nil {
+			return nil, err
+		}
+		if userInfo.Token, err = keyring.encryptToken(token); err != nil {
+			return nil, err
+		}
+		return json.Marshal(userInfo)
+	})
+}
+
+func (p *Plugin) reencryptAppStoreCredentials(keyring *encryptionKeyring, key string) error {
+	return p.reencryptValue(key, func(b []byte) ([]byte, error) {
+		var credentials AppStoreCredentials
+		if err := json.Unmarshal(b, &credentials); err != nil {
+			return nil, err
+		}
+
+		privateKey, _, err := keyring.decrypt(credentials.PrivateKey)
+		if err != nil {
+			return nil, err
+		}
+		if credentials.PrivateKey, err = keyring.encrypt(privateKey); err != nil {
+			return nil, err
+		}
+		return json.Marshal(credentials)
+	})
+}
+
+// reencryptValue replaces the value of the key with the result of reencrypt, reading it again if another
+// request changed it meanwhile, like a token refresh
+func (p *Plugin) reencryptValue(key string, reencrypt func([]byte) ([]byte, error)) error {
+	for attempt := 0; attempt < maxReencryptRetries; attempt++ {
+		b, appErr := p.API.KVGet(key)
+		if appErr != nil {
+			return appErr
+		}
+		if b == nil {
+			return nil
+		}
+
+		newBytes, err := reencrypt(b)
+		if err != nil {
+			return err
+		}
+		ok, appErr := p.API.KVCompareAndSet(key, b, newBytes)
+		if appErr != nil {
+			return appErr
+		}
+		if ok {
+			return nil
+		}
+	}
+	return errors.New("the value kept changing while re-encrypting it")
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+const (
+	testKeyA = "0123456789abcdef0123456789abcdef"
+	testKeyB = "fedcba9876543210fedcba9876543210"
+)
+
+// encryptCFB writes values the way the plugin stored them before the authenticated encryption
+func encryptCFB(t *testing.T, key string, text string) string {
+	block, err := aes.NewCipher([]byte(key))
+	require.NoError(t, err)
+
+	padding := aes.BlockSize - len(text)%aes.BlockSize
+	msg := append([]byte(text), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	ciphertext := make([]byte, aes.BlockSize+len(msg))
+	cipher.NewCFBEncrypter(block, ciphertext[:aes.BlockSize]).XORKeyStream(ciphertext[aes.BlockSize:], msg)
+	return base64.URLEncoding.EncodeToString(ciphertext)
+}
+
+func TestEncryptionKeyring(t *testing.T) {
+	oldKeyring := newEncryptionKeyring(testKeyA, nil)
+	encrypted, err := oldKeyring.encrypt("token")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(encrypted, getKeyID([]byte(testKeyA))+keyIDSeparator))
+
+	plain, upgrade, err := oldKeyring.decrypt(encrypted)
+	require.NoError(t, err)
+	assert.Equal(t, "token", plain)
+	assert.False(t, upgrade)
+
+	rotatedKeyring := newEncryptionKeyring(testKeyB, []string{testKeyA})
+	plain, upgrade, err = rotatedKeyring.decrypt(encrypted)
+	require.NoError(t, err)
+	assert.Equal(t, "token", plain)
+	assert.True(t, upgrade)
+
+	_, _, err = newEncryptionKeyring(testKeyB, nil).decrypt(encrypted)
+	assert.Error(t, err)
+
+	tampered := encrypted[:len(encrypted)-4] + "AAA="
+	_, _, err = oldKeyring.decrypt(tampered)
+	assert.Error(t, err)
+}
+
+func TestEncryptionKeyringLegacyValues(t *testing.T) {
+	legacy := encryptCFB(t, testKeyA, "token")
+
+	plain, upgrade, err := newEncryptionKeyring(testKeyB, []string{testKeyA}).decrypt(legacy)
+	require.NoError(t, err)
+	assert.Equal(t, "token", plain)
+	assert.True(t, upgrade)
+}
+
+func TestEncryptionKeyringTokens(t *testing.T) {
+	keyring := newEncryptionKeyring(testKeyA, nil)
+	token := &oauth2.Token{AccessToken: "access", RefreshToken: "1//refresh"}
+
+	encrypted, err := keyring.encryptToken(token)
+	require.NoError(t, err)
+	assert.NotContains(t, encrypted.AccessToken, "access")
+	assert.NotContains(t, encrypted.RefreshToken, "refresh")
+	assert.Equal(t, "1//refresh", token.RefreshToken)
+
+	decrypted, upgrade, err := keyring.decryptToken(encrypted)
+	require.NoError(t, err)
+	assert.Equal(t, token, decrypted)
+	assert.False(t, upgrade)
+
+	// A token encrypted with AES-GCM never has a plain refresh token, so it is taken as corrupted
+	encrypted.RefreshToken = "1//refresh"
+	_, _, err = keyring.decryptToken(encrypted)
+	assert.Error(t, err)
+
+	// Refresh tokens stored before they were encrypted, next to a CFB access token, are kept, asking to store them again
+	legacy := &oauth2.Token{AccessToken: encryptCFB(t, testKeyA, "access"), RefreshToken: "1//refresh"}
+	decrypted, upgrade, err = keyring.decryptToken(legacy)
+	require.NoError(t, err)
+	assert.Equal(t, token, decrypted)
+	assert.True(t, upgrade)
+}
+
+func TestReencryptTokenChangedMeanwhile(t *testing.T) {
+	p := newTestPlugin()
+	api := p.API.(*plugintest.API)
+	keyring := newEncryptionKeyring(testKeyB, []string{testKeyA})
+	encrypted, err := newEncryptionKeyring(testKeyA, nil).encryptToken(&oauth2.Token{AccessToken: "access"})
+	require.NoError(t, err)
+	b, err := json.Marshal(GooglePlayUserInfo{UserID: "user", Token: encrypted})
+	require.NoError(t, err)
+
+	key := getTokenKey("user", "")
+	api.On("KVGet", key).Return(b, nil)
+	api.On("KVCompareAndSet", key, b, mock.Anything).Return(false, nil)
+	assert.Error(t, p.reencryptToken(keyring, key), "a token changed by another request is not counted as re-encrypted")
+	api.AssertNumberOfCalls(t, "KVCompareAndSet", maxReencryptRetries)
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+const (
+	maxDeliveryAttempts = 4
+	// maxDeadLetters is the amount of undelivered payloads kept. The oldest ones are dropped past it.
+	maxDeadLetters = 200
+	// maxDeadLettersSize is the amount of bytes of payloads and errors kept, as all the dead letters share a KV value
+	maxDeadLettersSize = 1 << 20
+	// maxDeliveryErrorBody is the amount of bytes of an error answer kept on the dead letter
+	maxDeliveryErrorBody = 512
+
+	signatureHeader = "X-Gpreviews-Signature"
+	timestampHeader = "X-Gpreviews-Timestamp"
+)
+
+// deliveryBackoff is the wait before the first retry, doubled on every next one
+var deliveryBackoff = 2 * time.Second
+
+var deliveryClient = &http.Client{Timeout: 10 * time.Second}
+
+// DeadLetter is a webhook payload not delivered after all the retries
+type DeadLetter struct {
+	ID string
+	// Type is the kind of alert of the payload, like newReviews or sla
+	Type      string
+	Webhook   string
+	Payload   string
+	Attempts  int
+	LastError string
+	FailedAt  time.Time
+}
+
+// deliveryError is an answer of the webhook out of the 2xx range
+type deliveryError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *deliveryError) Error() string {
+	return fmt.Sprintf("webhook answered %d: %s", e.StatusCode, e.Body)
+}
+
+// isRetryableDelivery checks if the delivery may succeed later. Network errors, timeouts, 408, 429 and 5xx answers are retried.
+func isRetryableDelivery(err error) bool {
+	deliveryErr, ok := err.(*deliveryError)
+	if !ok {
+		return true
+	}
+	return deliveryErr.StatusCode == http.StatusRequestTimeout || deliveryErr.StatusCode == http.StatusTooManyRequests || deliveryErr.StatusCode >= 500
+}
+
+// signWebhookPayload returns the HMAC-SHA256 of the timestamp and the payload joined by a dot, as the signature header expects it
+func signWebhookPayload(secret string, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// postWebhookPayload makes one delivery attempt, signing the payload if there is a secret
+func postWebhookPayload(webhook string, payload []byte, secret string) error {
+	request, err := http.NewRequest(http.MethodPost, webhook, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if secret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		request.Header.Set(timestampHeader, timestamp)
+		request.Header.Set(signatureHeader, signWebhookPayload(secret, timestamp, payload))
+	}
+
+	response, err := deliveryClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	body, _ := ioutil.ReadAll(io.LimitReader(response.Body, maxDeliveryErrorBody))
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return &deliveryError{StatusCode: response.StatusCode, Body: strings.TrimSpace(string(body))}
+	}
+	return nil
+}
+
+// deliverWebhookPayload posts the payload, retrying with exponential backoff, and returns the amount of attempts made
+func (p *Plugin) deliverWebhookPayload(webhook string, payload []byte) (int, error) {
+	secret := p.getConfiguration().WebhookSigningSecret
+	delay := deliveryBackoff
+	for attempt := 1; ; attempt++ {
+		err := postWebhookPayload(webhook, payload, secret)
+		if err == nil {
+			return attempt, nil
+		}
+		if attempt == maxDeliveryAttempts || !isRetryableDelivery(err) {
+			return attempt, err
+		}
+		p.logDebug("Retrying webhook delivery", "attempt", attempt, "err", err.Error())
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+func (p *Plugin) sendWebhookText(deliveryType string, webhook string, text string) error {
+	return p.sendWebhookPost(deliveryType, webhook, text, nil)
+}
+
+// sendWebhookPost posts the text and the attachments on the incoming webhook. Payloads not delivered are kept as dead letters.
+func (p *Plugin) sendWebhookPost(deliveryType string, webhook string, text string, attachments []*model.SlackAttachment) error {
+	request := model.IncomingWebhookRequest{
+		Text:        text,
+		Attachments: attachments,
+	}
+
+	payload, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	attempts, err := p.deliverWebhookPayload(webhook, payload)
+	if err != nil {
+		p.addDeadLetter(&DeadLetter{
+			ID:        model.NewId(),
+			Type:      deliveryType,
+			Webhook:   webhook,
+			Payload:   string(payload),
+			Attempts:  attempts,
+			LastError: err.Error(),
+			FailedAt:  time.Now(),
+		})
+	}
+	return err
+}
+
+func (p *Plugin) getDeadLetters() []*DeadLetter {
+	p.control.deadLettersMutex.Lock()
+	defer p.control.deadLettersMutex.Unlock()
+	return append([]*DeadLetter{}, p.deadLetters...)
+}
+
+func (p *Plugin) addDeadLetter(deadLetter *DeadLetter) {
+	p.updateDeadLetters(func(deadLetters []*DeadLetter) []*DeadLetter {
+		return append(deadLetters, deadLetter)
+	})
+	p.logWarn("Webhook payload kept as dead letter", "id", deadLetter.ID, "type", deadLetter.Type, "attempts", deadLetter.Attempts, "err", deadLetter.LastError)
+}
+
+// editDeadLetter replaces the dead letter with the result of edit, or removes it if edit returns nil
+func (p *Plugin) editDeadLetter(id string, edit func(*DeadLetter) *DeadLetter) bool {
+	found := false
+	p.updateDeadLetters(func(deadLetters []*DeadLetter) []*DeadLetter {
+		for i, deadLetter := range deadLetters {
+			if deadLetter.ID != id {
+				continue
+			}
+			found = true
+			if edited := edit(deadLetter); edited != nil {
+				deadLetters[i] = edited
+			} else {
+				deadLetters = append(deadLetters[:i], deadLetters[i+1:]...)
+			}
+			break
+		}
+		return deadLetters
+	})
+	return found
+}
+
+// updateDeadLetters applies update over the dead letters stored by all the nodes, and stores the result within the
+// limits. The dead letters are read again first, and again if another node stored its own meanwhile, so the ones
+// added on other nodes are not overwritten.
+func (p *Plugin) updateDeadLetters(update func([]*DeadLetter) []*DeadLetter) {
+	p.control.deadLettersMutex.Lock()
+	defer p.control.deadLettersMutex.Unlock()
+
+	for attempt := 0; attempt < maxSharedStateRetries; attempt++ {
+		p.persistency.LoadDeadLetters(&p.deadLetters)
+		p.deadLetters = capDeadLetters(update(append([]*DeadLetter{}, p.deadLetters...)))
+		if err := p.persistency.SaveDeadLetters(p.deadLetters); err != errStateChanged {
+			return
+		}
+	}
+	p.logError("Error saving the dead letters, other nodes kept changing them")
+}
+
+// capDeadLetters keeps the newest dead letters within maxDeadLetters and maxDeadLettersSize, dropping the oldest ones.
+// A dead letter over maxDeadLettersSize on its own is dropped, keeping the older ones.
+func capDeadLetters(deadLetters []*DeadLetter) []*DeadLetter {
+	size := 0
+	first := len(deadLetters)
+	capped := []*DeadLetter{}
+	for first > 0 && len(capped) < maxDeadLetters {
+		deadLetter := deadLetters[first-1]
+		first--
+		letterSize := len(deadLetter.Payload) + len(deadLetter.LastError)
+		if letterSize > maxDeadLettersSize {
+			continue
+		}
+		size += letterSize
+		if size > maxDeadLettersSize {
+			break
+		}
+		capped = append([]*DeadLetter{deadLetter}, capped...)
+	}
+	return capped
+}
+
+// replayDeadLetter delivers the payload again, without retries, removing it from the dead letters if it succeeds
+func (p *Plugin) replayDeadLetter(deadLetter *DeadLetter) error {
+	err := postWebhookPayload(deadLetter.Webhook, []byte(deadLetter.Payload), p.getConfiguration().WebhookSigningSecret)
+	p.recordAlertDelivery(deadLetter.Type, err)
+	p.editDeadLetter(deadLetter.ID, func(current *DeadLetter) *DeadLetter {
+		if err == nil {
+			return nil
+		}
+		updated := *current
+		updated.Attempts++
+		updated.LastError = err.Error()
+		updated.FailedAt = time.Now()
+		return &updated
+	})
+	return err
+}
+
+// selectDeadLetters returns the dead letter with the id, or all of them with "all"
+func (p *Plugin) selectDeadLetters(id string) []*DeadLetter {
+	selected := []*DeadLetter{}
+	for _, deadLetter := range p.getDeadLetters() {
+		if id == "all" || deadLetter.ID == id {
+			selected = append(selected, deadLetter)
+		}
+	}
+	return selected
+}
+
+// getWebhookHost hides the path of the webhook, as it is the secret of Mattermost incoming webhooks
+func getWebhookHost(webhook string) string {
+	parsed, err := url.Parse(webhook)
+	if err != nil || parsed.Host == "" {
+		return "invalid URL"
+	}
+	return parsed.Host
+}
+
+func (p *Plugin) serveDeadLetters(args []string, userID string) (*model.CommandResponse, *model.AppError) {
+	var message string
+
+	if len(args) > 4 {
+		message += fmt.Sprintf(":x:Wrong use: `%s %s %s [id]`", args[0], args[1], args[2])
+		return commandErrorResponse(message)
+	}
+
+	if len(args) == 4 {
+		selected := p.selectDeadLetters(args[3])
+		if len(selected) != 1 {
+			message += fmt.Sprintf(":x:There is no dead letter with id **%s**.", args[3])
+			return commandErrorResponse(message)
+		}
+		deadLetter := selected[0]
+		message += fmt.Sprintf("## Dead letter **%s**\n", deadLetter.ID)
+		message += fmt.Sprintf("Type **%s** to **%s**, failed at _%s_ after **%d** attempts: %s\n", deadLetter.Type, getWebhookHost(deadLetter.Webhook), deadLetter.FailedAt.Local(), deadLetter.Attempts, deadLetter.LastError)
+		message += fmt.Sprintf("```json\n%s\n```", deadLetter.Payload)
+		return commandStatusResponse(message)
+	}
+
+	deadLetters := p.getDeadLetters()
+	if len(deadLetters) == 0 {
+		message += "There are no undelivered webhook payloads."
+		return commandStatusResponse(message)
+	}
+	message += fmt.Sprintf("## Here are the **%d** undelivered webhook payloads:\n", len(deadLetters))
+	for _, deadLetter := range deadLetters {
+		message += fmt.Sprintf("* **%s**: **%s** to **%s**, failed at _%s_ after **%d** attempts: %s\n", deadLetter.ID, deadLetter.Type, getWebhookHost(deadLetter.Webhook), deadLetter.FailedAt.Local(), deadLetter.Attempts, deadLetter.LastError)
+	}
+	return commandStatusResponse(message)
+}
+
+func (p *Plugin) replayDeadLetters(args []string, userID string) (*model.CommandResponse, *model.AppError) {
+	var message string
+
+	if len(args) != 4 {
+		message += fmt.Sprintf(":x:Wrong use: `%s %s %s id|all`", args[0], args[1], args[2])
+		return commandErrorResponse(message)
+	}
+
+	selected := p.selectDeadLetters(args[3])
+	if len(selected) == 0 {
+		message += fmt.Sprintf(":x:There is no dead letter with id **%s**.", args[3])
+		return commandErrorResponse(message)
+	}
+
+	failed := 0
+	for _, deadLetter := range selected {
+		if err := p.replayDeadLetter(deadLetter); err != nil {
+			p.logError("Error replaying dead letter", "id", deadLetter.ID, "type", deadLetter.Type, "err", err.Error())
+			failed++
+		}
+	}
+	if failed > 0 {
+		message += fmt.Sprintf(":x:**%d** of **%d** payloads could not be delivered again. They are kept as dead letters.", failed, len(selected))
+		return commandErrorResponse(message)
+	}
+
+	message += fmt.Sprintf(":white_check_mark:**%d** payloads delivered.", len(selected))
+	return commandStatusResponse(message)
+}
+
+func (p *Plugin) discardDeadLetters(args []string, userID string) (*model.CommandResponse, *model.AppError) {
+	var message string
+
+	if len(args) != 4 {
+		message += fmt.Sprintf(":x:Wrong use: `%s %s %s id|all`", args[0], args[1], args[2])
+		return commandErrorResponse(message)
+	}
+
+	discarded := 0
+	for _, deadLetter := range p.selectDeadLetters(args[3]) {
+		if p.editDeadLetter(deadLetter.ID, func(*DeadLetter) *DeadLetter { return nil }) {
+			discarded++
+		}
+	}
+	if discarded == 0 {
+		message += fmt.Sprintf(":x:There is no dead letter with id **%s**.", args[3])
+		return commandErrorResponse(message)
+	}
+
+	message += fmt.Sprintf(":white_check_mark:**%d** dead letters discarded.", discarded)
+	return commandStatusResponse(message)
+}
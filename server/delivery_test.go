@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSendWebhookPostRetriesAndSigns(t *testing.T) {
+	deliveryBackoff = time.Millisecond
+	defer func() { deliveryBackoff = 2 * time.Second }()
+
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		body, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		assert.Equal(t, signWebhookPayload("secret", r.Header.Get(timestampHeader), body), r.Header.Get(signatureHeader))
+		if attempts < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	p := newTestPlugin()
+	p.configuration.WebhookSigningSecret = "secret"
+	require.NoError(t, p.sendWebhookText("newReviews", server.URL, "text"))
+	assert.Equal(t, 3, attempts)
+	assert.Empty(t, p.getDeadLetters())
+}
+
+func TestSendWebhookPostDeadLetters(t *testing.T) {
+	deliveryBackoff = time.Millisecond
+	defer func() { deliveryBackoff = 2 * time.Second }()
+
+	status := http.StatusBadRequest
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	p := newTestPlugin()
+	assert.Error(t, p.sendWebhookText("sla", server.URL, "permanent"))
+	assert.Equal(t, 1, attempts)
+
+	status = http.StatusInternalServerError
+	assert.Error(t, p.sendWebhookText("sla", server.URL, "temporary"))
+	assert.Equal(t, 1+maxDeliveryAttempts, attempts)
+
+	deadLetters := p.getDeadLetters()
+	require.Len(t, deadLetters, 2)
+	assert.Equal(t, "sla", deadLetters[0].Type)
+	assert.Equal(t, 1, deadLetters[0].Attempts)
+	assert.Equal(t, maxDeliveryAttempts, deadLetters[1].Attempts)
+	assert.Equal(t, "webhook answered 500: ", deadLetters[1].LastError)
+
+	assert.Error(t, p.replayDeadLetter(deadLetters[0]))
+	assert.Equal(t, 2, p.getDeadLetters()[0].Attempts)
+
+	status = http.StatusOK
+	assert.NoError(t, p.replayDeadLetter(deadLetters[0]))
+	require.Len(t, p.getDeadLetters(), 1)
+	assert.Equal(t, deadLetters[1].ID, p.getDeadLetters()[0].ID)
+}
+
+func TestDeadLettersSharedAcrossNodes(t *testing.T) {
+	api := &plugintest.API{}
+	newTestKVStore(api)
+	leader := newTestClusterNode(api)
+	follower := newTestClusterNode(api)
+
+	leader.addDeadLetter(&DeadLetter{ID: "first", Payload: "payload"})
+	follower.addDeadLetter(&DeadLetter{ID: "second", Payload: "payload"})
+	leader.LoadDeadLetters()
+	require.Len(t, leader.getDeadLetters(), 2, "the dead letters added on other nodes are kept")
+	assert.Equal(t, "first", leader.getDeadLetters()[0].ID)
+
+	assert.True(t, leader.editDeadLetter("second", func(*DeadLetter) *DeadLetter { return nil }))
+	follower.LoadDeadLetters()
+	require.Len(t, follower.getDeadLetters(), 1)
+	assert.Equal(t, "first", follower.getDeadLetters()[0].ID)
+
+	// The follower stores a dead letter after the leader read them and before it stores its own
+	attempts := 0
+	leader.updateDeadLetters(func(deadLetters []*DeadLetter) []*DeadLetter {
+		attempts++
+		if attempts == 1 {
+			follower.addDeadLetter(&DeadLetter{ID: "third", Payload: "payload"})
+		}
+		return append(deadLetters, &DeadLetter{ID: "fourth", Payload: "payload"})
+	})
+	assert.Equal(t, 2, attempts, "the leader adds its dead letter again to the ones of the follower")
+	follower.LoadDeadLetters()
+	require.Len(t, follower.getDeadLetters(), 3)
+	assert.Equal(t, "third", follower.getDeadLetters()[1].ID)
+	assert.Equal(t, "fourth", follower.getDeadLetters()[2].ID)
+}
+
+func TestCapDeadLetters(t *testing.T) {
+	payload := strings.Repeat("x", maxDeadLettersSize/4)
+	deadLetters := []*DeadLetter{}
+	for i := 0; i < 5; i++ {
+		deadLetters = append(deadLetters, &DeadLetter{ID: fmt.Sprintf("big%d", i), Payload: payload})
+	}
+	capped := capDeadLetters(deadLetters)
+	require.Len(t, capped, 4, "the oldest dead letters are dropped past the size limit")
+	assert.Equal(t, "big1", capped[0].ID)
+	assert.Equal(t, "big4", capped[3].ID)
+
+	deadLetters = []*DeadLetter{}
+	for i := 0; i < maxDeadLetters+1; i++ {
+		deadLetters = append(deadLetters, &DeadLetter{ID: fmt.Sprintf("small%d", i)})
+	}
+	capped = capDeadLetters(deadLetters)
+	require.Len(t, capped, maxDeadLetters)
+	assert.Equal(t, "small1", capped[0].ID)
+
+	deadLetters = []*DeadLetter{{ID: "small"}, {ID: "huge", Payload: strings.Repeat("x", maxDeadLettersSize+1)}}
+	capped = capDeadLetters(deadLetters)
+	require.Len(t, capped, 1, "a payload over the size limit on its own does not drop the others")
+	assert.Equal(t, "small", capped[0].ID)
+}
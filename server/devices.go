@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	maxBreakdownRows = 10
+	// Devices with at least minFlaggedReviews reviews and an average rating flaggedRatingGap stars below the app average are flagged
+	minFlaggedReviews = 3
+	flaggedRatingGap  = 1.0
+)
+
+type breakdownEntry struct {
+	name     string
+	count    int
+	lowStars int
+	stars    int64
+	metadata *DeviceMetadata
+}
+
+func (e *breakdownEntry) averageStars() float64 {
+	return float64(e.stars) / float64(e.count)
+}
+
+// getBreakdown groups the reviews by the given key, sorted by the amount of 1-2 star reviews
+func getBreakdown(reviews []*Review, getKey func(*Review) string) []*breakdownEntry {
+	entries := make(map[string]*breakdownEntry)
+	for _, review := range reviews {
+		key := getKey(review)
+		entry, ok := entries[key]
+		if !ok {
+			entry = &breakdownEntry{name: key}
+			entries[key] = entry
+		}
+		entry.count++
+		entry.stars += review.Rating
+		if review.isLowRated() {
+			entry.lowStars++
+		}
+		if entry.metadata == nil {
+			entry.metadata = review.DeviceMetadata
+		}
+	}
+
+	breakdown := []*breakdownEntry{}
+	for _, entry := range entries {
+		breakdown = append(breakdown, entry)
+	}
+	sort.Slice(breakdown, func(i, j int) bool {
+		if breakdown[i].lowStars != breakdown[j].lowStars {
+			return breakdown[i].lowStars > breakdown[j].lowStars
+		}
+		if breakdown[i].count != breakdown[j].count {
+			return breakdown[i].count > breakdown[j].count
+		}
+		return breakdown[i].name < breakdown[j].name
+	})
+	return breakdown
+}
+
+func getDeviceKey(review *Review) string {
+	name := review.Device
+	if review.DeviceMetadata != nil && review.DeviceMetadata.ProductName != "" {
+		name = fmt.Sprintf("%s (%s)", review.DeviceMetadata.ProductName, review.Device)
+	}
+	if name == "" {
+		return "Unknown"
+	}
+	return name
+}
+
+func getManufacturerKey(review *Review) string {
+	if review.DeviceMetadata == nil || review.DeviceMetadata.Manufacturer == "" {
+		return "Unknown"
+	}
+	return review.DeviceMetadata.Manufacturer
+}
+
+func getOSVersionKey(review *Review) string {
+	if review.AndroidOSVersion == 0 {
+		return "Unknown"
+	}
+	return fmt.Sprintf("API %d", review.AndroidOSVersion)
+}
+
+func formatDeviceMetadata(metadata *DeviceMetadata) string {
+	if metadata == nil {
+		return ""
+	}
+	specs := []string{}
+	if metadata.Manufacturer != "" {
+		specs = append(specs, metadata.Manufacturer)
+	}
+	if metadata.RAMMb > 0 {
+		specs = append(specs, fmt.Sprintf("%dMB RAM", metadata.RAMMb))
+	}
+	if metadata.ScreenDensityDpi > 0 {
+		specs = append(specs, fmt.Sprintf("%ddpi", metadata.ScreenDensityDpi))
+	}
+	if metadata.GlEsVersion > 0 {
+		// The OpenGL ES version is encoded with the major version on the upper 16 bits
+		specs = append(specs, fmt.Sprintf("GL ES %d.%d", metadata.GlEsVersion>>16, metadata.GlEsVersion&0xffff))
+	}
+	if cpu := strings.TrimSpace(metadata.CPUMake + " " + metadata.CPUModel); cpu != "" {
+		specs = append(specs, cpu)
+	}
+	return strings.Join(specs, ", ")
+}
+
+func formatBreakdown(title string, breakdown []*breakdownEntry, appAverage float64, withSpecs bool) string {
+	text := fmt.Sprintf("#### %s\n", title)
+	if withSpecs {
+		text += fmt.Sprintf("| %s | 1-2 star reviews | Reviews | Average stars | Specs |\n|:--|:--|:--|:--|:--|\n", title)
+	} else {
+		text += fmt.Sprintf("| %s | 1-2 star reviews | Reviews | Average stars |\n|:--|:--|:--|:--|\n", title)
+	}
+
+	for _, entry := range breakdown[:min(len(breakdown), maxBreakdownRows)] {
+		flag := ""
+		if entry.count >= minFlaggedReviews && entry.averageStars() <= appAverage-flaggedRatingGap {
+			flag = ":warning: "
+		}
+		text += fmt.Sprintf("| %s%s | %d | %d | %.2f |", flag, entry.name, entry.lowStars, entry.count, entry.averageStars())
+		if withSpecs {
+			text += fmt.Sprintf(" %s |", formatDeviceMetadata(entry.metadata))
+		}
+		text += "\n"
+	}
+	return text
+}
+
+// getDevicesReport ranks devices, manufacturers and OS versions by the amount of 1-2 star reviews
+func getDevicesReport(packageName string, reviews []*Review) string {
+	if len(reviews) == 0 {
+		return fmt.Sprintf("There are no reviews cached for **%s** yet.", packageName)
+	}
+
+	var stars int64
+	for _, review := range reviews {
+		stars += review.Rating
+	}
+	appAverage := float64(stars) / float64(len(reviews))
+
+	text := fmt.Sprintf("## Devices report for **%s** (**%d** reviews, **%.2f** average stars):\n", packageName, len(reviews), appAverage)
+	text += fmt.Sprintf(":warning: marks the ones with at least %d reviews and an average rating %.1f stars below the app average.\n", minFlaggedReviews, flaggedRatingGap)
+	text += formatBreakdown("Devices", getBreakdown(reviews, getDeviceKey), appAverage, true)
+	text += formatBreakdown("Manufacturers", getBreakdown(reviews, getManufacturerKey), appAverage, false)
+	text += formatBreakdown("Android versions", getBreakdown(reviews, getOSVersionKey), appAverage, false)
+	return text
+}
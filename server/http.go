@@ -7,8 +7,6 @@ import (
 	"net/http"
 	"strings"
 
-	"github.com/mattermost/mattermost-server/v5/mlog"
-	"github.com/mattermost/mattermost-server/v5/model"
 	"github.com/mattermost/mattermost-server/v5/plugin"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
@@ -22,11 +20,34 @@ func (p *Plugin) ServeHTTP(c *plugin.Context, w http.ResponseWriter, r *http.Req
 
 	w.Header().Set("Content-Type", "application/json")
 
+	// The drafts and reviews may have been changed on another node of the cluster
+	if strings.HasPrefix(r.URL.Path, "/approval/") || strings.HasPrefix(r.URL.Path, "/reviews/") {
+		p.loadSharedState()
+	}
+
 	switch path := r.URL.Path; path {
 	case "/oauth/connect":
 		p.connectUserToGooglePlay(w, r)
 	case "/oauth/complete":
 		p.completeConnectUserToGooglePlay(w, r)
+	case "/metrics":
+		p.serveMetrics(w, r)
+	case "/approval/approve":
+		p.approveDraft(w, r)
+	case "/approval/reject":
+		p.rejectDraft(w, r)
+	case "/approval/edit":
+		p.openEditDraftDialog(w, r)
+	case "/approval/edit/submit":
+		p.submitEditDraftDialog(w, r)
+	case "/reviews/translate":
+		p.translateReview(w, r)
+	case "/reviews/expand":
+		p.expandReview(w, r)
+	case "/reviews/issue":
+		p.createIssueFromAction(w, r)
+	case "/appstore/connect/submit":
+		p.submitAppStoreConnectDialog(w, r)
 	default:
 		http.NotFound(w, r)
 	}
@@ -41,15 +62,32 @@ func (p *Plugin) connectUserToGooglePlay(w http.ResponseWriter, r *http.Request)
 
 	conf := p.getOAuthConfig(userID)
 
-	state := fmt.Sprintf("%v_%v", model.NewId()[0:15], userID)
+	accountName := getAccountName(r.URL.Query().Get("account"))
+	if !isValidAccountName(accountName) {
+		http.Error(w, "invalid account name", http.StatusBadRequest)
+		return
+	}
 
-	p.API.KVSet(state, []byte(state))
+	token, state, err := p.createOAuthState(userID, r.URL.Query().Get("channel_id"), accountName)
+	if err != nil {
+		p.logError("Error storing OAuth state", "userID", userID, "status", http.StatusInternalServerError, "err", err.Error())
+		http.Error(w, "Unable to start the connection to GooglePlay", http.StatusInternalServerError)
+		return
+	}
 
-	url := conf.AuthCodeURL(state, oauth2.AccessTypeOffline)
+	url := conf.AuthCodeURL(token, getPKCEAuthOptions(state.Verifier)...)
 
 	http.Redirect(w, r, url, http.StatusFound)
 }
 
+func (p *Plugin) getPluginURL() string {
+	siteURL := *p.API.GetConfig().ServiceSettings.SiteURL
+	if i := len(siteURL); i > 0 && siteURL[i-1] == '/' {
+		siteURL = siteURL[:i-1]
+	}
+	return siteURL + "/plugins/" + manifest.Id
+}
+
 func (p *Plugin) getOAuthConfig(userID string) *oauth2.Config {
 	apiConfig := p.API.GetConfig()
 	siteURL := *apiConfig.ServiceSettings.SiteURL
@@ -82,106 +120,101 @@ func (p *Plugin) completeConnectUserToGooglePlay(w http.ResponseWriter, r *http.
 	ctx := context.Background()
 	conf := p.getOAuthConfig(authedUserID)
 
-	code := r.URL.Query().Get("code")
-	if len(code) == 0 {
-		http.Error(w, "missing authorization code", http.StatusBadRequest)
+	state, err := p.consumeOAuthState(r.URL.Query().Get("state"))
+	if err != nil {
+		p.logWarn("Invalid OAuth state", "userID", authedUserID, "status", http.StatusBadRequest, "err", err.Error())
+		http.Error(w, "invalid state", http.StatusBadRequest)
 		return
 	}
 
-	state := r.URL.Query().Get("state")
-
-	if storedState, err := p.API.KVGet(state); err != nil {
-		fmt.Println(err.Error())
-		http.Error(w, "missing stored state", http.StatusBadRequest)
-		return
-	} else if string(storedState) != state {
-		http.Error(w, "invalid state", http.StatusBadRequest)
+	if state.UserID != authedUserID {
+		p.logWarn("OAuth completed by a different user", "userID", authedUserID, "status", http.StatusUnauthorized)
+		http.Error(w, "Not authorized, incorrect user", http.StatusUnauthorized)
 		return
 	}
+	userID := state.UserID
 
-	userID := strings.Split(state, "_")[1]
-
-	p.API.KVDelete(state)
-
-	if userID != authedUserID {
-		http.Error(w, "Not authorized, incorrect user", http.StatusUnauthorized)
+	code := r.URL.Query().Get("code")
+	if len(code) == 0 {
+		message := "Google Play connection cancelled or denied."
+		if reason := r.URL.Query().Get("error"); reason != "" {
+			message = fmt.Sprintf("Google Play connection failed: %s.", reason)
+		}
+		p.notifyConnectResult(state, ":x:"+message)
+		writeConnectResultPage(w, http.StatusBadRequest, message)
 		return
 	}
 
-	tok, err := conf.Exchange(ctx, code)
+	tok, err := conf.Exchange(ctx, code, oauth2.SetAuthURLParam("code_verifier", state.Verifier))
 	if err != nil {
-		fmt.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		p.logError("Error exchanging OAuth code", "userID", userID, "status", http.StatusInternalServerError, "err", err.Error())
+		p.notifyConnectResult(state, ":x:Google Play connection failed: unable to get the access token.")
+		writeConnectResultPage(w, http.StatusInternalServerError, "Google Play connection failed: unable to get the access token.")
 		return
 	}
 
 	userInfo := &GooglePlayUserInfo{
-		UserID: userID,
-		Token:  tok,
+		UserID:      userID,
+		AccountName: state.AccountName,
+		Token:       tok,
 	}
 
 	if err := p.storeGooglePlayUserInfo(userInfo); err != nil {
-		fmt.Println(err.Error())
-		http.Error(w, "Unable to connect user to GooglePlay:"+err.Error(), http.StatusInternalServerError)
+		p.logError("Error storing Google Play user info", "userID", userID, "account", state.AccountName, "status", http.StatusInternalServerError, "err", err.Error())
+		p.notifyConnectResult(state, ":x:Google Play connection failed: unable to store the access token.")
+		writeConnectResultPage(w, http.StatusInternalServerError, "Google Play connection failed: unable to store the access token.")
 		return
 	}
 
-	html := `
-		<!DOCTYPE html>
-		<html>
-			<head>
-				<script>
-					window.close();
-				</script>
-			</head>
-			<body>
-				<p>Completed connecting to GooglePlay. Please close this window.</p>
-			</body>
-		</html>
-		`
-
-	w.Header().Set("Content-Type", "text/html")
-	w.Write([]byte(html))
-}
+	if err := p.addGooglePlayAccount(userID, state.AccountName); err != nil {
+		p.logError("Error storing Google Play connection name", "userID", userID, "account", state.AccountName, "status", http.StatusInternalServerError, "err", err.Error())
+		p.notifyConnectResult(state, ":x:Google Play connection failed: unable to store the connection name.")
+		writeConnectResultPage(w, http.StatusInternalServerError, "Google Play connection failed: unable to store the connection name.")
+		return
+	}
 
-func (p *Plugin) getGooglePlayUserInfo(userID string) (*GooglePlayUserInfo, error) {
-	config := p.getConfiguration()
+	p.notifyConnectResult(state, fmt.Sprintf(":white_check_mark:Google Play account connected as **%s**.", state.AccountName))
+	writeConnectResultPage(w, http.StatusOK, fmt.Sprintf("Google Play account connected as %s.", state.AccountName))
+}
 
+func (p *Plugin) getGooglePlayUserInfo(userID string, accountName string) (*GooglePlayUserInfo, error) {
 	var userInfo GooglePlayUserInfo
 
-	if infoBytes, err := p.API.KVGet(userID + GooglePlayTokenKey); err != nil || infoBytes == nil {
+	if infoBytes, err := p.API.KVGet(getTokenKey(userID, accountName)); err != nil || infoBytes == nil {
 		return nil, fmt.Errorf("must connect user account to GooglePlay first")
 	} else if err := json.Unmarshal(infoBytes, &userInfo); err != nil {
 		return nil, fmt.Errorf("unable to parse token")
 	}
 
-	unencryptedToken, err := decrypt([]byte(config.EncryptionKey), userInfo.Token.AccessToken)
+	unencryptedToken, upgrade, err := p.getEncryptionKeyring().decryptToken(userInfo.Token)
 	if err != nil {
-		mlog.Error(err.Error())
-		return nil, fmt.Errorf("unable to decrypt access token")
+		p.logError("Error decrypting token", "userID", userID, "err", err.Error())
+		return nil, fmt.Errorf("unable to decrypt token")
 	}
 
-	userInfo.Token.AccessToken = unencryptedToken
+	userInfo.Token = unencryptedToken
+
+	if upgrade {
+		if err := p.storeGooglePlayUserInfo(&userInfo); err != nil {
+			p.logWarn("Error encrypting access token with the current key", "userID", userID, "err", err.Error())
+		}
+	}
 
 	return &userInfo, nil
 }
 
 func (p *Plugin) storeGooglePlayUserInfo(info *GooglePlayUserInfo) error {
-	config := p.getConfiguration()
-
-	encryptedToken, err := encrypt([]byte(config.EncryptionKey), info.Token.AccessToken)
+	encryptedToken, err := p.getEncryptionKeyring().encryptToken(info.Token)
 	if err != nil {
 		return err
 	}
 
-	info.Token.AccessToken = encryptedToken
-
-	jsonInfo, err := json.Marshal(info)
+	jsonInfo, err := json.Marshal(&GooglePlayUserInfo{UserID: info.UserID, AccountName: info.AccountName, Token: encryptedToken})
 	if err != nil {
 		return err
 	}
 
-	if err := p.API.KVSet(info.UserID+GooglePlayTokenKey, jsonInfo); err != nil {
+	if err := p.API.KVSet(getTokenKey(info.UserID, info.AccountName), jsonInfo); err != nil {
 		return err
 	}
 
@@ -191,5 +224,7 @@ func (p *Plugin) storeGooglePlayUserInfo(info *GooglePlayUserInfo) error {
 // GooglePlayUserInfo stores important user information to save on the KVStore
 type GooglePlayUserInfo struct {
 	UserID string
-	Token  *oauth2.Token
+	// AccountName is the name of the connection. Empty on the connections made before they were named.
+	AccountName string
+	Token       *oauth2.Token
 }
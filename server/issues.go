@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+// defaultIssueTemplate creates the issue with the fields of the GitHub API, also accepted by GitLab as title and description
+const defaultIssueTemplate = `{"title": {{json .Title}}, "body": {{json .Body}}, "description": {{json .Body}}}`
+
+// maxIssueTitleLength is the amount of characters of the review text used on the issue title
+const maxIssueTitleLength = 60
+
+// issueURLFields are the fields of the tracker response holding the link to the issue, in order of preference.
+// GitHub answers html_url, GitLab web_url and Jira self.
+var issueURLFields = []string{"html_url", "web_url", "url", "self"}
+
+var issueTrackerClient = &http.Client{Timeout: 30 * time.Second}
+
+const (
+	// issueKeyPrefix is followed by the hash of the user and the review on the KV key storing its issue
+	issueKeyPrefix = "issue_"
+	// issuePendingTime frees the claim of a review if the node creating its issue stops before storing the link.
+	// It is longer than the timeout of the issue tracker requests.
+	issuePendingTime = time.Minute
+)
+
+// reviewIssue is stored on the KV store, shared by all the nodes, while the issue of a review is created and after.
+// The expiry of the claim is kept on the value, as an expired key still blocks the atomic insert until the server
+// cleans it up.
+type reviewIssue struct {
+	// URL is the link to the issue, empty while it is being created
+	URL          string
+	PendingUntil int64
+}
+
+// issueData is the data available on the issue payload template
+type issueData struct {
+	Title      string
+	Body       string
+	App        string
+	ReviewID   string
+	Author     string
+	Rating     int64
+	Text       string
+	Device     string
+	OSVersion  string
+	AppVersion string
+	Language   string
+	Link       string
+}
+
+func parseIssueTemplate(text string) (*template.Template, error) {
+	if text == "" {
+		text = defaultIssueTemplate
+	}
+	return template.New("issue").Funcs(template.FuncMap{
+		"json": func(value interface{}) (string, error) {
+			b, err := json.Marshal(value)
+			return string(b), err
+		},
+	}).Parse(text)
+}
+
+// getReviewLink returns the public page of the review on its store
+func getReviewLink(packageInfo PackageInfo, review *Review) string {
+	if packageInfo.Store == storeAppStore {
+		return fmt.Sprintf("https://apps.apple.com/app/id%s?see-all=reviews", packageInfo.Name)
+	}
+	return fmt.Sprintf("https://play.google.com/store/apps/details?id=%s&reviewId=%s", packageInfo.Name, review.ID)
+}
+
+func getAppVersion(review *Review) string {
+	if review.AppVersionName != "" {
+		return fmt.Sprintf("%s (%d)", review.AppVersionName, review.AppVersionCode)
+	}
+	if review.AppVersionCode != 0 {
+		return fmt.Sprintf("%d", review.AppVersionCode)
+	}
+	return "Unknown"
+}
+
+func newIssueData(packageInfo PackageInfo, review *Review) *issueData {
+	data := &issueData{
+		App:        packageInfo.Name,
+		ReviewID:   review.ID,
+		Author:     review.Author,
+		Rating:     review.Rating,
+		Text:       review.Text,
+		Device:     getDeviceKey(review),
+		OSVersion:  getOSVersionKey(review),
+		AppVersion: getAppVersion(review),
+		Language:   review.Language,
+		Link:       getReviewLink(packageInfo, review),
+	}
+
+	title := strings.Join(strings.Fields(review.Text), " ")
+	if runes := []rune(title); len(runes) > maxIssueTitleLength {
+		title = string(runes[:maxIssueTitleLength]) + "..."
+	}
+	data.Title = fmt.Sprintf("[%s] %d-star review: %s", packageInfo.Name, review.Rating, title)
+
+	data.Body = fmt.Sprintf("Review **%s** by **%s** on %s:\n\n> %s\n\n", review.ID, review.Author, getStoreTitle(packageInfo.Store), strings.Join(strings.Split(review.Text, "\n"), "\n> "))
+	data.Body += fmt.Sprintf("* Rating: %d/5\n", review.Rating)
+	data.Body += fmt.Sprintf("* App version: %s\n", data.AppVersion)
+	data.Body += fmt.Sprintf("* Device: %s\n", data.Device)
+	data.Body += fmt.Sprintf("* OS version: %s\n", data.OSVersion)
+	if review.Language != "" {
+		data.Body += fmt.Sprintf("* Language: %s\n", review.Language)
+	}
+	data.Body += fmt.Sprintf("* Link: %s\n", data.Link)
+	return data
+}
+
+// getIssueURL finds the link to the created issue on the tracker response
+func getIssueURL(body []byte) (string, error) {
+	var response map[string]interface{}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return "", fmt.Errorf("the issue tracker did not answer JSON: %v", err)
+	}
+	for _, field := range issueURLFields {
+		if url, ok := response[field].(string); ok && url != "" {
+			return url, nil
+		}
+	}
+	return "", errors.New("the issue tracker did not answer the link to the issue")
+}
+
+// createIssue sends the review to the configured issue tracker, returning the link to the issue
+func (p *Plugin) createIssue(packageInfo PackageInfo, review *Review) (string, error) {
+	config := p.getConfiguration()
+	if config.IssueTrackerURL == "" {
+		return "", errors.New("the issue tracker is not configured")
+	}
+
+	payloadTemplate, err := parseIssueTemplate(config.IssueTrackerTemplate)
+	if err != nil {
+		return "", err
+	}
+	var payload bytes.Buffer
+	if err = payloadTemplate.Execute(&payload, newIssueData(packageInfo, review)); err != nil {
+		return "", err
+	}
+
+	request, err := http.NewRequest(http.MethodPost, config.IssueTrackerURL, &payload)
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Accept", "application/json")
+	if config.IssueTrackerAuthorization != "" {
+		request.Header.Set("Authorization", config.IssueTrackerAuthorization)
+	}
+
+	response, err := issueTrackerClient.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+
+	b, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return "", err
+	}
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return "", fmt.Errorf("the issue tracker answered %d: %s", response.StatusCode, strings.TrimSpace(string(b)))
+	}
+	return getIssueURL(b)
+}
+
+// findCachedReview looks for the review on all the cached apps of the user, returning a copy of it
+func (p *Plugin) findCachedReview(userID string, reviewID string) (string, *Review, bool) {
+	p.control.reviewsMutex.RLock()
+	defer p.control.reviewsMutex.RUnlock()
+
+	for packageName, reviews := range p.localReviews[userID] {
+		for _, review := range reviews {
+			if review.ID == reviewID {
+				result := *review
+				return packageName, &result, true
+			}
+		}
+	}
+	return "", nil, false
+}
+
+// storeIssueURL links the cached review to its issue, so the link is shown on the next posts.
+// The review is replaced by a copy, as the pending alerts may be reading it without holding reviewsMutex.
+func (p *Plugin) storeIssueURL(userID string, packageName string, reviewID string, issueURL string) {
+	p.control.reviewsMutex.Lock()
+	defer p.control.reviewsMutex.Unlock()
+
+	reviews := p.localReviews[userID][packageName]
+	for i, review := range reviews {
+		if review.ID != reviewID {
+			continue
+		}
+		updated := *review
+		updated.IssueURL = issueURL
+		reviews[i] = &updated
+		p.SaveReviews()
+		return
+	}
+}
+
+func getIssueKey(userID string, reviewID string) string {
+	return getHashedKey(issueKeyPrefix, userID+"/"+reviewID)
+}
+
+// claimReviewIssue marks the issue of the review as being created, returning the claim to replace with the link.
+// If it was already created or claimed, it returns an error with the link to the issue, if any.
+func (p *Plugin) claimReviewIssue(userID string, packageName string, reviewID string) ([]byte, error) {
+	key := getIssueKey(userID, reviewID)
+	now := time.Now()
+	claim, _ := json.Marshal(reviewIssue{PendingUntil: now.Add(issuePendingTime).Unix()})
+
+	current, appErr := p.API.KVGet(key)
+	if appErr != nil {
+		return nil, appErr
+	}
+
+	var ok bool
+	if current == nil {
+		ok, appErr = p.API.KVSetWithOptions(key, claim, model.PluginKVSetOptions{Atomic: true, OldValue: nil})
+	} else {
+		var issue reviewIssue
+		if err := json.Unmarshal(current, &issue); err == nil {
+			if issue.URL != "" {
+				// The issue was created on another node, or before this node loaded the review
+				p.storeIssueURL(userID, packageName, reviewID, issue.URL)
+				return nil, fmt.Errorf("review %s already has an issue: %s", reviewID, issue.URL)
+			}
+			if issue.PendingUntil > now.Unix() {
+				return nil, fmt.Errorf("the issue of review %s is being created", reviewID)
+			}
+		}
+		ok, appErr = p.API.KVCompareAndSet(key, current, claim)
+	}
+	if appErr != nil {
+		return nil, appErr
+	}
+	if !ok {
+		return nil, fmt.Errorf("the issue of review %s is being created", reviewID)
+	}
+	return claim, nil
+}
+
+// createReviewIssue creates the issue of a cached review, unless it already has one
+func (p *Plugin) createReviewIssue(userID string, reviewID string) (string, error) {
+	packageName, review, ok := p.findCachedReview(userID, reviewID)
+	if !ok {
+		return "", fmt.Errorf("review %s is not cached. Try again after the next sync", reviewID)
+	}
+	if review.IssueURL != "" {
+		return "", fmt.Errorf("review %s already has an issue: %s", reviewID, review.IssueURL)
+	}
+	claim, err := p.claimReviewIssue(userID, packageName, reviewID)
+	if err != nil {
+		return "", err
+	}
+
+	key := getIssueKey(userID, reviewID)
+	packageInfo, _ := p.state.getPackage(userID, packageName)
+	issueURL, err := p.createIssue(packageInfo, review)
+	if err != nil {
+		p.logError("Error creating issue", "userID", userID, "package", packageName, "reviewID", reviewID, "err", err.Error())
+		if _, appErr := p.API.KVCompareAndDelete(key, claim); appErr != nil {
+			p.logError("Error releasing the issue of the review", "userID", userID, "reviewID", reviewID, "err", appErr.Error())
+		}
+		return "", err
+	}
+
+	issue, _ := json.Marshal(reviewIssue{URL: issueURL})
+	if ok, appErr := p.API.KVCompareAndSet(key, claim, issue); appErr != nil {
+		p.logError("Error saving the issue of the review", "userID", userID, "reviewID", reviewID, "err", appErr.Error())
+	} else if !ok {
+		p.logWarn("The claim of the review expired while creating its issue", "userID", userID, "reviewID", reviewID, "issueURL", issueURL)
+	}
+	p.storeIssueURL(userID, packageName, reviewID, issueURL)
+	p.logDebug("Issue created", "userID", userID, "package", packageName, "reviewID", reviewID, "issueURL", issueURL)
+	return issueURL, nil
+}
+
+// getCreateIssueAction returns the Create issue button of the review, or nil if the issue tracker is not configured
+func (p *Plugin) getCreateIssueAction(userID string, packageName string, review *Review) *model.PostAction {
+	if p.getConfiguration().IssueTrackerURL == "" || review.IssueURL != "" {
+		return nil
+	}
+	return p.getReviewAction("Create issue", "/reviews/issue", userID, packageName, review)
+}
+
+// createIssueFromAction answers the Create issue button with the link to the created issue
+func (p *Plugin) createIssueFromAction(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-ID")
+	if userID == "" {
+		http.Error(w, "Not authorized", http.StatusUnauthorized)
+		return
+	}
+
+	request := model.PostActionIntegrationRequestFromJson(r.Body)
+	if request == nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if _, appErr := p.API.GetChannelMember(request.ChannelId, userID); appErr != nil {
+		writePostActionResponse(w, "Only members of the channel can create issues from its reviews.")
+		return
+	}
+
+	ownerID, _ := request.Context["user_id"].(string)
+	reviewID, _ := request.Context["review_id"].(string)
+	issueURL, err := p.createReviewIssue(ownerID, reviewID)
+	if err != nil {
+		writePostActionResponse(w, "Cannot create the issue: "+err.Error()+".")
+		return
+	}
+	writePostActionResponse(w, fmt.Sprintf("Issue created for review **%s**: %s", reviewID, issueURL))
+}
+
+func (p *Plugin) createIssueCommand(args []string, userID string) (*model.CommandResponse, *model.AppError) {
+	var message string
+
+	if len(args) != 3 {
+		message += fmt.Sprintf(":x:Wrong use: `%s %s reviewId`", args[0], args[1])
+		return commandErrorResponse(message)
+	}
+
+	issueURL, err := p.createReviewIssue(userID, args[2])
+	if err != nil {
+		message += fmt.Sprintf(":x:Cannot create the issue: %s.", err.Error())
+		return commandErrorResponse(message)
+	}
+
+	message += fmt.Sprintf(":white_check_mark:Issue created for review **%s**: %s", args[2], issueURL)
+	return commandStatusResponse(message)
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetIssueURL(t *testing.T) {
+	for body, expected := range map[string]string{
+		`{"url": "https://api.github.com/repos/o/r/issues/1", "html_url": "https://github.com/o/r/issues/1"}`: "https://github.com/o/r/issues/1",
+		`{"iid": 1, "web_url": "https://gitlab.example.com/o/r/-/issues/1"}`:                                  "https://gitlab.example.com/o/r/-/issues/1",
+		`{"key": "APP-1", "self": "https://jira.example.com/rest/api/2/issue/10000"}`:                         "https://jira.example.com/rest/api/2/issue/10000",
+	} {
+		url, err := getIssueURL([]byte(body))
+		require.NoError(t, err, body)
+		assert.Equal(t, expected, url)
+	}
+
+	_, err := getIssueURL([]byte(`{"id": 1}`))
+	assert.Error(t, err)
+	_, err = getIssueURL([]byte(`<html>`))
+	assert.Error(t, err)
+}
+
+func TestCreateReviewIssue(t *testing.T) {
+	var payload map[string]string
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&payload))
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"html_url": "https://github.com/o/r/issues/7"}`))
+	}))
+	defer server.Close()
+
+	p := newTestPlugin()
+	newTestKVStore(p.API.(*plugintest.API))
+	p.configuration.IssueTrackerURL = server.URL
+	p.configuration.IssueTrackerAuthorization = "Bearer token"
+	p.state.addPackage(PackageInfo{Name: "com.example", UserID: "user"})
+	review := newTestReview("review", 1, "It crashes when I open the camera")
+	review.AppVersionName = "1.2"
+	review.AppVersionCode = 12
+	review.AndroidOSVersion = 29
+	p.localReviews["user"] = map[string][]*Review{"com.example": {review}}
+
+	issueURL, err := p.createReviewIssue("user", "review")
+	require.NoError(t, err)
+	assert.Equal(t, "https://github.com/o/r/issues/7", issueURL)
+	assert.Equal(t, "Bearer token", authorization)
+	assert.Equal(t, "[com.example] 1-star review: It crashes when I open the camera", payload["title"])
+	assert.True(t, strings.Contains(payload["body"], "* App version: 1.2 (12)\n"))
+	assert.True(t, strings.Contains(payload["body"], "* OS version: API 29\n"))
+	assert.True(t, strings.Contains(payload["body"], "https://play.google.com/store/apps/details?id=com.example&reviewId=review"))
+
+	cached := p.localReviews["user"]["com.example"][0]
+	assert.Equal(t, issueURL, cached.IssueURL)
+	card := p.newReviewCard("user", "com.example", "en", cached, false)
+	assert.Equal(t, &model.SlackAttachmentField{Title: "Issue", Value: issueURL, Short: true}, card.Fields[len(card.Fields)-1])
+	assert.Nil(t, p.getCreateIssueAction("user", "com.example", cached))
+
+	_, err = p.createReviewIssue("user", "review")
+	assert.Error(t, err)
+
+	_, _, updated, _ := mergeReviewLists(p.localReviews["user"]["com.example"], []*Review{newTestReview("review", 2, "Updated")}, "com.example")
+	require.Len(t, updated, 1)
+	assert.Equal(t, issueURL, updated[0].IssueURL)
+}
+
+func TestCreateReviewIssueOnce(t *testing.T) {
+	var created int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&created, 1)
+		<-release
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"html_url": "https://github.com/o/r/issues/7"}`))
+	}))
+	defer server.Close()
+
+	api := &plugintest.API{}
+	newTestKVStore(api)
+	nodes := []*Plugin{newTestClusterNode(api), newTestClusterNode(api)}
+	for _, p := range nodes {
+		p.configuration.IssueTrackerURL = server.URL
+		p.state.addPackage(PackageInfo{Name: "com.example", UserID: "user"})
+		p.localReviews["user"] = map[string][]*Review{"com.example": {newTestReview("review", 1, "It crashes")}}
+	}
+
+	var wg sync.WaitGroup
+	var failed int32
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(p *Plugin) {
+			defer wg.Done()
+			if _, err := p.createReviewIssue("user", "review"); err != nil {
+				atomic.AddInt32(&failed, 1)
+			}
+		}(nodes[i%2])
+	}
+	for atomic.LoadInt32(&created)+atomic.LoadInt32(&failed) < 4 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&created), "the review gets a single issue")
+	assert.Equal(t, int32(3), atomic.LoadInt32(&failed))
+
+	// The node that did not create the issue learns its link when asked again
+	for _, p := range nodes {
+		_, err := p.createReviewIssue("user", "review")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "https://github.com/o/r/issues/7")
+		assert.Equal(t, "https://github.com/o/r/issues/7", p.localReviews["user"]["com.example"][0].IssueURL)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&created))
+}
+
+func TestCreateIssueFromActionUsesRequestUser(t *testing.T) {
+	p := newTestPlugin()
+	api := p.API.(*plugintest.API)
+	api.On("GetChannelMember", "channel", "member").Return(&model.ChannelMember{}, nil)
+	api.On("GetChannelMember", "channel", "outsider").Return(nil, &model.AppError{Message: "not a member"})
+
+	request := &model.PostActionIntegrationRequest{UserId: "member", ChannelId: "channel", Context: map[string]interface{}{"user_id": "user", "review_id": "review"}}
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/reviews/issue", bytes.NewReader(request.ToJson()))
+	r.Header.Set("Mattermost-User-ID", "outsider")
+	p.createIssueFromAction(w, r)
+	response := model.PostActionIntegrationResponseFromJson(w.Body)
+	require.NotNil(t, response)
+	assert.Equal(t, "Only members of the channel can create issues from its reviews.", response.EphemeralText)
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodPost, "/reviews/issue", bytes.NewReader(request.ToJson()))
+	p.createIssueFromAction(w, r)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestCreateReviewIssueAfterExpiredClaim(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"html_url": "https://github.com/o/r/issues/7"}`))
+	}))
+	defer server.Close()
+
+	p := newTestPlugin()
+	kv := newTestKVStore(p.API.(*plugintest.API))
+	p.configuration.IssueTrackerURL = server.URL
+	p.state.addPackage(PackageInfo{Name: "com.example", UserID: "user"})
+	p.localReviews["user"] = map[string][]*Review{"com.example": {newTestReview("review", 1, "It crashes")}}
+
+	claim, err := json.Marshal(reviewIssue{PendingUntil: time.Now().Add(time.Minute).Unix()})
+	require.NoError(t, err)
+	kv.set(getIssueKey("user", "review"), claim)
+	_, err = p.createReviewIssue("user", "review")
+	assert.EqualError(t, err, "the issue of review review is being created")
+
+	// The node that claimed the review stopped, and the server did not remove the key
+	claim, err = json.Marshal(reviewIssue{PendingUntil: time.Now().Add(-time.Second).Unix()})
+	require.NoError(t, err)
+	kv.set(getIssueKey("user", "review"), claim)
+	issueURL, err := p.createReviewIssue("user", "review")
+	require.NoError(t, err)
+	assert.Equal(t, "https://github.com/o/r/issues/7", issueURL)
+
+	var issue reviewIssue
+	require.NoError(t, json.Unmarshal(kv.values[getIssueKey("user", "review")], &issue))
+	assert.Equal(t, reviewIssue{URL: issueURL}, issue)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"google.golang.org/api/googleapi"
+)
+
+const (
+	logLevelDebug = "debug"
+	logLevelInfo  = "info"
+	logLevelWarn  = "warn"
+	logLevelError = "error"
+)
+
+var logLevels = map[string]int{
+	logLevelDebug: 0,
+	logLevelInfo:  1,
+	logLevelWarn:  2,
+	logLevelError: 3,
+}
+
+// pluginLogger writes messages with key-value pairs on the Mattermost server log
+type pluginLogger interface {
+	logDebug(msg string, keyValuePairs ...interface{})
+	logInfo(msg string, keyValuePairs ...interface{})
+	logWarn(msg string, keyValuePairs ...interface{})
+	logError(msg string, keyValuePairs ...interface{})
+}
+
+func (p *Plugin) shouldLog(level string) bool {
+	return logLevels[level] >= logLevels[p.getConfiguration().LogLevel]
+}
+
+func (p *Plugin) logDebug(msg string, keyValuePairs ...interface{}) {
+	if p.shouldLog(logLevelDebug) {
+		p.API.LogDebug(msg, keyValuePairs...)
+	}
+}
+
+func (p *Plugin) logInfo(msg string, keyValuePairs ...interface{}) {
+	if p.shouldLog(logLevelInfo) {
+		p.API.LogInfo(msg, keyValuePairs...)
+	}
+}
+
+func (p *Plugin) logWarn(msg string, keyValuePairs ...interface{}) {
+	if p.shouldLog(logLevelWarn) {
+		p.API.LogWarn(msg, keyValuePairs...)
+	}
+}
+
+func (p *Plugin) logError(msg string, keyValuePairs ...interface{}) {
+	if p.shouldLog(logLevelError) {
+		p.API.LogError(msg, keyValuePairs...)
+	}
+}
+
+// getHTTPStatus returns the HTTP status code of the store API errors, or 0 for any other error
+func getHTTPStatus(err error) int {
+	switch apiErr := err.(type) {
+	case *googleapi.Error:
+		return apiErr.Code
+	case *storeAPIError:
+		return apiErr.Code
+	}
+	return 0
+}
@@ -53,11 +53,19 @@ const manifestStr = `
         "placeholder": "",
         "default": null
       },
+      {
+        "key": "PreviousEncryptionKeys",
+        "display_name": "Previous At Rest Encryption Keys",
+        "type": "text",
+        "help_text": "Comma separated list of previous encryption keys still able to decrypt stored access tokens. Tokens are encrypted again with the current key when read, or all at once with the /gpreviews admin reencrypt command.",
+        "placeholder": "",
+        "default": null
+      },
       {
         "key": "GetListTime",
         "display_name": "Refresh time (in seconds)",
         "type": "text",
-        "help_text": "How often you want to look for new reviews.",
+        "help_text": "How often you want to look for new reviews, unless a different interval is set for the app.",
         "placeholder": "",
         "default": 1800
       },
@@ -76,6 +84,96 @@ const manifestStr = `
         "help_text": "How many reviews as maximum you want to receive from plugin messages.",
         "placeholder": "",
         "default": 10
+      },
+      {
+        "key": "MaxConcurrentSyncs",
+        "display_name": "Concurrent syncs",
+        "type": "text",
+        "help_text": "How many apps can fetch their reviews at the same time. The pool of sync workers is created when the plugin is activated, so changes apply after restarting the plugin.",
+        "placeholder": "",
+        "default": 4
+      },
+      {
+        "key": "APIRequestsPerMinute",
+        "display_name": "Google Play API requests per minute",
+        "type": "text",
+        "help_text": "How many requests per minute can be sent to the Google Play API, shared by all the users.",
+        "placeholder": "",
+        "default": 60
+      },
+      {
+        "key": "LogLevel",
+        "display_name": "Log level",
+        "type": "dropdown",
+        "help_text": "Minimum level of the plugin messages written on the server log.",
+        "placeholder": "",
+        "default": "info",
+        "options": [
+          {
+            "display_name": "Debug",
+            "value": "debug"
+          },
+          {
+            "display_name": "Info",
+            "value": "info"
+          },
+          {
+            "display_name": "Warn",
+            "value": "warn"
+          },
+          {
+            "display_name": "Error",
+            "value": "error"
+          }
+        ]
+      },
+      {
+        "key": "MetricsToken",
+        "display_name": "Metrics token",
+        "type": "generated",
+        "help_text": "Bearer token to read the Prometheus metrics on /plugins/com.mattermost.google-play-reviews/metrics. System admins can always read them.",
+        "placeholder": "",
+        "default": null
+      },
+      {
+        "key": "TopicDictionaries",
+        "display_name": "Topic dictionaries",
+        "type": "text",
+        "help_text": "Topics the reviews are tagged with, besides the built-in performance, crashes, ui, pricing and login ones, written as topic=term1,term2;other=term3. Terms ending in * match any word starting with them. A built-in topic can be replaced with new terms, or removed leaving it without terms.",
+        "placeholder": "",
+        "default": null
+      },
+      {
+        "key": "WebhookSigningSecret",
+        "display_name": "Webhook signing secret",
+        "type": "generated",
+        "help_text": "Secret used to sign the alerts sent to webhooks. The X-Gpreviews-Signature header is sha256= followed by the hex HMAC-SHA256 of the X-Gpreviews-Timestamp header, a dot and the body. Empty sends them unsigned.",
+        "placeholder": "",
+        "default": null
+      },
+      {
+        "key": "IssueTrackerURL",
+        "display_name": "Issue tracker URL",
+        "type": "text",
+        "help_text": "Endpoint where the issues are created with a POST request, like https://api.github.com/repos/owner/repo/issues, https://gitlab.example.com/api/v4/projects/42/issues or https://jira.example.com/rest/api/2/issue. Empty disables the Create issue button.",
+        "placeholder": "",
+        "default": null
+      },
+      {
+        "key": "IssueTrackerAuthorization",
+        "display_name": "Issue tracker authorization",
+        "type": "text",
+        "help_text": "Value of the Authorization header sent to the issue tracker, like Bearer followed by a token, or Basic followed by the encoded user and API token for Jira.",
+        "placeholder": "",
+        "default": null
+      },
+      {
+        "key": "IssueTrackerTemplate",
+        "display_name": "Issue tracker payload template",
+        "type": "text",
+        "help_text": "Go template of the JSON payload. The fields are .Title, .Body, .App, .ReviewID, .Author, .Rating, .Text, .Device, .OSVersion, .AppVersion, .Language and .Link, and json quotes a value. Empty sends the title and the body as GitHub and GitLab expect them. Jira needs a template like {\"fields\": {\"project\": {\"key\": \"APP\"}, \"issuetype\": {\"name\": \"Bug\"}, \"summary\": {{json .Title}}, \"description\": {{json .Body}}}}.",
+        "placeholder": "",
+        "default": null
       }
     ]
   }
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/googleapi"
+)
+
+const (
+	metricSyncDuration         = "gpreviews_sync_duration_seconds"
+	metricSyncTotal            = "gpreviews_syncs_total"
+	metricSyncErrors           = "gpreviews_sync_errors_total"
+	metricReviewsFetched       = "gpreviews_reviews_fetched_total"
+	metricLastReviewsFetched   = "gpreviews_last_sync_reviews_fetched"
+	metricNewReviews           = "gpreviews_new_reviews_total"
+	metricLastNewReviews       = "gpreviews_last_sync_new_reviews"
+	metricQuotaErrors          = "gpreviews_google_api_quota_errors_total"
+	metricAlertDeliveries      = "gpreviews_alert_deliveries_total"
+	metricAlertFailures        = "gpreviews_alert_delivery_failures_total"
+	metricCachedReviews        = "gpreviews_cached_reviews"
+	metricTokenRefreshFailures = "gpreviews_token_refresh_failures_total"
+)
+
+type metricDefinition struct {
+	name       string
+	metricType string
+	help       string
+}
+
+// metricDefinitions sets the metrics exposed and the order they are written on
+var metricDefinitions = []metricDefinition{
+	{metricSyncDuration, "gauge", "Duration of the last reviews sync of each package."},
+	{metricSyncTotal, "counter", "Reviews syncs done for each package."},
+	{metricSyncErrors, "counter", "Reviews syncs failed for each package."},
+	{metricReviewsFetched, "counter", "Reviews fetched from Google Play for each package."},
+	{metricLastReviewsFetched, "gauge", "Reviews fetched from Google Play on the last sync of each package."},
+	{metricNewReviews, "counter", "New reviews found for each package."},
+	{metricLastNewReviews, "gauge", "New reviews found on the last sync of each package."},
+	{metricQuotaErrors, "counter", "Google Play API calls rejected for exceeding the quota, for each package."},
+	{metricAlertDeliveries, "counter", "Alerts delivered for each delivery type."},
+	{metricAlertFailures, "counter", "Alerts failed to deliver for each delivery type."},
+	{metricCachedReviews, "gauge", "Reviews on the cache for each package."},
+	{metricTokenRefreshFailures, "counter", "Google Play OAuth token refreshes failed."},
+}
+
+type metricKey struct {
+	name       string
+	labelName  string
+	labelValue string
+}
+
+// pluginMetrics stores the metric samples. It is safe to use concurrently.
+type pluginMetrics struct {
+	lock    sync.Mutex
+	samples map[metricKey]float64
+}
+
+func newPluginMetrics() *pluginMetrics {
+	return &pluginMetrics{
+		samples: make(map[metricKey]float64),
+	}
+}
+
+func (m *pluginMetrics) add(name string, labelName string, labelValue string, value float64) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.samples[metricKey{name, labelName, labelValue}] += value
+}
+
+func (m *pluginMetrics) set(name string, labelName string, labelValue string, value float64) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.samples[metricKey{name, labelName, labelValue}] = value
+}
+
+// write formats the samples on the Prometheus text exposition format
+func (m *pluginMetrics) write(extra map[metricKey]float64) string {
+	m.lock.Lock()
+	samples := make(map[metricKey]float64)
+	for k, v := range m.samples {
+		samples[k] = v
+	}
+	m.lock.Unlock()
+	for k, v := range extra {
+		samples[k] = v
+	}
+
+	byName := make(map[string][]metricKey)
+	for k := range samples {
+		byName[k.name] = append(byName[k.name], k)
+	}
+
+	var text strings.Builder
+	for _, definition := range metricDefinitions {
+		fmt.Fprintf(&text, "# HELP %s %s\n# TYPE %s %s\n", definition.name, definition.help, definition.name, definition.metricType)
+		keys := byName[definition.name]
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i].labelValue < keys[j].labelValue
+		})
+		for _, k := range keys {
+			if k.labelName == "" {
+				fmt.Fprintf(&text, "%s %v\n", k.name, samples[k])
+			} else {
+				fmt.Fprintf(&text, "%s{%s=\"%s\"} %v\n", k.name, k.labelName, escapeLabelValue(k.labelValue), samples[k])
+			}
+		}
+	}
+	return text.String()
+}
+
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// recordSyncError classifies a failed sync, detecting quota and token refresh errors
+func (p *Plugin) recordSyncError(packageName string, err error) {
+	p.metrics.add(metricSyncErrors, "package", packageName, 1)
+	if isQuotaError(err) {
+		p.metrics.add(metricQuotaErrors, "package", packageName, 1)
+	}
+	if isTokenRefreshError(err) {
+		p.metrics.add(metricTokenRefreshFailures, "", "", 1)
+	}
+}
+
+func (p *Plugin) recordAlertDelivery(deliveryType string, err error) {
+	if err != nil {
+		p.metrics.add(metricAlertFailures, "type", deliveryType, 1)
+		return
+	}
+	p.metrics.add(metricAlertDeliveries, "type", deliveryType, 1)
+}
+
+func isQuotaError(err error) bool {
+	apiErr, ok := err.(*googleapi.Error)
+	if !ok {
+		return false
+	}
+	if apiErr.Code == http.StatusTooManyRequests {
+		return true
+	}
+	for _, item := range apiErr.Errors {
+		if strings.Contains(item.Reason, "RateLimitExceeded") || strings.Contains(item.Reason, "rateLimitExceeded") || strings.Contains(item.Reason, "quotaExceeded") {
+			return true
+		}
+	}
+	return false
+}
+
+func isTokenRefreshError(err error) bool {
+	if urlErr, ok := err.(*url.Error); ok {
+		err = urlErr.Err
+	}
+	_, ok := err.(*oauth2.RetrieveError)
+	return ok
+}
+
+// canReadMetrics allows system admins and requests with the configured metrics token
+func (p *Plugin) canReadMetrics(r *http.Request) bool {
+	if token := p.getConfiguration().MetricsToken; token != "" {
+		requestToken := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(requestToken), []byte(token)) == 1 {
+			return true
+		}
+	}
+
+	userID := r.Header.Get("Mattermost-User-ID")
+	return userID != "" && p.API.HasPermissionTo(userID, model.PERMISSION_MANAGE_SYSTEM)
+}
+
+func (p *Plugin) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	if !p.canReadMetrics(r) {
+		http.Error(w, "Not authorized", http.StatusUnauthorized)
+		return
+	}
+
+	cacheSizes := make(map[metricKey]float64)
+	p.control.reviewsMutex.RLock()
+	for _, packages := range p.localReviews {
+		for packageName, reviews := range packages {
+			cacheSizes[metricKey{metricCachedReviews, "package", packageName}] += float64(len(reviews))
+		}
+	}
+	p.control.reviewsMutex.RUnlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write([]byte(p.metrics.write(cacheSizes)))
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"golang.org/x/oauth2"
+)
+
+const (
+	oauthStateKeyPrefix = "oauth_state_"
+	// oauthStateTime is how long the user has to complete the connection to Google Play
+	oauthStateTime = 10 * time.Minute
+)
+
+// oauthState ties a random OAuth state token to the user who started the connection
+type oauthState struct {
+	UserID      string
+	ChannelID   string
+	AccountName string
+	// Verifier is the PKCE code verifier, only known by the server
+	Verifier  string
+	ExpiresAt int64
+}
+
+func newRandomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func getCodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// getOAuthStateKey hashes the token, as the prefix and the token are longer than the KV keys allow
+func getOAuthStateKey(token string) string {
+	return getHashedKey(oauthStateKeyPrefix, token)
+}
+
+// createOAuthState stores a new state, which expires if the connection is abandoned
+func (p *Plugin) createOAuthState(userID string, channelID string, accountName string) (string, *oauthState, error) {
+	token, err := newRandomToken()
+	if err != nil {
+		return "", nil, err
+	}
+	verifier, err := newRandomToken()
+	if err != nil {
+		return "", nil, err
+	}
+
+	state := &oauthState{
+		UserID:      userID,
+		ChannelID:   channelID,
+		AccountName: accountName,
+		Verifier:    verifier,
+		ExpiresAt:   time.Now().Add(oauthStateTime).Unix(),
+	}
+	b, err := json.Marshal(state)
+	if err != nil {
+		return "", nil, err
+	}
+	if appErr := p.API.KVSetWithExpiry(getOAuthStateKey(token), b, int64(oauthStateTime.Seconds())); appErr != nil {
+		return "", nil, appErr
+	}
+	return token, state, nil
+}
+
+// consumeOAuthState gets the state of the token and deletes it, so it can only be used once
+func (p *Plugin) consumeOAuthState(token string) (*oauthState, error) {
+	if token == "" {
+		return nil, errors.New("missing state")
+	}
+
+	key := getOAuthStateKey(token)
+	b, appErr := p.API.KVGet(key)
+	if appErr != nil {
+		return nil, appErr
+	}
+	if b == nil {
+		return nil, errors.New("unknown or expired state")
+	}
+
+	if deleted, appErr := p.API.KVCompareAndDelete(key, b); appErr != nil {
+		return nil, appErr
+	} else if !deleted {
+		return nil, errors.New("state already used")
+	}
+
+	var state oauthState
+	if err := json.Unmarshal(b, &state); err != nil {
+		return nil, err
+	}
+	if time.Now().Unix() > state.ExpiresAt {
+		return nil, errors.New("expired state")
+	}
+	return &state, nil
+}
+
+func getPKCEAuthOptions(verifier string) []oauth2.AuthCodeOption {
+	return []oauth2.AuthCodeOption{
+		oauth2.AccessTypeOffline,
+		oauth2.SetAuthURLParam("code_challenge", getCodeChallenge(verifier)),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+	}
+}
+
+// notifyConnectResult tells the user on Mattermost how the connection to Google Play ended
+func (p *Plugin) notifyConnectResult(state *oauthState, message string) {
+	channelID := state.ChannelID
+	if channelID == "" {
+		channel, appErr := p.API.GetDirectChannel(state.UserID, p.botUserID)
+		if appErr != nil {
+			p.logError("Error getting the direct channel to notify the connection", "userID", state.UserID, "err", appErr.Error())
+			return
+		}
+		channelID = channel.Id
+	}
+
+	p.API.SendEphemeralPost(state.UserID, &model.Post{
+		UserId:    p.botUserID,
+		ChannelId: channelID,
+		Message:   message,
+	})
+}
+
+func writeConnectResultPage(w http.ResponseWriter, status int, message string) {
+	page := fmt.Sprintf(`
+		<!DOCTYPE html>
+		<html>
+			<body>
+				<p>%s</p>
+				<p>You can close this window and go back to Mattermost.</p>
+			</body>
+		</html>
+		`, html.EscapeString(message))
+
+	w.Header().Set("Content-Type", "text/html")
+	w.WriteHeader(status)
+	w.Write([]byte(page))
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// testKVStore backs the KV store methods of the mocked API with a map. As the server does, it rejects writing
+// keys longer than model.KEY_VALUE_KEY_MAX_RUNES.
+type testKVStore struct {
+	lock   sync.Mutex
+	values map[string][]byte
+}
+
+func newTestKVStore(api *plugintest.API) *testKVStore {
+	kv := &testKVStore{values: make(map[string][]byte)}
+	api.On("KVGet", mock.Anything).Return(func(key string) []byte {
+		kv.lock.Lock()
+		defer kv.lock.Unlock()
+		return kv.values[key]
+	}, nil)
+	api.On("KVSet", mock.Anything, mock.Anything).Return(func(key string, value []byte) *model.AppError {
+		if appErr := checkTestKey(key); appErr != nil {
+			return appErr
+		}
+		kv.set(key, value)
+		return nil
+	})
+	api.On("KVSetWithExpiry", mock.Anything, mock.Anything, mock.Anything).Return(func(key string, value []byte, expireInSeconds int64) *model.AppError {
+		if appErr := checkTestKey(key); appErr != nil {
+			return appErr
+		}
+		kv.set(key, value)
+		return nil
+	})
+	api.On("KVSetWithOptions", mock.Anything, mock.Anything, mock.Anything).Return(func(key string, value []byte, options model.PluginKVSetOptions) bool {
+		if checkTestKey(key) != nil {
+			return false
+		}
+		kv.lock.Lock()
+		defer kv.lock.Unlock()
+		if options.Atomic && !bytes.Equal(kv.values[key], options.OldValue) {
+			return false
+		}
+		kv.setLocked(key, value)
+		return true
+	}, func(key string, value []byte, options model.PluginKVSetOptions) *model.AppError {
+		return checkTestKey(key)
+	})
+	api.On("KVCompareAndSet", mock.Anything, mock.Anything, mock.Anything).Return(func(key string, oldValue []byte, value []byte) bool {
+		if checkTestKey(key) != nil {
+			return false
+		}
+		kv.lock.Lock()
+		defer kv.lock.Unlock()
+		if !bytes.Equal(kv.values[key], oldValue) {
+			return false
+		}
+		kv.setLocked(key, value)
+		return true
+	}, func(key string, oldValue []byte, value []byte) *model.AppError {
+		return checkTestKey(key)
+	})
+	api.On("KVCompareAndDelete", mock.Anything, mock.Anything).Return(func(key string, oldValue []byte) bool {
+		kv.lock.Lock()
+		defer kv.lock.Unlock()
+		if _, ok := kv.values[key]; !ok || !bytes.Equal(kv.values[key], oldValue) {
+			return false
+		}
+		delete(kv.values, key)
+		return true
+	}, nil)
+	api.On("KVDelete", mock.Anything).Return(func(key string) *model.AppError {
+		kv.set(key, nil)
+		return nil
+	})
+	api.On("KVList", mock.Anything, mock.Anything).Return(func(page int, perPage int) []string {
+		kv.lock.Lock()
+		defer kv.lock.Unlock()
+		keys := []string{}
+		for key := range kv.values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		start := min(page*perPage, len(keys))
+		return keys[start:min(start+perPage, len(keys))]
+	}, nil)
+	return kv
+}
+
+// checkTestKey validates the key as model.PluginKeyValue.IsValid does
+func checkTestKey(key string) *model.AppError {
+	if key == "" || utf8.RuneCountInString(key) > model.KEY_VALUE_KEY_MAX_RUNES {
+		return model.NewAppError("PluginKeyValue.IsValid", "model.plugin_key_value.is_valid.key.app_error", nil, "key="+key, http.StatusBadRequest)
+	}
+	return nil
+}
+
+func (kv *testKVStore) set(key string, value []byte) {
+	kv.lock.Lock()
+	defer kv.lock.Unlock()
+	kv.setLocked(key, value)
+}
+
+func (kv *testKVStore) setLocked(key string, value []byte) {
+	if value == nil {
+		delete(kv.values, key)
+		return
+	}
+	kv.values[key] = value
+}
+
+func TestOAuthState(t *testing.T) {
+	p := newTestPlugin()
+	kv := newTestKVStore(p.API.(*plugintest.API))
+
+	token, created, err := p.createOAuthState("user", "channel", "work")
+	require.NoError(t, err)
+	assert.Len(t, token, 43)
+
+	state, err := p.consumeOAuthState(token)
+	require.NoError(t, err)
+	assert.Equal(t, created, state)
+	assert.Equal(t, "work", state.AccountName)
+
+	_, err = p.consumeOAuthState(token)
+	assert.Error(t, err, "states can only be used once")
+	_, err = p.consumeOAuthState("")
+	assert.Error(t, err)
+
+	token, created, err = p.createOAuthState("user", "channel", "work")
+	require.NoError(t, err)
+	created.ExpiresAt = time.Now().Add(-time.Second).Unix()
+	b, err := json.Marshal(created)
+	require.NoError(t, err)
+	kv.set(getOAuthStateKey(token), b)
+	_, err = p.consumeOAuthState(token)
+	assert.Error(t, err, "expired states are rejected even if the KV store did not remove them yet")
+}
+
+func TestCompleteConnectWithStateOfAnotherUser(t *testing.T) {
+	p := newTestPlugin()
+	api := p.API.(*plugintest.API)
+	newTestKVStore(api)
+	config := &model.Config{}
+	config.SetDefaults()
+	api.On("GetConfig").Return(config)
+
+	token, _, err := p.createOAuthState("user", "channel", "work")
+	require.NoError(t, err)
+
+	r := httptest.NewRequest(http.MethodGet, "/oauth/complete?code=code&state="+url.QueryEscape(token), nil)
+	r.Header.Set("Mattermost-User-ID", "attacker")
+	w := httptest.NewRecorder()
+	p.completeConnectUserToGooglePlay(w, r)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	_, err = p.consumeOAuthState(token)
+	assert.Error(t, err, "the state is consumed by the failed attempt")
+}
+
+func TestCodeChallenge(t *testing.T) {
+	// Example of RFC 7636, appendix B
+	assert.Equal(t, "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM", getCodeChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"))
+
+	p := newTestPlugin()
+	config := &model.Config{}
+	config.SetDefaults()
+	p.API.(*plugintest.API).On("GetConfig").Return(config)
+	authURL, err := url.Parse(p.getOAuthConfig("user").AuthCodeURL("state", getPKCEAuthOptions("verifier")...))
+	require.NoError(t, err)
+	assert.Equal(t, getCodeChallenge("verifier"), authURL.Query().Get("code_challenge"))
+	assert.Equal(t, "S256", authURL.Query().Get("code_challenge_method"))
+	assert.Equal(t, "offline", authURL.Query().Get("access_type"))
+}
@@ -1,39 +1,62 @@
 package main
 
-import (
-	"google.golang.org/api/androidpublisher/v3"
-)
+import "errors"
+
+// maxSharedStateRetries bounds the retries when other nodes keep changing the shared state
+const maxSharedStateRetries = 5
+
+// errStateChanged is returned when saving a part of the shared state another node changed since this node read it
+var errStateChanged = errors.New("another node changed the state")
 
 type persistencyInt interface {
-	Init()
-	SavePackages([]PackageInfo)
+	// Init prepares the persistency, logging its errors with the given logger
+	Init(log pluginLogger)
+	SavePackages([]PackageInfo) error
 	LoadPackages(*[]PackageInfo) bool
-	SaveAliases(map[string]map[string]string)
+	SaveAliases(map[string]map[string]string) error
 	LoadAliases(*map[string]map[string]string) bool
-	SaveReviews(map[string]map[string][]*androidpublisher.Review)
-	LoadReviews(*map[string]map[string][]*androidpublisher.Review) bool
-	SaveAlerts(AlertsContainer)
+	SaveReviews(map[string]map[string][]*Review)
+	LoadReviews(*map[string]map[string][]*Review) bool
+	SaveAlerts(AlertsContainer) error
 	LoadAlerts(*AlertsContainer) bool
+	SaveSLAs(map[string]map[string]*SLARule) error
+	LoadSLAs(*map[string]map[string]*SLARule) bool
+	SaveTemplates(map[string]map[string]string) error
+	LoadTemplates(*map[string]map[string]string) bool
+	SaveApprovals(ApprovalsContainer) error
+	LoadApprovals(*ApprovalsContainer) bool
+	SaveVersions(map[string]map[string]map[int64]string)
+	LoadVersions(*map[string]map[string]map[int64]string) bool
+	SaveLanguageRoutes(map[string]map[string]*LanguageRoutes) error
+	LoadLanguageRoutes(*map[string]map[string]*LanguageRoutes) bool
+	SaveDeadLetters([]*DeadLetter) error
+	LoadDeadLetters(*[]*DeadLetter) bool
+	SaveSyncStatus(map[string]*packageSyncStatus)
+	LoadSyncStatus(*map[string]*packageSyncStatus) bool
 }
 
 // SavePackages stores the package list on the persistant space
-func (p *Plugin) SavePackages() {
-	p.persistency.SavePackages(p.packageList)
+func (p *Plugin) SavePackages() error {
+	return p.persistency.SavePackages(p.state.getPackages())
 }
 
 // LoadPackages loads the package list from the persistant space
 func (p *Plugin) LoadPackages() {
-	p.persistency.LoadPackages(&p.packageList)
+	packageList := p.state.getPackages()
+	p.persistency.LoadPackages(&packageList)
+	p.state.setPackages(packageList)
 }
 
 // SaveAliases stores the package aliases on the persistant space
-func (p *Plugin) SaveAliases() {
-	p.persistency.SaveAliases(p.aliases)
+func (p *Plugin) SaveAliases() error {
+	return p.persistency.SaveAliases(p.state.getAllAliases())
 }
 
 // LoadAliases loads the package aliases from the persistant space
 func (p *Plugin) LoadAliases() {
-	p.persistency.LoadAliases(&p.aliases)
+	aliases := p.state.getAllAliases()
+	p.persistency.LoadAliases(&aliases)
+	p.state.setAliases(aliases)
 }
 
 // SaveReviews stores the reviews on the persistant space
@@ -43,31 +66,176 @@ func (p *Plugin) SaveReviews() {
 
 // LoadReviews loads the reviews from the persistant space
 func (p *Plugin) LoadReviews() {
+	p.control.reviewsMutex.Lock()
+	defer p.control.reviewsMutex.Unlock()
 	p.persistency.LoadReviews(&p.localReviews)
 }
 
 // SaveAlerts stores the alerts on the persistant space
-func (p *Plugin) SaveAlerts() {
-	p.persistency.SaveAlerts(p.alerts)
+func (p *Plugin) SaveAlerts() error {
+	return p.persistency.SaveAlerts(p.state.getAlerts())
 }
 
-// LoadAlerts loads the alerts from the persistant space
+// LoadAlerts loads the alerts from the persistant space. The alerts that did not change keep their pending reviews.
 func (p *Plugin) LoadAlerts() {
-	p.persistency.LoadAlerts(&p.alerts)
+	alerts := p.state.getAlerts()
+	p.persistency.LoadAlerts(&alerts)
+	p.state.reloadAlerts(alerts)
+}
+
+// SaveSLAs stores the SLA rules on the persistant space
+func (p *Plugin) SaveSLAs() error {
+	return p.persistency.SaveSLAs(p.state.getAllSLAs())
+}
+
+// LoadSLAs loads the SLA rules from the persistant space
+func (p *Plugin) LoadSLAs() {
+	rules := p.state.getAllSLAs()
+	p.persistency.LoadSLAs(&rules)
+	p.state.setAllSLAs(rules)
+}
+
+// SaveTemplates stores the reply templates on the persistant space
+func (p *Plugin) SaveTemplates() error {
+	return p.persistency.SaveTemplates(p.state.getAllTemplates())
+}
+
+// LoadTemplates loads the reply templates from the persistant space
+func (p *Plugin) LoadTemplates() {
+	templates := p.state.getAllTemplates()
+	p.persistency.LoadTemplates(&templates)
+	p.state.setAllTemplates(templates)
+}
+
+// SaveApprovals stores the reply approvals on the persistant space
+func (p *Plugin) SaveApprovals() error {
+	return p.persistency.SaveApprovals(p.state.getApprovals())
+}
+
+// LoadApprovals loads the reply approvals from the persistant space
+func (p *Plugin) LoadApprovals() {
+	approvals := p.state.getApprovals()
+	p.persistency.LoadApprovals(&approvals)
+	p.state.setApprovals(approvals)
+}
+
+// SaveVersions stores the known app versions on the persistant space
+func (p *Plugin) SaveVersions() {
+	p.persistency.SaveVersions(p.state.getAllVersions())
+}
+
+// LoadVersions loads the known app versions from the persistant space
+func (p *Plugin) LoadVersions() {
+	versions := p.state.getAllVersions()
+	p.persistency.LoadVersions(&versions)
+	p.state.setAllVersions(versions)
+}
+
+// SaveLanguageRoutes stores the language routes on the persistant space
+func (p *Plugin) SaveLanguageRoutes() error {
+	return p.persistency.SaveLanguageRoutes(p.state.getAllLanguageRoutes())
 }
 
-// SaveAll stores all information (packages, aliases, alerts and reviews) on the persistant space
+// LoadLanguageRoutes loads the language routes from the persistant space
+func (p *Plugin) LoadLanguageRoutes() {
+	routes := p.state.getAllLanguageRoutes()
+	p.persistency.LoadLanguageRoutes(&routes)
+	p.state.setAllLanguageRoutes(routes)
+}
+
+// SaveDeadLetters stores the undelivered webhook payloads on the persistant space
+func (p *Plugin) SaveDeadLetters() error {
+	return p.persistency.SaveDeadLetters(p.getDeadLetters())
+}
+
+// LoadDeadLetters loads the undelivered webhook payloads from the persistant space
+func (p *Plugin) LoadDeadLetters() {
+	p.control.deadLettersMutex.Lock()
+	defer p.control.deadLettersMutex.Unlock()
+	p.persistency.LoadDeadLetters(&p.deadLetters)
+}
+
+// SaveSyncStatus stores the sync status of the apps on the persistant space
+func (p *Plugin) SaveSyncStatus() {
+	p.control.syncStatusMutex.RLock()
+	defer p.control.syncStatusMutex.RUnlock()
+	p.persistency.SaveSyncStatus(p.syncStatus)
+}
+
+// LoadSyncStatus loads the sync status of the apps from the persistant space
+func (p *Plugin) LoadSyncStatus() {
+	p.control.syncStatusMutex.Lock()
+	defer p.control.syncStatusMutex.Unlock()
+	p.persistency.LoadSyncStatus(&p.syncStatus)
+}
+
+// SaveAll stores all the plugin information on the persistant space
 func (p *Plugin) SaveAll() {
 	p.SavePackages()
 	p.SaveAlerts()
+	p.SaveSLAs()
+	p.SaveTemplates()
+	p.SaveApprovals()
+	p.SaveVersions()
 	p.SaveAliases()
+	p.SaveLanguageRoutes()
+	p.SaveDeadLetters()
+	p.SaveSyncStatus()
 	p.SaveReviews()
 }
 
-// LoadAll loads all information (packages, aliases, alerts and reviews) from the persistant space
+// LoadAll loads all the plugin information from the persistant space
 func (p *Plugin) LoadAll() {
+	p.control.sharedStateMutex.Lock()
+	defer p.control.sharedStateMutex.Unlock()
+
 	p.LoadPackages()
 	p.LoadAlerts()
+	p.LoadSLAs()
+	p.LoadTemplates()
+	p.LoadApprovals()
+	p.LoadVersions()
 	p.LoadAliases()
+	p.LoadLanguageRoutes()
+	p.LoadDeadLetters()
+	p.LoadSyncStatus()
 	p.LoadReviews()
 }
+
+// loadSharedState reloads the information the commands may have changed on other nodes of the cluster.
+// The reviews, the known versions and the sync status are only written by the leader, so it does not load them.
+func (p *Plugin) loadSharedState() {
+	p.control.sharedStateMutex.Lock()
+	defer p.control.sharedStateMutex.Unlock()
+
+	p.LoadPackages()
+	p.LoadAliases()
+	p.LoadAlerts()
+	p.LoadSLAs()
+	p.LoadTemplates()
+	p.LoadApprovals()
+	p.LoadLanguageRoutes()
+	p.LoadDeadLetters()
+	if !p.isLeader() {
+		p.LoadSyncStatus()
+		p.LoadReviews()
+	}
+}
+
+// updateSharedState applies edit over the part of the shared state last stored by any node, reading it with load
+// and storing it with save. If another node changed it meanwhile, it is read and edited again, so edit must decide
+// on the state it finds.
+func (p *Plugin) updateSharedState(load func(), save func() error, edit func()) error {
+	p.control.sharedStateMutex.Lock()
+	defer p.control.sharedStateMutex.Unlock()
+
+	for attempt := 0; attempt < maxSharedStateRetries; attempt++ {
+		load()
+		edit()
+		if err := save(); err != errStateChanged {
+			return err
+		}
+	}
+	p.logError("Error saving the shared state, other nodes kept changing it")
+	return errStateChanged
+}
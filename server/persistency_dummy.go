@@ -1,17 +1,14 @@
 package main
 
-import (
-	"google.golang.org/api/androidpublisher/v3"
-)
-
 type dummyPersistency struct{}
 
 // Init initializes the persistency system
-func (p *dummyPersistency) Init() {
+func (p *dummyPersistency) Init(log pluginLogger) {
 }
 
 // SavePackages stores the package list on the persistant space
-func (p *dummyPersistency) SavePackages(packageList []PackageInfo) {
+func (p *dummyPersistency) SavePackages(packageList []PackageInfo) error {
+	return nil
 }
 
 // LoadPackages loads the package list from the persistant space
@@ -20,7 +17,8 @@ func (p *dummyPersistency) LoadPackages(packageList *[]PackageInfo) bool {
 }
 
 // SaveAliases stores the package aliases on the persistant space
-func (p *dummyPersistency) SaveAliases(aliases map[string]map[string]string) {
+func (p *dummyPersistency) SaveAliases(aliases map[string]map[string]string) error {
+	return nil
 }
 
 // LoadAliases loads the package aliases from the persistant space
@@ -29,19 +27,88 @@ func (p *dummyPersistency) LoadAliases(aliases *map[string]map[string]string) bo
 }
 
 // SaveReviews stores the reviews on the persistant space
-func (p *dummyPersistency) SaveReviews(reviews map[string]map[string][]*androidpublisher.Review) {
+func (p *dummyPersistency) SaveReviews(reviews map[string]map[string][]*Review) {
 }
 
 // LoadReviews loads the reviews from the persistant space
-func (p *dummyPersistency) LoadReviews(reviews *map[string]map[string][]*androidpublisher.Review) bool {
+func (p *dummyPersistency) LoadReviews(reviews *map[string]map[string][]*Review) bool {
 	return true
 }
 
 // SaveAlerts stores the alerts on the persistant space
-func (p *dummyPersistency) SaveAlerts(alerts AlertsContainer) {
+func (p *dummyPersistency) SaveAlerts(alerts AlertsContainer) error {
+	return nil
 }
 
 // LoadAlerts loads the alerts from the persistant space
 func (p *dummyPersistency) LoadAlerts(alerts *AlertsContainer) bool {
 	return true
 }
+
+// SaveSLAs stores the SLA rules on the persistant space
+func (p *dummyPersistency) SaveSLAs(slaRules map[string]map[string]*SLARule) error {
+	return nil
+}
+
+// LoadSLAs loads the SLA rules from the persistant space
+func (p *dummyPersistency) LoadSLAs(slaRules *map[string]map[string]*SLARule) bool {
+	return true
+}
+
+// SaveTemplates stores the reply templates on the persistant space
+func (p *dummyPersistency) SaveTemplates(templates map[string]map[string]string) error {
+	return nil
+}
+
+// LoadTemplates loads the reply templates from the persistant space
+func (p *dummyPersistency) LoadTemplates(templates *map[string]map[string]string) bool {
+	return true
+}
+
+// SaveApprovals stores the reply approvals on the persistant space
+func (p *dummyPersistency) SaveApprovals(approvals ApprovalsContainer) error {
+	return nil
+}
+
+// LoadApprovals loads the reply approvals from the persistant space
+func (p *dummyPersistency) LoadApprovals(approvals *ApprovalsContainer) bool {
+	return true
+}
+
+// SaveVersions stores the known app versions on the persistant space
+func (p *dummyPersistency) SaveVersions(versions map[string]map[string]map[int64]string) {
+}
+
+// LoadVersions loads the known app versions from the persistant space
+func (p *dummyPersistency) LoadVersions(versions *map[string]map[string]map[int64]string) bool {
+	return true
+}
+
+// SaveLanguageRoutes stores the language routes on the persistant space
+func (p *dummyPersistency) SaveLanguageRoutes(routes map[string]map[string]*LanguageRoutes) error {
+	return nil
+}
+
+// LoadLanguageRoutes loads the language routes from the persistant space
+func (p *dummyPersistency) LoadLanguageRoutes(routes *map[string]map[string]*LanguageRoutes) bool {
+	return true
+}
+
+// SaveDeadLetters stores the undelivered webhook payloads on the persistant space
+func (p *dummyPersistency) SaveDeadLetters(deadLetters []*DeadLetter) error {
+	return nil
+}
+
+// LoadDeadLetters loads the undelivered webhook payloads from the persistant space
+func (p *dummyPersistency) LoadDeadLetters(deadLetters *[]*DeadLetter) bool {
+	return true
+}
+
+// SaveSyncStatus stores the sync status of the apps on the persistant space
+func (p *dummyPersistency) SaveSyncStatus(status map[string]*packageSyncStatus) {
+}
+
+// LoadSyncStatus loads the sync status of the apps from the persistant space
+func (p *dummyPersistency) LoadSyncStatus(status *map[string]*packageSyncStatus) bool {
+	return true
+}
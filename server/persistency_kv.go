@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin"
+)
+
+const (
+	kvPackagesKey       = "state_packages"
+	kvAliasesKey        = "state_aliases"
+	kvAlertsKey         = "state_alerts"
+	kvSLAsKey           = "state_slas"
+	kvTemplatesKey      = "state_templates"
+	kvApprovalsKey      = "state_approvals"
+	kvVersionsKey       = "state_versions"
+	kvRoutesKey         = "state_routes"
+	kvDeadLettersKey    = "state_deadletters"
+	kvSyncStatusKey     = "state_syncstatus"
+	kvReviewsVersionKey = "state_reviews_version"
+	// kvReviewsKeyPrefix is followed by the hash of the user and the app
+	kvReviewsKeyPrefix = "reviews_"
+)
+
+// kvPersistency stores the plugin information on the KV store, shared by all the nodes of the cluster.
+// The reviews of each app are stored on their own key, written only when they change.
+type kvPersistency struct {
+	api plugin.API
+	log pluginLogger
+
+	lock sync.Mutex
+	// stored keeps the value of each key of the shared state as this node last read or wrote it, to write the
+	// changes only if no other node changed the key meanwhile
+	stored map[string][]byte
+	// reviewHashes stores the hash of the reviews of each app last written or read by this node
+	reviewHashes map[string][sha256.Size]byte
+	// reviewsVersion changes every time a node writes reviews, so the other nodes only read them when they changed
+	reviewsVersion []byte
+}
+
+// kvPackageReviews is the value stored for the reviews of an app
+type kvPackageReviews struct {
+	UserID      string
+	PackageName string
+	Reviews     []*Review
+}
+
+func newKVPersistency(api plugin.API) *kvPersistency {
+	return &kvPersistency{
+		api:          api,
+		stored:       make(map[string][]byte),
+		reviewHashes: make(map[string][sha256.Size]byte),
+	}
+}
+
+// Init initializes the persistency system
+func (p *kvPersistency) Init(log pluginLogger) {
+	p.log = log
+}
+
+// getHashedKey follows the prefix with the hash of the value, filling the KV key up to its limit of runes.
+// It is used for the keys holding values of unbounded length, like user input.
+func getHashedKey(prefix string, value string) string {
+	hash := sha256.Sum256([]byte(value))
+	return prefix + hex.EncodeToString(hash[:])[:model.KEY_VALUE_KEY_MAX_RUNES-len(prefix)]
+}
+
+func getReviewsKey(userID string, packageName string) string {
+	return getHashedKey(kvReviewsKeyPrefix, getPackageKey(userID, packageName))
+}
+
+func (p *kvPersistency) save(key string, what string, value interface{}) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		p.log.logError("Error saving "+what, "key", key, "err", err.Error())
+		return
+	}
+
+	if appErr := p.api.KVSet(key, data); appErr != nil {
+		p.log.logError("Error saving "+what, "key", key, "err", appErr.Error())
+	}
+}
+
+// saveShared stores the value of a key of the shared state, unless another node changed it since this node last
+// read or wrote it, returning errStateChanged. The caller reads the key again and repeats its change.
+func (p *kvPersistency) saveShared(key string, what string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		p.log.logError("Error saving "+what, "key", key, "err", err.Error())
+		return err
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	previous := p.stored[key]
+	if bytes.Equal(previous, data) {
+		return nil
+	}
+	ok, appErr := p.api.KVSetWithOptions(key, data, model.PluginKVSetOptions{Atomic: true, OldValue: previous})
+	if appErr != nil {
+		p.log.logError("Error saving "+what, "key", key, "err", appErr.Error())
+		return appErr
+	}
+	if !ok {
+		return errStateChanged
+	}
+	p.stored[key] = data
+	return nil
+}
+
+// load reads the value of the key on a new value, so the entries removed by other nodes do not survive on the
+// maps, and stores it on value. It returns false, keeping value, if the key was never written.
+func (p *kvPersistency) load(key string, what string, value interface{}) bool {
+	data, appErr := p.api.KVGet(key)
+	if appErr != nil {
+		p.log.logError("Error loading "+what, "key", key, "err", appErr.Error())
+		return false
+	}
+	p.lock.Lock()
+	p.stored[key] = data
+	p.lock.Unlock()
+	if data == nil {
+		return false
+	}
+
+	loaded := reflect.New(reflect.TypeOf(value).Elem())
+	if err := json.Unmarshal(data, loaded.Interface()); err != nil {
+		p.log.logError("Error loading "+what, "key", key, "err", err.Error())
+		return false
+	}
+	reflect.ValueOf(value).Elem().Set(loaded.Elem())
+	return true
+}
+
+// SavePackages stores the package list on the persistant space
+func (p *kvPersistency) SavePackages(packageList []PackageInfo) error {
+	return p.saveShared(kvPackagesKey, "packages", packageList)
+}
+
+// LoadPackages loads the package list from the persistant space
+func (p *kvPersistency) LoadPackages(packageList *[]PackageInfo) bool {
+	return p.load(kvPackagesKey, "packages", packageList)
+}
+
+// SaveAliases stores the package aliases on the persistant space
+func (p *kvPersistency) SaveAliases(aliases map[string]map[string]string) error {
+	return p.saveShared(kvAliasesKey, "aliases", aliases)
+}
+
+// LoadAliases loads the package aliases from the persistant space
+func (p *kvPersistency) LoadAliases(aliases *map[string]map[string]string) bool {
+	return p.load(kvAliasesKey, "aliases", aliases)
+}
+
+// SaveReviews stores the reviews of the apps that changed since this node last wrote or read them
+func (p *kvPersistency) SaveReviews(reviews map[string]map[string][]*Review) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	changed := false
+	for userID, userReviews := range reviews {
+		for packageName, packageReviews := range userReviews {
+			key := getReviewsKey(userID, packageName)
+			data, err := json.Marshal(kvPackageReviews{UserID: userID, PackageName: packageName, Reviews: packageReviews})
+			if err != nil {
+				p.log.logError("Error saving reviews", "key", key, "err", err.Error())
+				continue
+			}
+
+			hash := sha256.Sum256(data)
+			if hash == p.reviewHashes[key] {
+				continue
+			}
+			if appErr := p.api.KVSet(key, data); appErr != nil {
+				p.log.logError("Error saving reviews", "key", key, "err", appErr.Error())
+				continue
+			}
+			p.reviewHashes[key] = hash
+			changed = true
+		}
+	}
+
+	if !changed {
+		return
+	}
+	version := []byte(model.NewId())
+	if appErr := p.api.KVSet(kvReviewsVersionKey, version); appErr != nil {
+		p.log.logError("Error saving reviews", "key", kvReviewsVersionKey, "err", appErr.Error())
+		return
+	}
+	p.reviewsVersion = version
+}
+
+// LoadReviews loads the reviews from the persistant space, unless no node changed them since this node last did
+func (p *kvPersistency) LoadReviews(reviews *map[string]map[string][]*Review) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	version, appErr := p.api.KVGet(kvReviewsVersionKey)
+	if appErr != nil {
+		p.log.logError("Error loading reviews", "key", kvReviewsVersionKey, "err", appErr.Error())
+		return false
+	}
+	if version == nil {
+		return false
+	}
+	if bytes.Equal(version, p.reviewsVersion) {
+		return true
+	}
+
+	loaded := make(map[string]map[string][]*Review)
+	hashes := make(map[string][sha256.Size]byte)
+	const perPage = 100
+	for page := 0; ; page++ {
+		keys, appErr := p.api.KVList(page, perPage)
+		if appErr != nil {
+			p.log.logError("Error loading reviews", "err", appErr.Error())
+			return false
+		}
+
+		for _, key := range keys {
+			if !strings.HasPrefix(key, kvReviewsKeyPrefix) {
+				continue
+			}
+			data, appErr := p.api.KVGet(key)
+			if appErr != nil || data == nil {
+				continue
+			}
+			var packageReviews kvPackageReviews
+			if err := json.Unmarshal(data, &packageReviews); err != nil {
+				p.log.logError("Error loading reviews", "key", key, "err", err.Error())
+				continue
+			}
+			if _, ok := loaded[packageReviews.UserID]; !ok {
+				loaded[packageReviews.UserID] = make(map[string][]*Review)
+			}
+			loaded[packageReviews.UserID][packageReviews.PackageName] = packageReviews.Reviews
+			hashes[key] = sha256.Sum256(data)
+		}
+
+		if len(keys) < perPage {
+			break
+		}
+	}
+
+	*reviews = loaded
+	p.reviewHashes = hashes
+	p.reviewsVersion = version
+	return true
+}
+
+// SaveAlerts stores the alerts on the persistant space
+func (p *kvPersistency) SaveAlerts(alerts AlertsContainer) error {
+	return p.saveShared(kvAlertsKey, "alerts", alerts)
+}
+
+// LoadAlerts loads the alerts from the persistant space
+func (p *kvPersistency) LoadAlerts(alerts *AlertsContainer) bool {
+	return p.load(kvAlertsKey, "alerts", alerts)
+}
+
+// SaveSLAs stores the SLA rules on the persistant space
+func (p *kvPersistency) SaveSLAs(rules map[string]map[string]*SLARule) error {
+	return p.saveShared(kvSLAsKey, "SLA rules", rules)
+}
+
+// LoadSLAs loads the SLA rules from the persistant space
+func (p *kvPersistency) LoadSLAs(rules *map[string]map[string]*SLARule) bool {
+	return p.load(kvSLAsKey, "SLA rules", rules)
+}
+
+// SaveTemplates stores the reply templates on the persistant space
+func (p *kvPersistency) SaveTemplates(templates map[string]map[string]string) error {
+	return p.saveShared(kvTemplatesKey, "reply templates", templates)
+}
+
+// LoadTemplates loads the reply templates from the persistant space
+func (p *kvPersistency) LoadTemplates(templates *map[string]map[string]string) bool {
+	return p.load(kvTemplatesKey, "reply templates", templates)
+}
+
+// SaveApprovals stores the reply approvals on the persistant space
+func (p *kvPersistency) SaveApprovals(approvals ApprovalsContainer) error {
+	return p.saveShared(kvApprovalsKey, "reply approvals", approvals)
+}
+
+// LoadApprovals loads the reply approvals from the persistant space
+func (p *kvPersistency) LoadApprovals(approvals *ApprovalsContainer) bool {
+	return p.load(kvApprovalsKey, "reply approvals", approvals)
+}
+
+// SaveVersions stores the known app versions on the persistant space
+func (p *kvPersistency) SaveVersions(versions map[string]map[string]map[int64]string) {
+	p.save(kvVersionsKey, "known app versions", versions)
+}
+
+// LoadVersions loads the known app versions from the persistant space
+func (p *kvPersistency) LoadVersions(versions *map[string]map[string]map[int64]string) bool {
+	return p.load(kvVersionsKey, "known app versions", versions)
+}
+
+// SaveLanguageRoutes stores the language routes on the persistant space
+func (p *kvPersistency) SaveLanguageRoutes(routes map[string]map[string]*LanguageRoutes) error {
+	return p.saveShared(kvRoutesKey, "language routes", routes)
+}
+
+// LoadLanguageRoutes loads the language routes from the persistant space
+func (p *kvPersistency) LoadLanguageRoutes(routes *map[string]map[string]*LanguageRoutes) bool {
+	return p.load(kvRoutesKey, "language routes", routes)
+}
+
+// SaveDeadLetters stores the undelivered webhook payloads on the persistant space
+func (p *kvPersistency) SaveDeadLetters(deadLetters []*DeadLetter) error {
+	return p.saveShared(kvDeadLettersKey, "dead letters", deadLetters)
+}
+
+// LoadDeadLetters loads the undelivered webhook payloads from the persistant space
+func (p *kvPersistency) LoadDeadLetters(deadLetters *[]*DeadLetter) bool {
+	return p.load(kvDeadLettersKey, "dead letters", deadLetters)
+}
+
+// SaveSyncStatus stores the sync status of the apps on the persistant space
+func (p *kvPersistency) SaveSyncStatus(status map[string]*packageSyncStatus) {
+	p.save(kvSyncStatusKey, "sync status", status)
+}
+
+// LoadSyncStatus loads the sync status of the apps from the persistant space
+func (p *kvPersistency) LoadSyncStatus(status *map[string]*packageSyncStatus) bool {
+	return p.load(kvSyncStatusKey, "sync status", status)
+}
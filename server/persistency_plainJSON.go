@@ -2,137 +2,355 @@ package main
 
 import (
 	"encoding/json"
-	"fmt"
 	"io/ioutil"
-
-	"google.golang.org/api/androidpublisher/v3"
 )
 
 type plainJSONPersistency struct {
+	log pluginLogger
+
 	defaultConfigFilename string
 	configFilename        string
 	packagesFilename      string
 	aliasesFilename       string
 	reviewsFilename       string
 	alertsFilename        string
+	slasFilename          string
+	templatesFilename     string
+	approvalsFilename     string
+	versionsFilename      string
+	routesFilename        string
+	deadLettersFilename   string
+	syncStatusFilename    string
 }
 
-func (p *plainJSONPersistency) Init() {
+func (p *plainJSONPersistency) Init(log pluginLogger) {
+	p.log = log
 	p.defaultConfigFilename = "default_config.json"
 	p.configFilename = "data/config.json"
 	p.packagesFilename = "data/packages.json"
 	p.aliasesFilename = "data/aliases.json"
 	p.reviewsFilename = "data/reviews.json"
 	p.alertsFilename = "data/alerts.json"
+	p.slasFilename = "data/slas.json"
+	p.templatesFilename = "data/templates.json"
+	p.approvalsFilename = "data/approvals.json"
+	p.versionsFilename = "data/versions.json"
+	p.routesFilename = "data/routes.json"
+	p.deadLettersFilename = "data/deadletters.json"
+	p.syncStatusFilename = "data/syncstatus.json"
 }
 
-func (p *plainJSONPersistency) SavePackages(packageList []PackageInfo) {
+func (p *plainJSONPersistency) SavePackages(packageList []PackageInfo) error {
 	data, err := json.MarshalIndent(packageList, "", "    ")
 	if err != nil {
-		fmt.Println("Error saving packages: " + err.Error())
-		return
+		p.log.logError("Error saving packages", "file", p.packagesFilename, "err", err.Error())
+		return err
 	}
 
 	err = ioutil.WriteFile(p.packagesFilename, data, 0644)
 	if err != nil {
-		fmt.Println("Error saving packages: " + err.Error())
+		p.log.logError("Error saving packages", "file", p.packagesFilename, "err", err.Error())
 	}
+	return err
 }
 
 func (p *plainJSONPersistency) LoadPackages(packageList *[]PackageInfo) bool {
 	data, err := ioutil.ReadFile(p.packagesFilename)
 	if err != nil {
-		fmt.Println("Error loading packages: " + err.Error())
+		p.log.logError("Error loading packages", "file", p.packagesFilename, "err", err.Error())
 		return false
 	}
 
 	err = json.Unmarshal(data, packageList)
 	if err != nil {
-		fmt.Println("Error loading packages: " + err.Error())
+		p.log.logError("Error loading packages", "file", p.packagesFilename, "err", err.Error())
 		return false
 	}
 	return true
 }
 
-func (p *plainJSONPersistency) SaveAliases(aliases map[string]map[string]string) {
+func (p *plainJSONPersistency) SaveAliases(aliases map[string]map[string]string) error {
 	data, err := json.MarshalIndent(aliases, "", "    ")
 	if err != nil {
-		fmt.Println("Error saving aliases: " + err.Error())
-		return
+		p.log.logError("Error saving aliases", "file", p.aliasesFilename, "err", err.Error())
+		return err
 	}
 
 	err = ioutil.WriteFile(p.aliasesFilename, data, 0644)
 	if err != nil {
-		fmt.Println("Error saving aliases: " + err.Error())
+		p.log.logError("Error saving aliases", "file", p.aliasesFilename, "err", err.Error())
 	}
+	return err
 }
 
 func (p *plainJSONPersistency) LoadAliases(aliases *map[string]map[string]string) bool {
 	data, err := ioutil.ReadFile(p.aliasesFilename)
 	if err != nil {
-		fmt.Println("Error loading aliases: " + err.Error())
+		p.log.logError("Error loading aliases", "file", p.aliasesFilename, "err", err.Error())
 		return false
 	}
 
 	err = json.Unmarshal(data, aliases)
 	if err != nil {
-		fmt.Println("Error loading aliases: " + err.Error())
+		p.log.logError("Error loading aliases", "file", p.aliasesFilename, "err", err.Error())
 		return false
 	}
 	return true
 }
 
-func (p *plainJSONPersistency) SaveReviews(reviews map[string]map[string][]*androidpublisher.Review) {
+func (p *plainJSONPersistency) SaveReviews(reviews map[string]map[string][]*Review) {
 	data, err := json.MarshalIndent(reviews, "", "    ")
 	if err != nil {
-		fmt.Println("Error saving reviews: " + err.Error())
+		p.log.logError("Error saving reviews", "file", p.reviewsFilename, "err", err.Error())
 		return
 	}
 
 	err = ioutil.WriteFile(p.reviewsFilename, data, 0644)
 	if err != nil {
-		fmt.Println("Error saving reviews: " + err.Error())
+		p.log.logError("Error saving reviews", "file", p.reviewsFilename, "err", err.Error())
 	}
 }
 
-func (p *plainJSONPersistency) LoadReviews(reviews *map[string]map[string][]*androidpublisher.Review) bool {
+func (p *plainJSONPersistency) LoadReviews(reviews *map[string]map[string][]*Review) bool {
 	data, err := ioutil.ReadFile(p.reviewsFilename)
 	if err != nil {
-		fmt.Println("Error loading reviews: " + err.Error())
+		p.log.logError("Error loading reviews", "file", p.reviewsFilename, "err", err.Error())
 		return false
 	}
 
-	err = json.Unmarshal(data, reviews)
+	err = unmarshalReviews(data, reviews)
 	if err != nil {
-		fmt.Println("Error loading reviews: " + err.Error())
+		p.log.logError("Error loading reviews", "file", p.reviewsFilename, "err", err.Error())
 		return false
 	}
 	return true
 }
 
-func (p *plainJSONPersistency) SaveAlerts(alerts AlertsContainer) {
+func (p *plainJSONPersistency) SaveAlerts(alerts AlertsContainer) error {
 	data, err := json.MarshalIndent(alerts, "", "    ")
 	if err != nil {
-		fmt.Println("Error saving alerts: " + err.Error())
-		return
+		p.log.logError("Error saving alerts", "file", p.alertsFilename, "err", err.Error())
+		return err
 	}
 
 	err = ioutil.WriteFile(p.alertsFilename, data, 0644)
 	if err != nil {
-		fmt.Println("Error saving alerts: " + err.Error())
+		p.log.logError("Error saving alerts", "file", p.alertsFilename, "err", err.Error())
 	}
+	return err
 }
 
 func (p *plainJSONPersistency) LoadAlerts(alerts *AlertsContainer) bool {
 	data, err := ioutil.ReadFile(p.alertsFilename)
 	if err != nil {
-		fmt.Println("Error loading alerts: " + err.Error())
+		p.log.logError("Error loading alerts", "file", p.alertsFilename, "err", err.Error())
 		return false
 	}
 
 	err = json.Unmarshal(data, alerts)
 	if err != nil {
-		fmt.Println("Error loading alerts: " + err.Error())
+		p.log.logError("Error loading alerts", "file", p.alertsFilename, "err", err.Error())
+		return false
+	}
+	return true
+}
+
+func (p *plainJSONPersistency) SaveSLAs(slaRules map[string]map[string]*SLARule) error {
+	data, err := json.MarshalIndent(slaRules, "", "    ")
+	if err != nil {
+		p.log.logError("Error saving SLAs", "file", p.slasFilename, "err", err.Error())
+		return err
+	}
+
+	err = ioutil.WriteFile(p.slasFilename, data, 0644)
+	if err != nil {
+		p.log.logError("Error saving SLAs", "file", p.slasFilename, "err", err.Error())
+	}
+	return err
+}
+
+func (p *plainJSONPersistency) LoadSLAs(slaRules *map[string]map[string]*SLARule) bool {
+	data, err := ioutil.ReadFile(p.slasFilename)
+	if err != nil {
+		p.log.logError("Error loading SLAs", "file", p.slasFilename, "err", err.Error())
+		return false
+	}
+
+	err = json.Unmarshal(data, slaRules)
+	if err != nil {
+		p.log.logError("Error loading SLAs", "file", p.slasFilename, "err", err.Error())
+		return false
+	}
+	return true
+}
+
+func (p *plainJSONPersistency) SaveTemplates(templates map[string]map[string]string) error {
+	data, err := json.MarshalIndent(templates, "", "    ")
+	if err != nil {
+		p.log.logError("Error saving reply templates", "file", p.templatesFilename, "err", err.Error())
+		return err
+	}
+
+	err = ioutil.WriteFile(p.templatesFilename, data, 0644)
+	if err != nil {
+		p.log.logError("Error saving reply templates", "file", p.templatesFilename, "err", err.Error())
+	}
+	return err
+}
+
+func (p *plainJSONPersistency) LoadTemplates(templates *map[string]map[string]string) bool {
+	data, err := ioutil.ReadFile(p.templatesFilename)
+	if err != nil {
+		p.log.logError("Error loading reply templates", "file", p.templatesFilename, "err", err.Error())
+		return false
+	}
+
+	err = json.Unmarshal(data, templates)
+	if err != nil {
+		p.log.logError("Error loading reply templates", "file", p.templatesFilename, "err", err.Error())
+		return false
+	}
+	return true
+}
+
+func (p *plainJSONPersistency) SaveApprovals(approvals ApprovalsContainer) error {
+	data, err := json.MarshalIndent(approvals, "", "    ")
+	if err != nil {
+		p.log.logError("Error saving reply approvals", "file", p.approvalsFilename, "err", err.Error())
+		return err
+	}
+
+	err = ioutil.WriteFile(p.approvalsFilename, data, 0644)
+	if err != nil {
+		p.log.logError("Error saving reply approvals", "file", p.approvalsFilename, "err", err.Error())
+	}
+	return err
+}
+
+func (p *plainJSONPersistency) LoadApprovals(approvals *ApprovalsContainer) bool {
+	data, err := ioutil.ReadFile(p.approvalsFilename)
+	if err != nil {
+		p.log.logError("Error loading reply approvals", "file", p.approvalsFilename, "err", err.Error())
+		return false
+	}
+
+	err = json.Unmarshal(data, approvals)
+	if err != nil {
+		p.log.logError("Error loading reply approvals", "file", p.approvalsFilename, "err", err.Error())
+		return false
+	}
+	return true
+}
+
+func (p *plainJSONPersistency) SaveVersions(versions map[string]map[string]map[int64]string) {
+	data, err := json.MarshalIndent(versions, "", "    ")
+	if err != nil {
+		p.log.logError("Error saving known app versions", "file", p.versionsFilename, "err", err.Error())
+		return
+	}
+
+	err = ioutil.WriteFile(p.versionsFilename, data, 0644)
+	if err != nil {
+		p.log.logError("Error saving known app versions", "file", p.versionsFilename, "err", err.Error())
+	}
+}
+
+func (p *plainJSONPersistency) LoadVersions(versions *map[string]map[string]map[int64]string) bool {
+	data, err := ioutil.ReadFile(p.versionsFilename)
+	if err != nil {
+		p.log.logError("Error loading known app versions", "file", p.versionsFilename, "err", err.Error())
+		return false
+	}
+
+	err = json.Unmarshal(data, versions)
+	if err != nil {
+		p.log.logError("Error loading known app versions", "file", p.versionsFilename, "err", err.Error())
+		return false
+	}
+	return true
+}
+
+func (p *plainJSONPersistency) SaveLanguageRoutes(routes map[string]map[string]*LanguageRoutes) error {
+	data, err := json.MarshalIndent(routes, "", "    ")
+	if err != nil {
+		p.log.logError("Error saving language routes", "file", p.routesFilename, "err", err.Error())
+		return err
+	}
+
+	err = ioutil.WriteFile(p.routesFilename, data, 0644)
+	if err != nil {
+		p.log.logError("Error saving language routes", "file", p.routesFilename, "err", err.Error())
+	}
+	return err
+}
+
+func (p *plainJSONPersistency) LoadLanguageRoutes(routes *map[string]map[string]*LanguageRoutes) bool {
+	data, err := ioutil.ReadFile(p.routesFilename)
+	if err != nil {
+		p.log.logError("Error loading language routes", "file", p.routesFilename, "err", err.Error())
+		return false
+	}
+
+	err = json.Unmarshal(data, routes)
+	if err != nil {
+		p.log.logError("Error loading language routes", "file", p.routesFilename, "err", err.Error())
+		return false
+	}
+	return true
+}
+
+func (p *plainJSONPersistency) SaveDeadLetters(deadLetters []*DeadLetter) error {
+	data, err := json.MarshalIndent(deadLetters, "", "    ")
+	if err != nil {
+		p.log.logError("Error saving dead letters", "file", p.deadLettersFilename, "err", err.Error())
+		return err
+	}
+
+	err = ioutil.WriteFile(p.deadLettersFilename, data, 0644)
+	if err != nil {
+		p.log.logError("Error saving dead letters", "file", p.deadLettersFilename, "err", err.Error())
+	}
+	return err
+}
+
+func (p *plainJSONPersistency) LoadDeadLetters(deadLetters *[]*DeadLetter) bool {
+	data, err := ioutil.ReadFile(p.deadLettersFilename)
+	if err != nil {
+		p.log.logError("Error loading dead letters", "file", p.deadLettersFilename, "err", err.Error())
+		return false
+	}
+
+	err = json.Unmarshal(data, deadLetters)
+	if err != nil {
+		p.log.logError("Error loading dead letters", "file", p.deadLettersFilename, "err", err.Error())
+		return false
+	}
+	return true
+}
+
+func (p *plainJSONPersistency) SaveSyncStatus(status map[string]*packageSyncStatus) {
+	data, err := json.MarshalIndent(status, "", "    ")
+	if err != nil {
+		p.log.logError("Error saving sync status", "file", p.syncStatusFilename, "err", err.Error())
+		return
+	}
+
+	err = ioutil.WriteFile(p.syncStatusFilename, data, 0644)
+	if err != nil {
+		p.log.logError("Error saving sync status", "file", p.syncStatusFilename, "err", err.Error())
+	}
+}
+
+func (p *plainJSONPersistency) LoadSyncStatus(status *map[string]*packageSyncStatus) bool {
+	data, err := ioutil.ReadFile(p.syncStatusFilename)
+	if err != nil {
+		p.log.logError("Error loading sync status", "file", p.syncStatusFilename, "err", err.Error())
+		return false
+	}
+
+	err = json.Unmarshal(data, status)
+	if err != nil {
+		p.log.logError("Error loading sync status", "file", p.syncStatusFilename, "err", err.Error())
 		return false
 	}
 	return true
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"sync"
 
+	"github.com/mattermost/mattermost-server/v5/model"
 	"github.com/mattermost/mattermost-server/v5/plugin"
 	"golang.org/x/oauth2"
 	"google.golang.org/api/androidpublisher/v3"
@@ -22,26 +23,51 @@ type Plugin struct {
 	configuration *configuration
 	persistency   persistencyInt
 	control       ControlUtils
+	botUserID     string
+	metrics       *pluginMetrics
+	syncStatus    map[string]*packageSyncStatus
+	scheduler     *syncScheduler
+	elector       *leaderElector
+	// classifiedWith stores the topic dictionaries the cached reviews of each app were tagged with, guarded by reviewsMutex
+	classifiedWith map[string]map[string]map[string][]string
 
 	// persistent data
 	// Newer reviews will always be on the lower ids of the slice
-	localReviews map[string]map[string][]*androidpublisher.Review
-	packageList  []PackageInfo
-	aliases      map[string]map[string]string
-	alerts       AlertsContainer
-	token        *oauth2.Token
+	localReviews map[string]map[string][]*Review
+	// state stores the apps, aliases, alerts, SLAs, templates, approvals, known versions and language routes
+	state *stateStore
+	// deadLetters are the webhook payloads not delivered after all the retries, oldest first
+	deadLetters []*DeadLetter
+	token       *oauth2.Token
 }
 
 // PackageInfo stores all needed information to process each package
 type PackageInfo struct {
 	Name   string
 	UserID string
+	// PollInterval is how often, in seconds, the reviews are fetched. Zero uses the configured refresh time.
+	PollInterval int64
+	// Account is the name of the store connection used for the app. Empty uses the default one.
+	Account string
+	// Store is where the app is published. Empty is Google Play.
+	Store string
+	// Language is the language the reviews are translated to. Empty does not translate them.
+	Language string
 }
 
-//ControlUtils contains all the mutex used for flow control
+// ControlUtils contains all the mutex used for flow control
 type ControlUtils struct {
 	reviewsMutex sync.RWMutex
 
+	// syncStatusMutex synchronizes access to the sync status of the packages.
+	syncStatusMutex sync.RWMutex
+
+	// deadLettersMutex synchronizes access to the undelivered webhook payloads.
+	deadLettersMutex sync.Mutex
+
+	// sharedStateMutex keeps the reloads of the shared state from undoing the changes being stored by this node.
+	sharedStateMutex sync.Mutex
+
 	// configurationLock synchronizes access to the configuration.
 	configurationLock sync.RWMutex
 }
@@ -50,6 +76,9 @@ type ControlUtils struct {
 type AlertsContainer struct {
 	NewReviewsAlerts map[string]map[string]*NewReviewsAlert
 	NewUpdatesAlerts map[string]map[string]*NewUpdatesAlert
+	KeywordsAlerts   map[string]map[string]*KeywordsAlert
+	AnomalyAlerts    map[string]map[string]*AnomalyAlert
+	NewVersionAlerts map[string]map[string]*NewVersionAlert
 }
 
 // OnActivate executes whenever the plugin is activated.
@@ -62,35 +91,52 @@ func (p *Plugin) OnActivate() error {
 		return fmt.Errorf("failed to register command: %v", err)
 	}
 
+	botUserID, err := p.Helpers.EnsureBot(&model.Bot{
+		Username:    "gpreviews",
+		DisplayName: "Google Play Reviews",
+		Description: "Created by the Google Play Reviews plugin.",
+	})
+	if err != nil {
+		return fmt.Errorf("failed to ensure bot account: %v", err)
+	}
+	p.botUserID = botUserID
+
 	p.init()
+	p.persistency.Init(p)
+	p.LoadAll()
 
+	go p.watchLeadership()
 	go p.getAllReviews()
 	go p.watchAlerts()
 
-	p.persistency.Init()
-	p.LoadAll()
+	return nil
+}
 
+// OnDeactivate hands the background jobs over to another node of the cluster
+func (p *Plugin) OnDeactivate() error {
+	p.releaseLeaderLease()
 	return nil
 }
 
 func (p *Plugin) init() {
-	p.packageList = []PackageInfo{}
-	p.aliases = make(map[string]map[string]string)
-	p.localReviews = make(map[string]map[string][]*androidpublisher.Review)
-	p.persistency = &dummyPersistency{}
-	p.alerts = AlertsContainer{
-		NewReviewsAlerts: make(map[string]map[string]*NewReviewsAlert),
-		NewUpdatesAlerts: make(map[string]map[string]*NewUpdatesAlert),
-	}
+	p.state = newStateStore()
+	p.localReviews = make(map[string]map[string][]*Review)
+	p.classifiedWith = make(map[string]map[string]map[string][]string)
+	p.deadLetters = []*DeadLetter{}
+	p.metrics = newPluginMetrics()
+	p.syncStatus = make(map[string]*packageSyncStatus)
+	p.scheduler = newSyncScheduler()
+	p.elector = newLeaderElector()
+	p.persistency = newKVPersistency(p.API)
 }
 
-func (p *Plugin) getService(userID string) *androidpublisher.ReviewsService {
+func (p *Plugin) getService(userID string, accountName string) *androidpublisher.ReviewsService {
 	config := p.getOAuthConfig(userID)
 
 	var userInfo *GooglePlayUserInfo
 	var err error
 
-	if userInfo, err = p.getGooglePlayUserInfo(userID); err != nil {
+	if userInfo, err = p.getGooglePlayUserInfo(userID, accountName); err != nil {
 		return nil
 	}
 	ctx := context.Background()
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// maxReplyLength is the maximum amount of characters Google Play accepts on a reply
+const maxReplyLength = 350
+
+func checkReplyLength(text string) error {
+	if length := utf8.RuneCountInString(text); length > maxReplyLength {
+		return fmt.Errorf("the reply is %d characters long, but Google Play only accepts up to %d", length, maxReplyLength)
+	}
+	return nil
+}
+
+// getReview looks for the review on the cache, and asks Google Play for it if it is not there
+func (p *Plugin) getReview(userID string, packageName string, reviewID string) (*Review, error) {
+	p.control.reviewsMutex.RLock()
+	for _, review := range p.localReviews[userID][packageName] {
+		if review.ID == reviewID {
+			p.control.reviewsMutex.RUnlock()
+			return review, nil
+		}
+	}
+	p.control.reviewsMutex.RUnlock()
+
+	source, err := p.getPackageSource(userID, packageName)
+	if err != nil {
+		return nil, err
+	}
+	packageInfo, _ := p.state.getPackage(userID, packageName)
+	return source.GetReview(packageName, reviewID, packageInfo.Language)
+}
+
+func (p *Plugin) replyToReview(userID string, packageName string, reviewID string, text string) error {
+	if err := checkReplyLength(text); err != nil {
+		return err
+	}
+
+	source, err := p.getPackageSource(userID, packageName)
+	if err != nil {
+		return err
+	}
+
+	reply, err := source.Reply(packageName, reviewID, text)
+	if err != nil {
+		return err
+	}
+
+	if reply != nil {
+		p.storeReply(userID, packageName, reviewID, reply)
+	}
+	return nil
+}
+
+// storeReply updates the cached review with the reply, so it is known before the next sync
+func (p *Plugin) storeReply(userID string, packageName string, reviewID string, reply *Reply) {
+	p.control.reviewsMutex.Lock()
+	defer p.control.reviewsMutex.Unlock()
+
+	for _, review := range p.localReviews[userID][packageName] {
+		if review.ID != reviewID {
+			continue
+		}
+		review.Reply = reply
+		p.SaveReviews()
+		return
+	}
+}
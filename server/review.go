@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	"google.golang.org/api/androidpublisher/v3"
+)
+
+// Review is a review of any store, as the plugin caches, stores and shows it
+type Review struct {
+	ID     string
+	Author string
+	// Rating is the amount of stars, from 1 to 5. Zero if unknown.
+	Rating int64
+	// Text is the text of the review, translated by the store if it was written in another language
+	Text string
+	// OriginalText is the text as the reviewer wrote it. Empty if it was not translated.
+	OriginalText string
+	// Language is the language of the reviewer, like "en" or "es_ES". Empty if unknown.
+	Language         string
+	AppVersionCode   int64
+	AppVersionName   string
+	Device           string
+	DeviceMetadata   *DeviceMetadata
+	AndroidOSVersion int64
+	// LastModified is when the reviewer wrote or last edited the review
+	LastModified time.Time
+	// Reply is the reply of the developer, nil if the review was not replied
+	Reply *Reply
+	// Sentiment is the score given by the classifier, from -1 (negative) to 1 (positive)
+	Sentiment float64
+	// Tags are the sentiment and the topics found by the classifier
+	Tags []string
+	// IssueURL is the link to the issue created from the review on the issue tracker. It is only known by the plugin.
+	IssueURL string
+}
+
+// Reply is the reply of the developer to a review
+type Reply struct {
+	Text         string
+	LastModified time.Time
+}
+
+// DeviceMetadata describes the device the review was written from
+type DeviceMetadata struct {
+	ProductName      string
+	Manufacturer     string
+	RAMMb            int64
+	ScreenDensityDpi int64
+	GlEsVersion      int64
+	CPUMake          string
+	CPUModel         string
+}
+
+// getRating returns the rating within 0 and 5, so it can be used as index of the star tables
+func (r *Review) getRating() int64 {
+	if r.Rating < 0 || r.Rating > 5 {
+		return 0
+	}
+	return r.Rating
+}
+
+// isLowRated checks if the review has 1 or 2 stars. Reviews without rating are not low rated.
+func (r *Review) isLowRated() bool {
+	return r.Rating >= 1 && r.Rating <= 2
+}
+
+// newGooglePlayReview maps a Google Play review. Missing comments leave the fields empty.
+func newGooglePlayReview(review *androidpublisher.Review) *Review {
+	if review == nil {
+		return nil
+	}
+
+	result := &Review{
+		ID:     review.ReviewId,
+		Author: review.AuthorName,
+	}
+	hasUserComment := false
+	for _, comment := range review.Comments {
+		if comment == nil {
+			continue
+		}
+		if userComment := comment.UserComment; userComment != nil && !hasUserComment {
+			hasUserComment = true
+			result.Rating = userComment.StarRating
+			result.Text = userComment.Text
+			result.OriginalText = userComment.OriginalText
+			result.Language = userComment.ReviewerLanguage
+			result.AppVersionCode = userComment.AppVersionCode
+			result.AppVersionName = userComment.AppVersionName
+			result.Device = userComment.Device
+			result.DeviceMetadata = newGooglePlayDeviceMetadata(userComment.DeviceMetadata)
+			result.AndroidOSVersion = userComment.AndroidOsVersion
+			result.LastModified = timestampToTime(userComment.LastModified)
+		}
+		if developerComment := comment.DeveloperComment; developerComment != nil && result.Reply == nil {
+			result.Reply = &Reply{
+				Text:         developerComment.Text,
+				LastModified: timestampToTime(developerComment.LastModified),
+			}
+		}
+	}
+	return result
+}
+
+func newGooglePlayDeviceMetadata(metadata *androidpublisher.DeviceMetadata) *DeviceMetadata {
+	if metadata == nil {
+		return nil
+	}
+	return &DeviceMetadata{
+		ProductName:      metadata.ProductName,
+		Manufacturer:     metadata.Manufacturer,
+		RAMMb:            metadata.RamMb,
+		ScreenDensityDpi: metadata.ScreenDensityDpi,
+		GlEsVersion:      metadata.GlEsVersion,
+		CPUMake:          metadata.CpuMake,
+		CPUModel:         metadata.CpuModel,
+	}
+}
+
+// newGooglePlayReply maps the result of a reply published on Google Play
+func newGooglePlayReply(result *androidpublisher.ReviewReplyResult) *Reply {
+	if result == nil {
+		return nil
+	}
+	return &Reply{
+		Text:         result.ReplyText,
+		LastModified: timestampToTime(result.LastEdited),
+	}
+}
+
+// unmarshalReviews decodes the stored reviews, including the ones stored by older versions as Google Play reviews
+func unmarshalReviews(data []byte, reviews *map[string]map[string][]*Review) error {
+	var raw map[string]map[string][]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	result := make(map[string]map[string][]*Review)
+	for userID, userReviews := range raw {
+		result[userID] = make(map[string][]*Review)
+		for packageName, list := range userReviews {
+			result[userID][packageName] = []*Review{}
+			for _, item := range list {
+				review, err := unmarshalReview(item)
+				if err != nil {
+					return err
+				}
+				result[userID][packageName] = append(result[userID][packageName], review)
+			}
+		}
+	}
+	*reviews = result
+	return nil
+}
+
+func unmarshalReview(data []byte) (*Review, error) {
+	var legacy androidpublisher.Review
+	if err := json.Unmarshal(data, &legacy); err == nil && len(legacy.Comments) > 0 {
+		return newGooglePlayReview(&legacy), nil
+	}
+
+	review := &Review{}
+	if err := json.Unmarshal(data, review); err != nil {
+		return nil, err
+	}
+	return review, nil
+}
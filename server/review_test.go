@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/androidpublisher/v3"
+)
+
+func TestNewGooglePlayReview(t *testing.T) {
+	review := newGooglePlayReview(&androidpublisher.Review{
+		ReviewId:   "id",
+		AuthorName: "ann",
+		Comments: []*androidpublisher.Comment{nil, {
+			UserComment: &androidpublisher.UserComment{
+				Text:             "Bueno",
+				OriginalText:     "Good",
+				ReviewerLanguage: "es",
+				StarRating:       4,
+				AppVersionCode:   7,
+				DeviceMetadata:   &androidpublisher.DeviceMetadata{Manufacturer: "Acme", RamMb: 2048},
+				LastModified:     &androidpublisher.Timestamp{Seconds: 100},
+			},
+		}, {
+			DeveloperComment: &androidpublisher.DeveloperComment{Text: "Thanks", LastModified: &androidpublisher.Timestamp{Seconds: 200}},
+		}},
+	})
+
+	assert.Equal(t, "id", review.ID)
+	assert.Equal(t, "ann", review.Author)
+	assert.Equal(t, "Bueno", review.Text)
+	assert.Equal(t, "Good", review.OriginalText)
+	assert.Equal(t, "es", review.Language)
+	assert.Equal(t, int64(4), review.Rating)
+	assert.Equal(t, int64(7), review.AppVersionCode)
+	assert.Equal(t, &DeviceMetadata{Manufacturer: "Acme", RAMMb: 2048}, review.DeviceMetadata)
+	assert.Equal(t, int64(100), review.LastModified.Unix())
+	require.NotNil(t, review.Reply)
+	assert.Equal(t, "Thanks", review.Reply.Text)
+	assert.Equal(t, int64(200), review.Reply.LastModified.Unix())
+}
+
+func TestNewGooglePlayReviewWithoutUserComment(t *testing.T) {
+	review := newGooglePlayReview(&androidpublisher.Review{
+		ReviewId: "id",
+		Comments: []*androidpublisher.Comment{{
+			DeveloperComment: &androidpublisher.DeveloperComment{Text: "Thanks"},
+		}},
+	})
+
+	assert.Equal(t, "id", review.ID)
+	assert.Equal(t, int64(0), review.Rating)
+	assert.True(t, review.LastModified.IsZero())
+	require.NotNil(t, review.Reply)
+	assert.NotPanics(t, func() { newTestPlugin().newReviewCard("user", "com.example", "en", review, false) })
+
+	assert.Nil(t, newGooglePlayReview(nil))
+	assert.NotPanics(t, func() { newGooglePlayReview(&androidpublisher.Review{ReviewId: "empty"}) })
+}
+
+func TestUnmarshalReviews(t *testing.T) {
+	legacy, err := json.Marshal(map[string]map[string][]*androidpublisher.Review{
+		"user": {"com.example": {{
+			ReviewId: "old",
+			Comments: []*androidpublisher.Comment{{
+				UserComment: &androidpublisher.UserComment{Text: "Old", StarRating: 2, LastModified: &androidpublisher.Timestamp{Seconds: 100}},
+			}},
+		}}},
+	})
+	require.NoError(t, err)
+
+	reviews := make(map[string]map[string][]*Review)
+	require.NoError(t, unmarshalReviews(legacy, &reviews))
+	require.Len(t, reviews["user"]["com.example"], 1)
+	assert.Equal(t, "old", reviews["user"]["com.example"][0].ID)
+	assert.Equal(t, "Old", reviews["user"]["com.example"][0].Text)
+	assert.Equal(t, int64(2), reviews["user"]["com.example"][0].Rating)
+
+	current, err := json.Marshal(map[string]map[string][]*Review{
+		"user": {"com.example": {{ID: "new", Text: "New", Rating: 5, LastModified: time.Unix(200, 0)}}},
+	})
+	require.NoError(t, err)
+	require.NoError(t, unmarshalReviews(current, &reviews))
+	require.Len(t, reviews["user"]["com.example"], 1)
+	assert.Equal(t, "new", reviews["user"]["com.example"][0].ID)
+	assert.Equal(t, int64(200), reviews["user"]["com.example"][0].LastModified.Unix())
+}
@@ -3,169 +3,149 @@ package main
 import (
 	"fmt"
 	"math/rand"
-	"strings"
 	"time"
-
-	"google.golang.org/api/androidpublisher/v3"
 )
 
-type reviewsGetResponse = struct {
-	packageName string
-	userID      string
-	list        []*androidpublisher.Review
+var mockReview = Review{
+	ID:               "ReviewId",
+	Author:           "AuthorName",
+	Rating:           5,
+	Text:             "Text",
+	OriginalText:     "OriginalText",
+	Language:         "ES",
+	AppVersionCode:   1,
+	AppVersionName:   "VersionName",
+	Device:           "Device",
+	DeviceMetadata:   nil,
+	AndroidOSVersion: 23,
+	LastModified:     time.Unix(1, 1000000),
 }
 
-var mockReview = androidpublisher.Review{
-	AuthorName: "AuthorName",
-	Comments: []*androidpublisher.Comment{
-		&androidpublisher.Comment{
-			UserComment: &androidpublisher.UserComment{
-				AndroidOsVersion: 23,
-				AppVersionCode:   1,
-				AppVersionName:   "VersionName",
-				Device:           "Device",
-				DeviceMetadata:   nil,
-				LastModified: &androidpublisher.Timestamp{
-					Nanos:   1000000,
-					Seconds: 1,
-				},
-				OriginalText:     "OriginalText",
-				ReviewerLanguage: "ES",
-				StarRating:       5,
-				Text:             "Text",
-				ThumbsDownCount:  1,
-				ThumbsUpCount:    1,
-			},
-		},
-	},
-	ReviewId: "ReviewId",
+func newMockReview() Review {
+	rand.Seed(time.Now().UnixNano())
+	return Review{
+		ID:               fmt.Sprintf("%d", time.Now().Unix()),
+		Author:           "MockUser",
+		Rating:           rand.Int63n(6),
+		Text:             fmt.Sprintf("This is a mock review written at %v", time.Now()),
+		OriginalText:     "OriginalText",
+		Language:         "ES",
+		AppVersionCode:   1,
+		AppVersionName:   "VersionName",
+		Device:           "Device",
+		DeviceMetadata:   nil,
+		AndroidOSVersion: 23,
+		LastModified:     time.Now(),
+	}
 }
 
-func newMockReview() androidpublisher.Review {
-	rand.Seed(time.Now().UnixNano())
-	return androidpublisher.Review{
-		AuthorName: "MockUser",
-		Comments: []*androidpublisher.Comment{
-			&androidpublisher.Comment{
-				UserComment: &androidpublisher.UserComment{
-					AndroidOsVersion: 23,
-					AppVersionCode:   1,
-					AppVersionName:   "VersionName",
-					Device:           "Device",
-					DeviceMetadata:   nil,
-					LastModified: &androidpublisher.Timestamp{
-						Nanos:   0,
-						Seconds: time.Now().Unix(),
-					},
-					OriginalText:     "OriginalText",
-					ReviewerLanguage: "ES",
-					StarRating:       rand.Int63n(6),
-					Text:             fmt.Sprintf("This is a mock review written at %v", time.Now()),
-					ThumbsDownCount:  1,
-					ThumbsUpCount:    1,
-				},
-			},
-		},
-		ReviewId: fmt.Sprintf("%d", time.Now().Unix()),
+// syncPackage fetches the reviews of the package, merging them on the cache and updating the alerts
+func (p *Plugin) syncPackage(packageInfo PackageInfo) error {
+	list, err := p.getReviews(packageInfo.Name, packageInfo.UserID)
+	if err != nil || len(list) == 0 {
+		// mockReview := newMockReview()
+		// list = []*Review{
+		// 	&mockReview,
+		// }
+		return err
 	}
+
+	newReviews := p.storeReviews(packageInfo, list)
+	p.routeReviews(packageInfo, newReviews)
+	return nil
 }
 
-func (p *Plugin) getAllReviews() {
-	for {
-		listSyncChannel := make(chan reviewsGetResponse)
-		for _, packageInfo := range p.packageList {
-			go p.getReviews(packageInfo.Name, packageInfo.UserID, listSyncChannel)
-		}
-		shouldSave := false
-		for range p.packageList {
-			getResponse := <-listSyncChannel
-			if getResponse.list == nil || len(getResponse.list) == 0 {
-				// mockReview := newMockReview()
-				// getResponse.list = []*androidpublisher.Review{
-				// 	&mockReview,
-				// }
-				continue
-			}
+// storeReviews merges the fetched reviews on the cache and updates the alerts, returning the reviews not cached
+// before. The reviews edited by their authors are updates, so they are not routed again.
+func (p *Plugin) storeReviews(packageInfo PackageInfo, list []*Review) []*Review {
+	// The fetched reviews are not shared yet, so they can be tagged in place without holding the lock
+	dictionaries := p.getConfiguration().TopicDictionaries
+	classifier := newLexiconClassifier(dictionaries)
+	classifyReviews(classifier, list)
+	p.reclassifyCachedReviews(packageInfo, classifier, dictionaries)
 
-			p.control.reviewsMutex.Lock()
-			if _, ok := p.localReviews[getResponse.userID]; !ok {
-				p.localReviews[getResponse.userID] = make(map[string][]*androidpublisher.Review)
-			}
-			if _, ok := p.localReviews[getResponse.userID][getResponse.packageName]; !ok {
-				p.localReviews[getResponse.userID][getResponse.packageName] = []*androidpublisher.Review{}
-			}
-			count, local, updates, new := mergeReviewLists(p.localReviews[getResponse.userID][getResponse.packageName], getResponse.list, getResponse.packageName)
-			p.localReviews[getResponse.userID][getResponse.packageName] = local
-			p.updateAlerts(getResponse.packageName, getResponse.userID, updates, new)
-			p.control.reviewsMutex.Unlock()
+	p.control.reviewsMutex.Lock()
+	defer p.control.reviewsMutex.Unlock()
 
-			shouldSave = shouldSave || count > 0
-		}
+	if _, ok := p.localReviews[packageInfo.UserID]; !ok {
+		p.localReviews[packageInfo.UserID] = make(map[string][]*Review)
+	}
+	if _, ok := p.localReviews[packageInfo.UserID][packageInfo.Name]; !ok {
+		p.localReviews[packageInfo.UserID][packageInfo.Name] = []*Review{}
+	}
+	_, local, updates, new := mergeReviewLists(p.localReviews[packageInfo.UserID][packageInfo.Name], list, packageInfo.Name)
+	p.metrics.set(metricLastNewReviews, "package", packageInfo.Name, float64(len(new)))
+	p.metrics.add(metricNewReviews, "package", packageInfo.Name, float64(len(new)))
+	p.localReviews[packageInfo.UserID][packageInfo.Name] = local
+	p.updateAlerts(packageInfo.Name, packageInfo.UserID, updates, new)
+
+	if len(new) > 0 || len(updates) > 0 {
+		p.SaveReviews()
+	}
+	return excludeReviews(new, updates)
+}
 
-		if shouldSave {
-			p.control.reviewsMutex.Lock()
-			p.SaveReviews()
-			p.control.reviewsMutex.Unlock()
+// excludeReviews returns the reviews whose id is not on excluded
+func excludeReviews(reviews []*Review, excluded []*Review) []*Review {
+	ids := make(map[string]bool, len(excluded))
+	for _, review := range excluded {
+		ids[review.ID] = true
+	}
+	result := []*Review{}
+	for _, review := range reviews {
+		if !ids[review.ID] {
+			result = append(result, review)
 		}
-		config := p.getConfiguration()
-		time.Sleep(time.Duration(config.GetListTime) * time.Second)
 	}
+	return result
 }
 
-func (p *Plugin) getReviews(packageName string, userID string, listSyncChannel chan reviewsGetResponse) {
-	service := p.getService(userID)
-	list, err := service.List(packageName).Do()
+func (p *Plugin) getReviews(packageName string, userID string) ([]*Review, error) {
+	source, err := p.getPackageSource(userID, packageName)
 	if err != nil {
-		fmt.Print(err.Error())
-		listSyncChannel <- reviewsGetResponse{
-			packageName: packageName,
-			userID:      userID,
-			list:        nil,
-		}
-	} else {
-		listSyncChannel <- reviewsGetResponse{
-			packageName: packageName,
-			userID:      userID,
-			list:        list.Reviews,
-		}
+		p.logWarn("Skipping reviews of a user not connected to the store", "userID", userID, "package", packageName, "err", err.Error())
+		p.setSyncError(userID, packageName, err)
+		return nil, err
 	}
-}
 
-func formatReview(review *androidpublisher.Review) string {
-	stars := [...]string{
-		":new_moon::new_moon::new_moon::new_moon::new_moon:",
-		":star::new_moon::new_moon::new_moon::new_moon:",
-		":star::star::new_moon::new_moon::new_moon:",
-		":star::star::star::new_moon::new_moon:",
-		":star::star::star::star::new_moon:",
-		":star::star::star::star::star:",
+	packageInfo, _ := p.state.getPackage(userID, packageName)
+	start := time.Now()
+	reviews, err := source.ListReviews(packageName, packageInfo.Language)
+	p.metrics.set(metricSyncDuration, "package", packageName, time.Since(start).Seconds())
+	p.metrics.add(metricSyncTotal, "package", packageName, 1)
+	if err != nil {
+		p.logError("Error getting reviews", "userID", userID, "package", packageName, "status", getHTTPStatus(err), "err", err.Error())
+		p.recordSyncError(packageName, err)
+		p.setSyncError(userID, packageName, err)
+		return nil, err
 	}
 
-	lastModified := review.Comments[0].UserComment.LastModified
-	return fmt.Sprintf("#### **%s** commented (%s):\n>%s\n\non _%s_\nReviewId:**%s**\n",
-		review.AuthorName,
-		stars[review.Comments[0].UserComment.StarRating],
-		strings.Join(strings.Split(review.Comments[0].UserComment.Text, "\n"), "\n>"),
-		time.Unix(lastModified.Seconds, lastModified.Nanos),
-		review.ReviewId)
+	p.logDebug("Reviews fetched", "userID", userID, "package", packageName, "count", len(reviews))
+	p.metrics.set(metricLastReviewsFetched, "package", packageName, float64(len(reviews)))
+	p.metrics.add(metricReviewsFetched, "package", packageName, float64(len(reviews)))
+	p.metrics.set(metricLastNewReviews, "package", packageName, 0)
+	p.setSyncSuccess(userID, packageName)
+	return reviews, nil
 }
 
-func mergeReviewLists(localList []*androidpublisher.Review, remoteList []*androidpublisher.Review, packageName string) (count int, newLocalList []*androidpublisher.Review, updatedReviews []*androidpublisher.Review, newReviews []*androidpublisher.Review) {
+func mergeReviewLists(localList []*Review, remoteList []*Review, packageName string) (count int, newLocalList []*Review, updatedReviews []*Review, newReviews []*Review) {
 	// Remove already cached elements
 	if len(localList) != 0 {
 		for i := range remoteList {
-			if remoteList[i].Comments[0].UserComment.LastModified.Seconds <= localList[0].Comments[0].UserComment.LastModified.Seconds {
-				remoteList = remoteList[:i-1]
+			if !remoteList[i].LastModified.After(localList[0].LastModified) {
+				remoteList = remoteList[:i]
 				break
 			}
 		}
 	}
 
-	updatedReviews = []*androidpublisher.Review{}
+	updatedReviews = []*Review{}
 	// Remove duplicates
 	for _, listItem := range remoteList {
 		for i, cacheItem := range localList {
-			if listItem.ReviewId == cacheItem.ReviewId {
+			if listItem.ID == cacheItem.ID {
+				// The stores do not know the issue of the review, so it is kept from the cache
+				listItem.IssueURL = cacheItem.IssueURL
 				updatedReviews = append(updatedReviews, listItem)
 				localList = removeElement(localList, i)
 				break
@@ -173,7 +153,7 @@ func mergeReviewLists(localList []*androidpublisher.Review, remoteList []*androi
 		}
 	}
 
-	newReviews = append([]*androidpublisher.Review(nil), remoteList...)
+	newReviews = append([]*Review(nil), remoteList...)
 
 	// Join lists
 	localList = append(remoteList, localList...)
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+// fallbackRoute names the fallback channel on the route commands
+const fallbackRoute = "fallback"
+
+// LanguageRoutes sends the new reviews of an app to channels by the language of the reviewer
+type LanguageRoutes struct {
+	// Channels maps primary languages, like "es", to channel IDs
+	Channels map[string]string
+	// Fallback is the channel ID of the reviews in any other language, or in an unknown one. Empty drops them.
+	Fallback string
+}
+
+func copyLanguageRoutes(routes *LanguageRoutes) *LanguageRoutes {
+	result := &LanguageRoutes{
+		Channels: make(map[string]string),
+		Fallback: routes.Fallback,
+	}
+	for language, channelID := range routes.Channels {
+		result.Channels[language] = channelID
+	}
+	return result
+}
+
+// getChannelID returns the channel the review is routed to, or an empty string if it is dropped
+func (r *LanguageRoutes) getChannelID(review *Review) string {
+	if channelID, ok := r.Channels[getPrimaryLanguage(review.Language)]; ok && review.Language != "" {
+		return channelID
+	}
+	return r.Fallback
+}
+
+// routeReviews posts the new reviews on the channels of their languages. It must be called without holding reviewsMutex.
+func (p *Plugin) routeReviews(packageInfo PackageInfo, reviews []*Review) {
+	if len(reviews) == 0 {
+		return
+	}
+	routes, ok := p.state.getLanguageRoutes(packageInfo.UserID, packageInfo.Name)
+	if !ok {
+		return
+	}
+
+	byChannel := make(map[string][]*Review)
+	channelIDs := []string{}
+	for _, review := range reviews {
+		channelID := routes.getChannelID(review)
+		if channelID == "" {
+			continue
+		}
+		if _, ok := byChannel[channelID]; !ok {
+			channelIDs = append(channelIDs, channelID)
+		}
+		byChannel[channelID] = append(byChannel[channelID], review)
+	}
+
+	config := p.getConfiguration()
+	for _, channelID := range channelIDs {
+		channelReviews := byChannel[channelID]
+		showing := min(len(channelReviews), config.MaxReviewsServed)
+
+		text := fmt.Sprintf("## New reviews of **%s**:\n", packageInfo.Name)
+		if len(channelReviews) > showing {
+			text += fmt.Sprintf("and **%d** more not shown.", len(channelReviews)-showing)
+		}
+
+		post := &model.Post{
+			UserId:    p.botUserID,
+			ChannelId: channelID,
+			Message:   text,
+		}
+		model.ParseSlackAttachment(post, p.getReviewCards(packageInfo.UserID, packageInfo.Name, channelReviews[:showing]))
+		if _, appErr := p.API.CreatePost(post); appErr != nil {
+			p.logError("Error posting routed reviews", "userID", packageInfo.UserID, "package", packageInfo.Name, "channelID", channelID, "err", appErr.Error())
+			continue
+		}
+		p.logDebug("Reviews routed", "userID", packageInfo.UserID, "package", packageInfo.Name, "channelID", channelID, "count", len(channelReviews))
+	}
+}
+
+// getRouteChannel finds the channel by name on the team of the current channel, and lets the bot post on it
+func (p *Plugin) getRouteChannel(channelName string, currentChannelID string, userID string) (*model.Channel, error) {
+	current, appErr := p.API.GetChannel(currentChannelID)
+	if appErr != nil {
+		return nil, appErr
+	}
+	channel, appErr := p.API.GetChannelByName(current.TeamId, strings.TrimPrefix(channelName, "~"), false)
+	if appErr != nil {
+		return nil, errors.New("channel not found")
+	}
+	if _, appErr := p.API.GetChannelMember(channel.Id, userID); appErr != nil {
+		return nil, errors.New("you are not a member of the channel")
+	}
+	if _, appErr := p.API.GetChannelMember(channel.Id, p.botUserID); appErr != nil {
+		if _, appErr := p.API.AddChannelMember(channel.Id, p.botUserID); appErr != nil {
+			return nil, errors.New("the bot cannot join the channel")
+		}
+	}
+	return channel, nil
+}
+
+func (p *Plugin) getChannelDisplayName(channelID string) string {
+	channel, appErr := p.API.GetChannel(channelID)
+	if appErr != nil {
+		return channelID
+	}
+	return "~" + channel.Name
+}
+
+func (p *Plugin) addLanguageRoute(args []string, userID string, channelID string) (*model.CommandResponse, *model.AppError) {
+	var message string
+
+	if len(args) < 6 {
+		message += fmt.Sprintf(":x:Wrong use: `%s %s %s packageName_or_alias ~channel language [language ...]`", args[0], args[1], args[2])
+		return commandErrorResponse(message)
+	}
+
+	packageName, ok := p.state.resolvePackageName(args[3], userID)
+	if !ok {
+		message += fmt.Sprintf(":x:Package **%s** is not yet registered.", args[3])
+		return commandErrorResponse(message)
+	}
+
+	languages := []string{}
+	for _, language := range args[5:] {
+		if !isValidLanguage(language) {
+			message += fmt.Sprintf(":x:**%s** is not a valid language. Please use a language code like `es` or `pt`.", language)
+			return commandErrorResponse(message)
+		}
+		languages = append(languages, getPrimaryLanguage(language))
+	}
+
+	channel, err := p.getRouteChannel(args[4], channelID, userID)
+	if err != nil {
+		message += fmt.Sprintf(":x:Cannot route reviews to **%s**: %s.", args[4], err.Error())
+		return commandErrorResponse(message)
+	}
+
+	p.updateSharedState(p.LoadLanguageRoutes, p.SaveLanguageRoutes, func() {
+		p.state.editLanguageRoutes(userID, packageName, func(routes *LanguageRoutes) {
+			for _, language := range languages {
+				routes.Channels[language] = channel.Id
+			}
+		})
+	})
+
+	message += fmt.Sprintf(":white_check_mark:New reviews of **%s** in **%s** will be posted on **~%s**.", packageName, strings.Join(languages, "**, **"), channel.Name)
+	return commandStatusResponse(message)
+}
+
+func (p *Plugin) setFallbackRoute(args []string, userID string, channelID string) (*model.CommandResponse, *model.AppError) {
+	var message string
+
+	if len(args) != 5 {
+		message += fmt.Sprintf(":x:Wrong use: `%s %s %s packageName_or_alias ~channel`. Use `none` as channel to drop the reviews of other languages.", args[0], args[1], args[2])
+		return commandErrorResponse(message)
+	}
+
+	packageName, ok := p.state.resolvePackageName(args[3], userID)
+	if !ok {
+		message += fmt.Sprintf(":x:Package **%s** is not yet registered.", args[3])
+		return commandErrorResponse(message)
+	}
+
+	if args[4] == "none" {
+		p.updateSharedState(p.LoadLanguageRoutes, p.SaveLanguageRoutes, func() {
+			p.state.editLanguageRoutes(userID, packageName, func(routes *LanguageRoutes) {
+				routes.Fallback = ""
+			})
+		})
+		message += fmt.Sprintf(":white_check_mark:New reviews of **%s** in other languages will not be posted.", packageName)
+		return commandStatusResponse(message)
+	}
+
+	channel, err := p.getRouteChannel(args[4], channelID, userID)
+	if err != nil {
+		message += fmt.Sprintf(":x:Cannot route reviews to **%s**: %s.", args[4], err.Error())
+		return commandErrorResponse(message)
+	}
+
+	p.updateSharedState(p.LoadLanguageRoutes, p.SaveLanguageRoutes, func() {
+		p.state.editLanguageRoutes(userID, packageName, func(routes *LanguageRoutes) {
+			routes.Fallback = channel.Id
+		})
+	})
+
+	message += fmt.Sprintf(":white_check_mark:New reviews of **%s** in other languages will be posted on **~%s**.", packageName, channel.Name)
+	return commandStatusResponse(message)
+}
+
+func (p *Plugin) removeLanguageRoute(args []string, userID string) (*model.CommandResponse, *model.AppError) {
+	var message string
+
+	if len(args) != 5 {
+		message += fmt.Sprintf(":x:Wrong use: `%s %s %s packageName_or_alias language`. Use `%s` as language to remove the fallback channel.", args[0], args[1], args[2], fallbackRoute)
+		return commandErrorResponse(message)
+	}
+
+	packageName, ok := p.state.resolvePackageName(args[3], userID)
+	if !ok {
+		message += fmt.Sprintf(":x:Package **%s** is not yet registered.", args[3])
+		return commandErrorResponse(message)
+	}
+
+	language := getPrimaryLanguage(args[4])
+	found := false
+	p.updateSharedState(p.LoadLanguageRoutes, p.SaveLanguageRoutes, func() {
+		p.state.editLanguageRoutes(userID, packageName, func(routes *LanguageRoutes) {
+			if language == fallbackRoute {
+				found = routes.Fallback != ""
+				routes.Fallback = ""
+				return
+			}
+			_, found = routes.Channels[language]
+			delete(routes.Channels, language)
+		})
+	})
+	if !found {
+		message += fmt.Sprintf(":x:There is no route for **%s** on **%s**.", args[4], packageName)
+		return commandErrorResponse(message)
+	}
+
+	message += fmt.Sprintf(":white_check_mark:Route for **%s** removed from **%s**.", args[4], packageName)
+	return commandStatusResponse(message)
+}
+
+func (p *Plugin) serveListLanguageRoutes(args []string, userID string) (*model.CommandResponse, *model.AppError) {
+	var message string
+
+	message += "## Here are all the language routes you have registered:\n"
+	for packageName, routes := range p.state.getUserLanguageRoutes(userID) {
+		languages := []string{}
+		for language := range routes.Channels {
+			languages = append(languages, language)
+		}
+		sort.Strings(languages)
+
+		message += fmt.Sprintf("* **%s**:", packageName)
+		for _, language := range languages {
+			message += fmt.Sprintf(" **%s** to **%s**,", language, p.getChannelDisplayName(routes.Channels[language]))
+		}
+		if routes.Fallback != "" {
+			message += fmt.Sprintf(" others to **%s**\n", p.getChannelDisplayName(routes.Fallback))
+		} else {
+			message += " others not posted\n"
+		}
+	}
+	return commandStatusResponse(message)
+}
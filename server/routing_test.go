@@ -0,0 +1,91 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLanguageRoutesGetChannelID(t *testing.T) {
+	routes := &LanguageRoutes{
+		Channels: map[string]string{"es": "latam", "pt": "latam", "ja": "japan"},
+		Fallback: "support",
+	}
+
+	review := newTestReview("1", 1, "text")
+	for language, channelID := range map[string]string{"es_ES": "latam", "pt-BR": "latam", "JA": "japan", "de": "support", "": "support"} {
+		review.Language = language
+		assert.Equal(t, channelID, routes.getChannelID(review), language)
+	}
+
+	routes.Fallback = ""
+	review.Language = "de"
+	assert.Empty(t, routes.getChannelID(review))
+}
+
+func TestRouteReviews(t *testing.T) {
+	p := newTestPlugin()
+	api := p.API.(*plugintest.API)
+	config := &model.Config{}
+	config.SetDefaults()
+	api.On("GetConfig").Return(config)
+
+	packageInfo := PackageInfo{Name: "com.example", UserID: "user"}
+	p.state.addPackage(packageInfo)
+	p.state.editLanguageRoutes("user", "com.example", func(routes *LanguageRoutes) {
+		routes.Channels["es"] = "latam"
+	})
+
+	posts := map[string]string{}
+	api.On("CreatePost", mock.AnythingOfType("*model.Post")).Return(func(post *model.Post) *model.Post {
+		posts[post.ChannelId] = post.Message
+		for _, attachment := range post.Attachments() {
+			posts[post.ChannelId] += attachment.Text
+		}
+		return post
+	}, nil)
+
+	spanish := newTestReview("spanish", 1, "Hola")
+	spanish.Language = "es"
+	german := newTestReview("german", 2, "Hallo")
+	german.Language = "de"
+	p.routeReviews(packageInfo, []*Review{spanish, german})
+
+	assert.Len(t, posts, 1)
+	assert.True(t, strings.Contains(posts["latam"], "Hola"))
+	assert.False(t, strings.Contains(posts["latam"], "Hallo"))
+
+	p.state.editLanguageRoutes("user", "com.example", func(routes *LanguageRoutes) {
+		routes.Fallback = "support"
+	})
+	p.routeReviews(packageInfo, []*Review{german})
+	assert.True(t, strings.Contains(posts["support"], "Hallo"))
+
+	p.state.editLanguageRoutes("user", "com.example", func(routes *LanguageRoutes) {
+		delete(routes.Channels, "es")
+		routes.Fallback = ""
+	})
+	_, ok := p.state.getLanguageRoutes("user", "com.example")
+	assert.False(t, ok)
+}
+
+func TestStoreReviewsReturnsOnlyUncached(t *testing.T) {
+	p := newTestPlugin()
+	packageInfo := PackageInfo{Name: "com.example", UserID: "user"}
+	p.state.addPackage(packageInfo)
+
+	added := p.storeReviews(packageInfo, []*Review{newTestReview("review", 1, "Hola")})
+	require.Len(t, added, 1)
+	assert.Equal(t, "review", added[0].ID)
+
+	// Editing the review makes it an update, so it is not routed again as a new review
+	added = p.storeReviews(packageInfo, []*Review{newTestReview("other", 3, "Hallo"), newTestReview("review", 2, "Hola, editado")})
+	require.Len(t, added, 1)
+	assert.Equal(t, "other", added[0].ID)
+	assert.Len(t, p.localReviews["user"]["com.example"], 2)
+}
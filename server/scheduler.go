@@ -0,0 +1,217 @@
+package main
+
+import (
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// schedulerTick is how often the scheduler looks for apps due to sync
+	schedulerTick = 5 * time.Second
+	// maxSyncBackoff caps the exponential backoff applied to apps failing with 429 or 5xx
+	maxSyncBackoff = 6 * time.Hour
+	// syncJitterRatio is the maximum fraction of the polling interval randomly added to each sync
+	syncJitterRatio = 0.1
+)
+
+// syncScheduler decides when each app syncs. It is safe to use concurrently.
+type syncScheduler struct {
+	lock     sync.Mutex
+	nextRun  map[string]time.Time
+	failures map[string]uint
+	running  map[string]bool
+	wakeUp   chan struct{}
+	limiter  *tokenBucket
+}
+
+func newSyncScheduler() *syncScheduler {
+	return &syncScheduler{
+		nextRun:  make(map[string]time.Time),
+		failures: make(map[string]uint),
+		running:  make(map[string]bool),
+		wakeUp:   make(chan struct{}, 1),
+		limiter:  newTokenBucket(),
+	}
+}
+
+func getPackageKey(userID string, packageName string) string {
+	return userID + "/" + packageName
+}
+
+// takeIfDue marks the app as running if its sync is due and it is not already running
+func (s *syncScheduler) takeIfDue(key string, now time.Time) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.running[key] || now.Before(s.nextRun[key]) {
+		return false
+	}
+	s.running[key] = true
+	return true
+}
+
+// done schedules the next sync of the app, backing off exponentially while the API keeps returning 429 or 5xx
+func (s *syncScheduler) done(key string, interval time.Duration, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.running[key] = false
+	delay := interval
+	if isRetryableSyncError(err) {
+		s.failures[key]++
+		delay = getSyncBackoff(interval, s.failures[key])
+	} else {
+		delete(s.failures, key)
+	}
+
+	jitter := time.Duration(rand.Int63n(int64(float64(interval)*syncJitterRatio) + 1))
+	s.nextRun[key] = time.Now().Add(delay + jitter)
+}
+
+// trigger makes the apps due now and wakes up the scheduler
+func (s *syncScheduler) trigger(keys []string) {
+	s.lock.Lock()
+	for _, key := range keys {
+		s.nextRun[key] = time.Time{}
+	}
+	s.lock.Unlock()
+
+	select {
+	case s.wakeUp <- struct{}{}:
+	default:
+	}
+}
+
+func (s *syncScheduler) getNextRun(key string) time.Time {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.nextRun[key]
+}
+
+func getSyncBackoff(interval time.Duration, failures uint) time.Duration {
+	if failures > 16 {
+		return maxSyncBackoff
+	}
+	backoff := interval << failures
+	if backoff > maxSyncBackoff || backoff <= 0 {
+		return maxSyncBackoff
+	}
+	return backoff
+}
+
+func isRetryableSyncError(err error) bool {
+	status := getHTTPStatus(err)
+	return status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
+}
+
+// tokenBucket limits the calls to the Google Play API, shared across all the users
+type tokenBucket struct {
+	lock       sync.Mutex
+	tokens     float64
+	capacity   float64
+	perSecond  float64
+	lastRefill time.Time
+}
+
+func newTokenBucket() *tokenBucket {
+	return &tokenBucket{
+		lastRefill: time.Now(),
+	}
+}
+
+// setRate updates the requests allowed per minute, which is also the burst size
+func (b *tokenBucket) setRate(perMinute int) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.refill(time.Now())
+	b.capacity = float64(perMinute)
+	b.perSecond = float64(perMinute) / 60
+	if b.tokens > b.capacity {
+		b.tokens = b.capacity
+	}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens += now.Sub(b.lastRefill).Seconds() * b.perSecond
+	if b.tokens > b.capacity {
+		b.tokens = b.capacity
+	}
+	b.lastRefill = now
+}
+
+// wait blocks until a token is available and takes it
+func (b *tokenBucket) wait() {
+	for {
+		b.lock.Lock()
+		b.refill(time.Now())
+		if b.tokens >= 1 {
+			b.tokens--
+			b.lock.Unlock()
+			return
+		}
+		wait := time.Second
+		if b.perSecond > 0 {
+			wait = time.Duration((1 - b.tokens) / b.perSecond * float64(time.Second))
+		}
+		b.lock.Unlock()
+		time.Sleep(wait)
+	}
+}
+
+func (p *Plugin) getPollInterval(packageInfo PackageInfo) time.Duration {
+	if packageInfo.PollInterval > 0 {
+		return time.Duration(packageInfo.PollInterval) * time.Second
+	}
+	return time.Duration(p.getConfiguration().GetListTime) * time.Second
+}
+
+// getAllReviews runs the scheduler, sending the apps due to sync to a bounded pool of workers.
+// Only the leader node of the cluster syncs. It reloads the apps and alerts on every tick, as the commands
+// may have changed them on other nodes.
+func (p *Plugin) getAllReviews() {
+	jobs := make(chan PackageInfo)
+	for i := 0; i < p.getConfiguration().MaxConcurrentSyncs; i++ {
+		go p.syncWorker(jobs)
+	}
+
+	for {
+		config := p.getConfiguration()
+		p.scheduler.limiter.setRate(config.APIRequestsPerMinute)
+
+		if p.isLeader() {
+			p.loadSharedState()
+			if keys := p.takeRefreshRequests(); len(keys) > 0 {
+				p.scheduler.trigger(keys)
+			}
+		}
+
+		now := time.Now()
+		for _, packageInfo := range p.state.getPackages() {
+			if !p.isLeader() {
+				break
+			}
+			if p.scheduler.takeIfDue(getPackageKey(packageInfo.UserID, packageInfo.Name), now) {
+				jobs <- packageInfo
+			}
+		}
+
+		select {
+		case <-time.After(schedulerTick):
+		case <-p.scheduler.wakeUp:
+		}
+	}
+}
+
+func (p *Plugin) syncWorker(jobs chan PackageInfo) {
+	for packageInfo := range jobs {
+		p.scheduler.limiter.wait()
+		err := p.syncPackage(packageInfo)
+		key := getPackageKey(packageInfo.UserID, packageInfo.Name)
+		p.scheduler.done(key, p.getPollInterval(packageInfo), err)
+		p.setSyncNextRun(packageInfo.UserID, packageInfo.Name, p.scheduler.getNextRun(key))
+		p.SaveSyncStatus()
+	}
+}
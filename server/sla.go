@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+const (
+	slaPending = iota
+	slaWarning
+	slaBreached
+	slaRepliedInTime
+	slaRepliedLate
+)
+
+// SLARule declares how much time developers have to reply to the reviews of a package inside a star rating band
+type SLARule = struct {
+	Webhook      string
+	PackageName  string
+	MinStars     int64
+	MaxStars     int64
+	Hours        int64
+	WarningHours int64
+	// Escalations stores the last escalation level alerted for each review id
+	Escalations map[string]int
+}
+
+func (p *Plugin) alertSLAs() {
+	for userID, rules := range p.state.getAllSLAs() {
+		for name, rule := range rules {
+			p.sendSLAAlert(name, rule, userID)
+		}
+	}
+}
+
+func (p *Plugin) sendSLAAlert(name string, rule *SLARule, userID string) {
+	attachments, warnings, breaches, shouldSave := p.getSLAEscalations(name, rule, userID, time.Now())
+	if len(attachments) == 0 {
+		if shouldSave {
+			p.saveSLAEscalations(userID, name, rule.Escalations)
+		}
+		return
+	}
+
+	// The webhook is called without holding reviewsMutex, as it retries with backoff
+	if err := p.sendWebhookPost("sla", rule.Webhook, "", attachments); err != nil {
+		p.logError("Error sending SLA escalation", "userID", userID, "package", rule.PackageName, "sla", name, "err", err.Error())
+		p.recordAlertDelivery("sla", err)
+		if shouldSave {
+			p.saveSLAEscalations(userID, name, rule.Escalations)
+		}
+		return
+	}
+
+	p.recordAlertDelivery("sla", nil)
+
+	for _, review := range warnings {
+		rule.Escalations[review.ID] = slaWarning
+	}
+	for _, review := range breaches {
+		rule.Escalations[review.ID] = slaBreached
+	}
+	p.saveSLAEscalations(userID, name, rule.Escalations)
+}
+
+// saveSLAEscalations stores the escalations of the rule, keeping the changes made to the rules on other nodes
+func (p *Plugin) saveSLAEscalations(userID string, name string, escalations map[string]int) {
+	p.updateSharedState(p.LoadSLAs, p.SaveSLAs, func() {
+		p.state.setSLAEscalations(userID, name, escalations)
+	})
+}
+
+// getSLAEscalations renders the cached reviews of the rule to escalate, and forgets the escalations of the reviews
+// no longer pending, telling if the rule changed
+func (p *Plugin) getSLAEscalations(name string, rule *SLARule, userID string, now time.Time) (attachments []*model.SlackAttachment, warnings []*Review, breaches []*Review, shouldSave bool) {
+	p.control.reviewsMutex.RLock()
+	defer p.control.reviewsMutex.RUnlock()
+
+	pending := make(map[string]bool)
+	for _, review := range p.localReviews[userID][rule.PackageName] {
+		if !inStarRange(review, rule.MinStars, rule.MaxStars) {
+			continue
+		}
+		status, _ := getSLAStatus(rule, review, now)
+		if status == slaPending || status == slaWarning || status == slaBreached {
+			pending[review.ID] = true
+		}
+		if (status == slaWarning || status == slaBreached) && rule.Escalations[review.ID] < status {
+			if status == slaWarning {
+				warnings = append(warnings, review)
+			} else {
+				breaches = append(breaches, review)
+			}
+		}
+	}
+
+	// Forget escalations from reviews already replied or no longer cached
+	for reviewID := range rule.Escalations {
+		if !pending[reviewID] {
+			delete(rule.Escalations, reviewID)
+			shouldSave = true
+		}
+	}
+
+	config := p.getConfiguration()
+	if len(breaches) > 0 {
+		header := fmt.Sprintf("## Reviews that breached the SLA **%s** (reply within %d hours):", name, rule.Hours)
+		attachments = append(attachments, p.getSLACards(userID, rule, header, breaches, now, config.MaxReviewsServed)...)
+	}
+	if len(warnings) > 0 {
+		header := fmt.Sprintf("## Reviews about to breach the SLA **%s** (reply within %d hours):", name, rule.Hours)
+		attachments = append(attachments, p.getSLACards(userID, rule, header, warnings, now, config.MaxReviewsServed)...)
+	}
+	return attachments, warnings, breaches, shouldSave
+}
+
+// getSLACards renders the reviews with the time left to reply to each one, starting with the header
+func (p *Plugin) getSLACards(userID string, rule *SLARule, header string, reviews []*Review, now time.Time, maxReviews int) []*model.SlackAttachment {
+	showing := min(len(reviews), maxReviews)
+	if len(reviews) > showing {
+		header += fmt.Sprintf(" **%d** more not shown.", len(reviews)-showing)
+	}
+	cards := p.getReviewCards(userID, rule.PackageName, reviews[:showing])
+	for i, review := range reviews[:showing] {
+		_, elapsed := getSLAStatus(rule, review, now)
+		left := time.Duration(rule.Hours)*time.Hour - elapsed
+		if left > 0 {
+			cards[i].Pretext = fmt.Sprintf("Time left to reply: **%v**", left.Round(time.Minute))
+		} else {
+			cards[i].Pretext = fmt.Sprintf("Overdue by: **%v**", (-left).Round(time.Minute))
+		}
+	}
+	cards[0].Pretext = header + "\n" + cards[0].Pretext
+	return cards
+}
+
+// getSLAStatus returns how a review stands against the rule, and the time it has been (or was) waiting for a reply
+func getSLAStatus(rule *SLARule, review *Review, now time.Time) (status int, elapsed time.Duration) {
+	deadline := time.Duration(rule.Hours) * time.Hour
+	warning := time.Duration(rule.Hours-rule.WarningHours) * time.Hour
+	commentTime := review.LastModified
+
+	if reply := review.Reply; reply != nil {
+		replyTime := reply.LastModified
+		if !replyTime.Before(commentTime) {
+			elapsed = replyTime.Sub(commentTime)
+			if elapsed > deadline {
+				return slaRepliedLate, elapsed
+			}
+			return slaRepliedInTime, elapsed
+		}
+	}
+
+	elapsed = now.Sub(commentTime)
+	switch {
+	case elapsed > deadline:
+		return slaBreached, elapsed
+	case elapsed > warning:
+		return slaWarning, elapsed
+	default:
+		return slaPending, elapsed
+	}
+}
+
+// getSLAReport builds the compliance summary of a rule over the cached reviews
+func getSLAReport(name string, rule *SLARule, reviews []*Review, now time.Time) string {
+	counts := make(map[int]int)
+	var totalReplyTime time.Duration
+	replied := 0
+	for _, review := range reviews {
+		if !inStarRange(review, rule.MinStars, rule.MaxStars) {
+			continue
+		}
+		status, elapsed := getSLAStatus(rule, review, now)
+		counts[status]++
+		if status == slaRepliedInTime || status == slaRepliedLate {
+			replied++
+			totalReplyTime += elapsed
+		}
+	}
+
+	text := fmt.Sprintf("#### SLA **%s**: package **%s**, %s reviews replied within **%d hours**\n", name, rule.PackageName, formatStarRange(rule.MinStars, rule.MaxStars), rule.Hours)
+	if replied > 0 {
+		text += fmt.Sprintf("* Replied in time: **%d** of **%d** (%d%%)\n", counts[slaRepliedInTime], replied, counts[slaRepliedInTime]*100/replied)
+		text += fmt.Sprintf("* Average reply time: **%v**\n", (totalReplyTime / time.Duration(replied)).Round(time.Minute))
+	} else {
+		text += "* No replied reviews yet\n"
+	}
+	text += fmt.Sprintf("* Replied late: **%d**\n", counts[slaRepliedLate])
+	text += fmt.Sprintf("* Waiting for reply: **%d** (**%d** about to breach)\n", counts[slaPending]+counts[slaWarning], counts[slaWarning])
+	text += fmt.Sprintf("* Overdue without reply: **%d**\n", counts[slaBreached])
+	return text
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSendSLAAlert(t *testing.T) {
+	p := newTestPlugin()
+	config := &model.Config{}
+	config.SetDefaults()
+	p.API.(*plugintest.API).On("GetConfig").Return(config)
+
+	var sent int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The reviews can be synced while the escalation is being delivered
+		p.control.reviewsMutex.Lock()
+		p.control.reviewsMutex.Unlock()
+		atomic.AddInt32(&sent, 1)
+	}))
+	defer server.Close()
+
+	packageInfo := PackageInfo{Name: "com.example", UserID: "user"}
+	p.state.addPackage(packageInfo)
+	p.storeReviews(packageInfo, []*Review{newTestReview("review", time.Now().Add(-2*time.Hour).Unix(), "It crashes")})
+	p.state.addSLA("user", "sla", &SLARule{Webhook: server.URL, PackageName: "com.example", MinStars: 1, MaxStars: 2, Hours: 1})
+
+	done := make(chan struct{})
+	go func() {
+		p.alertSLAs()
+		p.alertSLAs()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the SLA escalation is sent holding reviewsMutex")
+	}
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&sent), "each review is escalated once per level")
+	assert.Equal(t, slaBreached, p.state.getSLAs("user")["sla"].Escalations["review"])
+}
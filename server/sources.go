@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"google.golang.org/api/androidpublisher/v3"
+)
+
+const (
+	storeGooglePlay = "googleplay"
+	storeAppStore   = "appstore"
+)
+
+// ReviewSource fetches and replies the reviews of the apps of a store
+type ReviewSource interface {
+	// ListReviews returns the latest reviews of the app, newest first. The reviews written in other
+	// languages are translated to the language, if not empty and the store can translate them.
+	ListReviews(appID string, language string) ([]*Review, error)
+	// GetReview returns one review of the app, translated like ListReviews
+	GetReview(appID string, reviewID string, language string) (*Review, error)
+	// Reply publishes the developer reply to a review
+	Reply(appID string, reviewID string, text string) (*Reply, error)
+}
+
+func getStoreName(store string) string {
+	if store == "" {
+		return storeGooglePlay
+	}
+	return store
+}
+
+func getStoreTitle(store string) string {
+	if store == storeAppStore {
+		return "App Store"
+	}
+	return "Google Play"
+}
+
+// getStoreFromArgs takes the optional store keyword at the index out of the command arguments
+func getStoreFromArgs(args []string, index int) (string, []string) {
+	if len(args) > index && args[index] == storeAppStore {
+		rest := append([]string{}, args[:index]...)
+		return storeAppStore, append(rest, args[index+1:]...)
+	}
+	return "", args
+}
+
+// googlePlaySource fetches the reviews through the Google Play Developer API
+type googlePlaySource struct {
+	service *androidpublisher.ReviewsService
+}
+
+func (s *googlePlaySource) ListReviews(appID string, language string) ([]*Review, error) {
+	call := s.service.List(appID)
+	if language != "" {
+		call = call.TranslationLanguage(language)
+	}
+	list, err := call.Do()
+	if err != nil {
+		return nil, err
+	}
+
+	reviews := []*Review{}
+	for _, review := range list.Reviews {
+		if review != nil {
+			reviews = append(reviews, newGooglePlayReview(review))
+		}
+	}
+	return reviews, nil
+}
+
+func (s *googlePlaySource) GetReview(appID string, reviewID string, language string) (*Review, error) {
+	call := s.service.Get(appID, reviewID)
+	if language != "" {
+		call = call.TranslationLanguage(language)
+	}
+	review, err := call.Do()
+	if err != nil {
+		return nil, err
+	}
+	if review == nil {
+		return nil, errors.New("empty review")
+	}
+	return newGooglePlayReview(review), nil
+}
+
+func (s *googlePlaySource) Reply(appID string, reviewID string, text string) (*Reply, error) {
+	response, err := s.service.Reply(appID, reviewID, &androidpublisher.ReviewsReplyRequest{ReplyText: text}).Do()
+	if err != nil {
+		return nil, err
+	}
+	return newGooglePlayReply(response.Result), nil
+}
+
+// getSource gets the source of the store, authenticated with the connection of the user
+func (p *Plugin) getSource(userID string, store string, accountName string) (ReviewSource, error) {
+	switch getStoreName(store) {
+	case storeGooglePlay:
+		service := p.getService(userID, accountName)
+		if service == nil {
+			return nil, fmt.Errorf("Google Play account %s is not connected", getAccountName(accountName))
+		}
+		return &googlePlaySource{service: service}, nil
+	case storeAppStore:
+		return p.getAppStoreSource(userID, accountName)
+	default:
+		return nil, errors.New("unknown store " + store)
+	}
+}
+
+// getPackageSource gets the source of the store and the connection the app is bound to
+func (p *Plugin) getPackageSource(userID string, packageName string) (ReviewSource, error) {
+	packageInfo, _ := p.state.getPackage(userID, packageName)
+	return p.getSource(userID, packageInfo.Store, packageInfo.Account)
+}
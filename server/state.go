@@ -0,0 +1,690 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+)
+
+// stateStore owns the apps, aliases, alerts, SLAs, reply templates, approvals, known versions and language routes
+// registered by the users. It is safe to use concurrently.
+//
+// Ownership rules:
+// * The slices and maps are only changed through the store methods. Readers get copies they can keep.
+// * The alerts are shared pointers. Their exported fields are set before the alert is added and never change.
+// * The unexported fields of the alerts (pending reviews, counts and lastAlerted) are owned by reviewsMutex.
+type stateStore struct {
+	lock        sync.RWMutex
+	packageList []PackageInfo
+	aliases     map[string]map[string]string
+	alerts      AlertsContainer
+	routes      map[string]map[string]*LanguageRoutes
+	slaRules    map[string]map[string]*SLARule
+	templates   map[string]map[string]string
+	approvals   ApprovalsContainer
+	versions    map[string]map[string]map[int64]string
+}
+
+func newStateStore() *stateStore {
+	return &stateStore{
+		packageList: []PackageInfo{},
+		aliases:     make(map[string]map[string]string),
+		alerts:      newAlertsContainer(),
+		routes:      make(map[string]map[string]*LanguageRoutes),
+		slaRules:    make(map[string]map[string]*SLARule),
+		templates:   make(map[string]map[string]string),
+		approvals:   newApprovalsContainer(),
+		versions:    make(map[string]map[string]map[int64]string),
+	}
+}
+
+func newApprovalsContainer() ApprovalsContainer {
+	return ApprovalsContainer{
+		Channels: make(map[string]string),
+		Drafts:   make(map[string]*ReplyDraft),
+	}
+}
+
+func newAlertsContainer() AlertsContainer {
+	return AlertsContainer{
+		NewReviewsAlerts: make(map[string]map[string]*NewReviewsAlert),
+		NewUpdatesAlerts: make(map[string]map[string]*NewUpdatesAlert),
+		KeywordsAlerts:   make(map[string]map[string]*KeywordsAlert),
+		AnomalyAlerts:    make(map[string]map[string]*AnomalyAlert),
+		NewVersionAlerts: make(map[string]map[string]*NewVersionAlert),
+	}
+}
+
+// getPackages returns a copy of the apps of all the users
+func (s *stateStore) getPackages() []PackageInfo {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	list := make([]PackageInfo, len(s.packageList))
+	copy(list, s.packageList)
+	return list
+}
+
+// getUserPackages returns a copy of the apps of the user
+func (s *stateStore) getUserPackages(userID string) []PackageInfo {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	list := []PackageInfo{}
+	for _, packageInfo := range s.packageList {
+		if packageInfo.UserID == userID {
+			list = append(list, packageInfo)
+		}
+	}
+	return list
+}
+
+// getPackage returns a copy of the app of the user
+func (s *stateStore) getPackage(userID string, packageName string) (PackageInfo, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	for _, packageInfo := range s.packageList {
+		if packageInfo.Name == packageName && packageInfo.UserID == userID {
+			return packageInfo, true
+		}
+	}
+	return PackageInfo{}, false
+}
+
+func (s *stateStore) setPackages(list []PackageInfo) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.packageList = make([]PackageInfo, len(list))
+	copy(s.packageList, list)
+}
+
+func (s *stateStore) hasPackage(packageInfo PackageInfo) bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return contains(s.packageList, packageInfo)
+}
+
+// isRegisteredPackage checks if any user registered the app
+func (s *stateStore) isRegisteredPackage(packageName string) bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	for _, packageInfo := range s.packageList {
+		if packageInfo.Name == packageName {
+			return true
+		}
+	}
+	return false
+}
+
+// addPackage registers the app, returning false if it was already registered
+func (s *stateStore) addPackage(packageInfo PackageInfo) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if contains(s.packageList, packageInfo) {
+		return false
+	}
+	s.packageList = append(s.packageList, packageInfo)
+	return true
+}
+
+func (s *stateStore) setPollInterval(userID string, packageName string, interval int64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for i := range s.packageList {
+		if s.packageList[i].Name == packageName && s.packageList[i].UserID == userID {
+			s.packageList[i].PollInterval = interval
+		}
+	}
+}
+
+func (s *stateStore) setLanguage(userID string, packageName string, language string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for i := range s.packageList {
+		if s.packageList[i].Name == packageName && s.packageList[i].UserID == userID {
+			s.packageList[i].Language = language
+		}
+	}
+}
+
+// getAliases returns a copy of the aliases of the user
+func (s *stateStore) getAliases(userID string) map[string]string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	aliases := make(map[string]string)
+	for k, v := range s.aliases[userID] {
+		aliases[k] = v
+	}
+	return aliases
+}
+
+// getAllAliases returns a copy of the aliases of all the users
+func (s *stateStore) getAllAliases() map[string]map[string]string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	aliases := make(map[string]map[string]string)
+	for userID, userAliases := range s.aliases {
+		aliases[userID] = make(map[string]string)
+		for k, v := range userAliases {
+			aliases[userID][k] = v
+		}
+	}
+	return aliases
+}
+
+func (s *stateStore) setAliases(aliases map[string]map[string]string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.aliases = make(map[string]map[string]string)
+	for userID, userAliases := range aliases {
+		s.aliases[userID] = make(map[string]string)
+		for k, v := range userAliases {
+			s.aliases[userID][k] = v
+		}
+	}
+}
+
+// addAlias sets the alias, returning the app it already points to if it was taken
+func (s *stateStore) addAlias(userID string, aliasName string, packageName string) (string, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if existing, ok := s.aliases[userID][aliasName]; ok {
+		return existing, false
+	}
+	if _, ok := s.aliases[userID]; !ok {
+		s.aliases[userID] = make(map[string]string)
+	}
+	s.aliases[userID][aliasName] = packageName
+	return packageName, true
+}
+
+// resolvePackageName gets the app of the user by its name or its alias
+func (s *stateStore) resolvePackageName(packageNameOrAlias string, userID string) (string, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return getPackageNameFromArgs(packageNameOrAlias, userID, s.packageList, s.aliases[userID])
+}
+
+// getLanguageRoutes returns a copy of the language routes of the app
+func (s *stateStore) getLanguageRoutes(userID string, packageName string) (*LanguageRoutes, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	routes, ok := s.routes[userID][packageName]
+	if !ok {
+		return nil, false
+	}
+	return copyLanguageRoutes(routes), true
+}
+
+// getUserLanguageRoutes returns a copy of the language routes of the apps of the user
+func (s *stateStore) getUserLanguageRoutes(userID string) map[string]*LanguageRoutes {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	routes := make(map[string]*LanguageRoutes)
+	for packageName, packageRoutes := range s.routes[userID] {
+		routes[packageName] = copyLanguageRoutes(packageRoutes)
+	}
+	return routes
+}
+
+// getAllLanguageRoutes returns a copy of the language routes of all the users
+func (s *stateStore) getAllLanguageRoutes() map[string]map[string]*LanguageRoutes {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return copyAllLanguageRoutes(s.routes)
+}
+
+func (s *stateStore) setAllLanguageRoutes(routes map[string]map[string]*LanguageRoutes) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.routes = copyAllLanguageRoutes(routes)
+}
+
+// editLanguageRoutes runs f on the routes of the app holding the write lock, creating them if needed.
+// Routes left without channels are removed.
+func (s *stateStore) editLanguageRoutes(userID string, packageName string, f func(routes *LanguageRoutes)) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.routes[userID]; !ok {
+		s.routes[userID] = make(map[string]*LanguageRoutes)
+	}
+	routes, ok := s.routes[userID][packageName]
+	if !ok {
+		routes = &LanguageRoutes{Channels: make(map[string]string)}
+		s.routes[userID][packageName] = routes
+	}
+	f(routes)
+	if len(routes.Channels) == 0 && routes.Fallback == "" {
+		delete(s.routes[userID], packageName)
+	}
+}
+
+func copyAllLanguageRoutes(routes map[string]map[string]*LanguageRoutes) map[string]map[string]*LanguageRoutes {
+	result := make(map[string]map[string]*LanguageRoutes)
+	for userID, userRoutes := range routes {
+		result[userID] = make(map[string]*LanguageRoutes)
+		for packageName, packageRoutes := range userRoutes {
+			result[userID][packageName] = copyLanguageRoutes(packageRoutes)
+		}
+	}
+	return result
+}
+
+// getAlerts returns a copy of the alerts maps. The alerts are shared, see the ownership rules of the store.
+func (s *stateStore) getAlerts() AlertsContainer {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return copyAlertsContainer(s.alerts)
+}
+
+func (s *stateStore) setAlerts(alerts AlertsContainer) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.alerts = copyAlertsContainer(alerts)
+}
+
+// reloadAlerts replaces the alerts with the ones loaded from the persistency. The alerts whose exported fields did
+// not change are kept, so their pending reviews and the time they were last sent survive.
+func (s *stateStore) reloadAlerts(alerts AlertsContainer) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	loaded := copyAlertsContainer(alerts)
+	for userID, userAlerts := range loaded.NewReviewsAlerts {
+		for name, alert := range userAlerts {
+			if current, ok := s.alerts.NewReviewsAlerts[userID][name]; ok && isSameAlert(current, alert) {
+				userAlerts[name] = current
+			}
+		}
+	}
+	for userID, userAlerts := range loaded.NewUpdatesAlerts {
+		for name, alert := range userAlerts {
+			if current, ok := s.alerts.NewUpdatesAlerts[userID][name]; ok && isSameAlert(current, alert) {
+				userAlerts[name] = current
+			}
+		}
+	}
+	for userID, userAlerts := range loaded.KeywordsAlerts {
+		for name, alert := range userAlerts {
+			if current, ok := s.alerts.KeywordsAlerts[userID][name]; ok && isSameAlert(current, alert) {
+				userAlerts[name] = current
+			}
+		}
+	}
+	for userID, userAlerts := range loaded.AnomalyAlerts {
+		for name, alert := range userAlerts {
+			if current, ok := s.alerts.AnomalyAlerts[userID][name]; ok && isSameAlert(current, alert) {
+				userAlerts[name] = current
+			}
+		}
+	}
+	for userID, userAlerts := range loaded.NewVersionAlerts {
+		for name, alert := range userAlerts {
+			if current, ok := s.alerts.NewVersionAlerts[userID][name]; ok && isSameAlert(current, alert) {
+				userAlerts[name] = current
+			}
+		}
+	}
+	s.alerts = loaded
+}
+
+// isSameAlert compares the exported fields of the alerts, which never change once the alert is added
+func isSameAlert(a interface{}, b interface{}) bool {
+	if a == b {
+		return true
+	}
+	dataA, errA := json.Marshal(a)
+	dataB, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(dataA, dataB)
+}
+
+// editAlerts runs f holding the write lock, so it can check and change the alerts at once.
+// f must not keep references to the maps.
+func (s *stateStore) editAlerts(f func(alerts *AlertsContainer)) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	f(&s.alerts)
+}
+
+func copyAlertsContainer(alerts AlertsContainer) AlertsContainer {
+	result := newAlertsContainer()
+	for userID, userAlerts := range alerts.NewReviewsAlerts {
+		result.NewReviewsAlerts[userID] = make(map[string]*NewReviewsAlert)
+		for k, v := range userAlerts {
+			result.NewReviewsAlerts[userID][k] = v
+		}
+	}
+	for userID, userAlerts := range alerts.NewUpdatesAlerts {
+		result.NewUpdatesAlerts[userID] = make(map[string]*NewUpdatesAlert)
+		for k, v := range userAlerts {
+			result.NewUpdatesAlerts[userID][k] = v
+		}
+	}
+	for userID, userAlerts := range alerts.KeywordsAlerts {
+		result.KeywordsAlerts[userID] = make(map[string]*KeywordsAlert)
+		for k, v := range userAlerts {
+			result.KeywordsAlerts[userID][k] = v
+		}
+	}
+	for userID, userAlerts := range alerts.AnomalyAlerts {
+		result.AnomalyAlerts[userID] = make(map[string]*AnomalyAlert)
+		for k, v := range userAlerts {
+			result.AnomalyAlerts[userID][k] = v
+		}
+	}
+	for userID, userAlerts := range alerts.NewVersionAlerts {
+		result.NewVersionAlerts[userID] = make(map[string]*NewVersionAlert)
+		for k, v := range userAlerts {
+			result.NewVersionAlerts[userID][k] = v
+		}
+	}
+	return result
+}
+
+// getSLAs returns a copy of the SLA rules of the user
+func (s *stateStore) getSLAs(userID string) map[string]*SLARule {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	rules := make(map[string]*SLARule)
+	for name, rule := range s.slaRules[userID] {
+		rules[name] = copySLARule(rule)
+	}
+	return rules
+}
+
+// getAllSLAs returns a copy of the SLA rules of all the users
+func (s *stateStore) getAllSLAs() map[string]map[string]*SLARule {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return copyAllSLAs(s.slaRules)
+}
+
+func (s *stateStore) setAllSLAs(rules map[string]map[string]*SLARule) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.slaRules = copyAllSLAs(rules)
+}
+
+// addSLA registers the rule, returning false if there is already one with the same name
+func (s *stateStore) addSLA(userID string, name string, rule *SLARule) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.slaRules[userID][name]; ok {
+		return false
+	}
+	if _, ok := s.slaRules[userID]; !ok {
+		s.slaRules[userID] = make(map[string]*SLARule)
+	}
+	s.slaRules[userID][name] = copySLARule(rule)
+	return true
+}
+
+// removeSLA removes the rule, returning false if it did not exist
+func (s *stateStore) removeSLA(userID string, name string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.slaRules[userID][name]; !ok {
+		return false
+	}
+	delete(s.slaRules[userID], name)
+	return true
+}
+
+// setSLAEscalations replaces the escalations of the rule, if it still exists
+func (s *stateStore) setSLAEscalations(userID string, name string, escalations map[string]int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	rule, ok := s.slaRules[userID][name]
+	if !ok {
+		return
+	}
+	rule.Escalations = make(map[string]int)
+	for reviewID, level := range escalations {
+		rule.Escalations[reviewID] = level
+	}
+}
+
+func copySLARule(rule *SLARule) *SLARule {
+	result := *rule
+	result.Escalations = make(map[string]int)
+	for reviewID, level := range rule.Escalations {
+		result.Escalations[reviewID] = level
+	}
+	return &result
+}
+
+func copyAllSLAs(rules map[string]map[string]*SLARule) map[string]map[string]*SLARule {
+	result := make(map[string]map[string]*SLARule)
+	for userID, userRules := range rules {
+		result[userID] = make(map[string]*SLARule)
+		for name, rule := range userRules {
+			result[userID][name] = copySLARule(rule)
+		}
+	}
+	return result
+}
+
+// getTemplates returns a copy of the reply templates of the user
+func (s *stateStore) getTemplates(userID string) map[string]string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	templates := make(map[string]string)
+	for name, text := range s.templates[userID] {
+		templates[name] = text
+	}
+	return templates
+}
+
+func (s *stateStore) getTemplate(userID string, name string) (string, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	text, ok := s.templates[userID][name]
+	return text, ok
+}
+
+// getAllTemplates returns a copy of the reply templates of all the users
+func (s *stateStore) getAllTemplates() map[string]map[string]string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return copyAllTemplates(s.templates)
+}
+
+func (s *stateStore) setAllTemplates(templates map[string]map[string]string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.templates = copyAllTemplates(templates)
+}
+
+// addTemplate registers the template, returning false if there is already one with the same name
+func (s *stateStore) addTemplate(userID string, name string, text string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.templates[userID][name]; ok {
+		return false
+	}
+	if _, ok := s.templates[userID]; !ok {
+		s.templates[userID] = make(map[string]string)
+	}
+	s.templates[userID][name] = text
+	return true
+}
+
+// removeTemplate removes the template, returning false if it did not exist
+func (s *stateStore) removeTemplate(userID string, name string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.templates[userID][name]; !ok {
+		return false
+	}
+	delete(s.templates[userID], name)
+	return true
+}
+
+func copyAllTemplates(templates map[string]map[string]string) map[string]map[string]string {
+	result := make(map[string]map[string]string)
+	for userID, userTemplates := range templates {
+		result[userID] = make(map[string]string)
+		for name, text := range userTemplates {
+			result[userID][name] = text
+		}
+	}
+	return result
+}
+
+// getApprovals returns a copy of the approvers channels and the reply drafts
+func (s *stateStore) getApprovals() ApprovalsContainer {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return copyApprovalsContainer(s.approvals)
+}
+
+func (s *stateStore) setApprovals(approvals ApprovalsContainer) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.approvals = copyApprovalsContainer(approvals)
+}
+
+// getApprovalChannel returns the approvers channel of the app, if its replies need approval
+func (s *stateStore) getApprovalChannel(packageName string) (string, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	channelID, ok := s.approvals.Channels[packageName]
+	return channelID, ok
+}
+
+func (s *stateStore) setApprovalChannel(packageName string, channelID string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.approvals.Channels[packageName] = channelID
+}
+
+// removeApprovalChannel disables the approval mode of the app, returning false if it was not enabled
+func (s *stateStore) removeApprovalChannel(packageName string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.approvals.Channels[packageName]; !ok {
+		return false
+	}
+	delete(s.approvals.Channels, packageName)
+	return true
+}
+
+// getDraft returns a copy of the reply draft
+func (s *stateStore) getDraft(draftID string) (*ReplyDraft, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	draft, ok := s.approvals.Drafts[draftID]
+	if !ok {
+		return nil, false
+	}
+	result := *draft
+	return &result, true
+}
+
+// setDraft stores a copy of the reply draft, replacing the previous version
+func (s *stateStore) setDraft(draft *ReplyDraft) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	stored := *draft
+	s.approvals.Drafts[draft.ID] = &stored
+}
+
+// editDraft runs f on a copy of the draft holding the write lock, so it can check and change the draft at once.
+// The changes are only stored if f returns true. It returns a copy of the stored draft.
+func (s *stateStore) editDraft(draftID string, f func(draft *ReplyDraft) bool) (*ReplyDraft, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	draft, ok := s.approvals.Drafts[draftID]
+	if !ok {
+		return nil, false
+	}
+	edited := *draft
+	if !f(&edited) {
+		return nil, false
+	}
+	s.approvals.Drafts[draftID] = &edited
+	result := edited
+	return &result, true
+}
+
+func copyApprovalsContainer(approvals ApprovalsContainer) ApprovalsContainer {
+	result := newApprovalsContainer()
+	for packageName, channelID := range approvals.Channels {
+		result.Channels[packageName] = channelID
+	}
+	for draftID, draft := range approvals.Drafts {
+		stored := *draft
+		result.Drafts[draftID] = &stored
+	}
+	return result
+}
+
+// getAllVersions returns a copy of the known versions of the apps of all the users
+func (s *stateStore) getAllVersions() map[string]map[string]map[int64]string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return copyAllVersions(s.versions)
+}
+
+func (s *stateStore) setAllVersions(versions map[string]map[string]map[int64]string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.versions = copyAllVersions(versions)
+}
+
+// editVersions runs f on the known versions of the app holding the write lock. found tells if the app was
+// checked before; otherwise known starts empty and is kept after f returns.
+func (s *stateStore) editVersions(userID string, packageName string, f func(known map[int64]string, found bool)) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.versions[userID]; !ok {
+		s.versions[userID] = make(map[string]map[int64]string)
+	}
+	known, found := s.versions[userID][packageName]
+	if !found {
+		known = make(map[int64]string)
+		s.versions[userID][packageName] = known
+	}
+	f(known, found)
+}
+
+func copyAllVersions(versions map[string]map[string]map[int64]string) map[string]map[string]map[int64]string {
+	result := make(map[string]map[string]map[int64]string)
+	for userID, userVersions := range versions {
+		result[userID] = make(map[string]map[int64]string)
+		for packageName, known := range userVersions {
+			result[userID][packageName] = make(map[int64]string)
+			for code, name := range known {
+				result[userID][packageName][code] = name
+			}
+		}
+	}
+	return result
+}
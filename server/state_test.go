@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestPlugin() *Plugin {
+	p := &Plugin{}
+	p.API = &plugintest.API{}
+	p.configuration = &configuration{
+		GetListTime:          "60",
+		AlertWatcherTime:     "1",
+		MaxReviewsServed:     "5",
+		MaxConcurrentSyncs:   "1",
+		APIRequestsPerMinute: "60",
+		LogLevel:             logLevelError,
+	}
+	p.init()
+	p.persistency = &dummyPersistency{}
+	return p
+}
+
+func newTestReview(id string, seconds int64, text string) *Review {
+	return &Review{
+		ID:           id,
+		Author:       "Author",
+		Rating:       1,
+		Text:         text,
+		LastModified: time.Unix(seconds, 0),
+	}
+}
+
+func TestStateStoreCopyOnRead(t *testing.T) {
+	s := newStateStore()
+
+	assert.True(t, s.addPackage(PackageInfo{Name: "com.example", UserID: "user"}))
+	assert.False(t, s.addPackage(PackageInfo{Name: "com.example", UserID: "user"}))
+
+	packages := s.getPackages()
+	packages[0].Name = "changed"
+	assert.Equal(t, "com.example", s.getPackages()[0].Name)
+
+	_, ok := s.addAlias("user", "example", "com.example")
+	assert.True(t, ok)
+	existing, ok := s.addAlias("user", "example", "com.other")
+	assert.False(t, ok)
+	assert.Equal(t, "com.example", existing)
+
+	aliases := s.getAliases("user")
+	aliases["other"] = "com.other"
+	_, ok = s.resolvePackageName("other", "user")
+	assert.False(t, ok)
+	packageName, ok := s.resolvePackageName("example", "user")
+	assert.True(t, ok)
+	assert.Equal(t, "com.example", packageName)
+
+	s.editAlerts(func(alerts *AlertsContainer) {
+		alerts.NewReviewsAlerts["user"] = map[string]*NewReviewsAlert{"alert": {}}
+	})
+	alerts := s.getAlerts()
+	delete(alerts.NewReviewsAlerts["user"], "alert")
+	assert.Len(t, s.getAlerts().NewReviewsAlerts["user"], 1)
+
+	assert.True(t, s.addSLA("user", "sla", &SLARule{PackageName: "com.example"}))
+	assert.False(t, s.addSLA("user", "sla", &SLARule{PackageName: "com.other"}))
+	rules := s.getAllSLAs()
+	rules["user"]["sla"].Escalations["review"] = slaBreached
+	assert.Empty(t, s.getSLAs("user")["sla"].Escalations)
+	s.setSLAEscalations("user", "sla", rules["user"]["sla"].Escalations)
+	assert.Equal(t, slaBreached, s.getSLAs("user")["sla"].Escalations["review"])
+
+	s.setDraft(&ReplyDraft{ID: "draft", Status: draftPending})
+	draft, ok := s.getDraft("draft")
+	assert.True(t, ok)
+	draft.Status = draftApproved
+	draft, _ = s.getDraft("draft")
+	assert.Equal(t, draftPending, draft.Status)
+}
+
+func TestConcurrentCommandsAndSync(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	const userID = "user"
+	const iterations = 50
+	p := newTestPlugin()
+	config := &model.Config{}
+	config.SetDefaults()
+	p.API.(*plugintest.API).On("GetConfig").Return(config)
+	newTestKVStore(p.API.(*plugintest.API))
+	packageInfo := PackageInfo{Name: "com.example", UserID: userID}
+	p.state.addPackage(packageInfo)
+
+	run := func(command string, expected string) {
+		response, appErr := p.routeRoot(strings.Fields(command), userID, "channel", "")
+		if assert.Nil(t, appErr, command) {
+			assert.Contains(t, response.Text, expected, command)
+		}
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(4)
+
+	go func() {
+		defer wg.Done()
+		for i := 0; i < iterations; i++ {
+			run(fmt.Sprintf("/gpreviews add alert newReviews reviews%d %s com.example 1", i, server.URL), ":white_check_mark:")
+			run(fmt.Sprintf("/gpreviews add alert keywords keywords%d %s com.example 1 crash", i, server.URL), ":white_check_mark:")
+			run(fmt.Sprintf("/gpreviews add alias alias%d com.example", i), ":white_check_mark:")
+			run(fmt.Sprintf("/gpreviews set interval alias%d 60", i), ":white_check_mark:")
+			run(fmt.Sprintf("/gpreviews add sla sla%d %s alias%d 1-2 24", i, server.URL, i), ":white_check_mark:")
+			run(fmt.Sprintf("/gpreviews template add template%d Thanks {{author}}", i), ":white_check_mark:")
+			run("/gpreviews list alerts newReviews", fmt.Sprintf("**\"reviews%d\"**", i))
+			run("/gpreviews list apps", "com.example")
+			run("/gpreviews list sla", fmt.Sprintf("**\"sla%d\"**", i))
+			run("/gpreviews template list", fmt.Sprintf("**\"template%d\"**", i))
+			run(fmt.Sprintf("/gpreviews remove alert newReviews reviews%d", i), ":white_check_mark:")
+			run(fmt.Sprintf("/gpreviews template remove template%d", i), ":white_check_mark:")
+		}
+	}()
+
+	go func() {
+		defer wg.Done()
+		for i := 0; i < iterations; i++ {
+			p.storeReviews(packageInfo, []*Review{
+				newTestReview(fmt.Sprintf("review%d", i), int64(i+1), "It keeps crashing"),
+			})
+		}
+	}()
+
+	go func() {
+		defer wg.Done()
+		for i := 0; i < iterations; i++ {
+			alerts := p.state.getAlerts()
+			for name, alert := range alerts.NewReviewsAlerts[userID] {
+				p.sendReviewsAlert(name, alert, userID)
+			}
+			for name, alert := range alerts.KeywordsAlerts[userID] {
+				p.sendKeywordsAlert(name, alert, userID)
+			}
+			p.alertSLAs()
+		}
+	}()
+
+	go func() {
+		defer wg.Done()
+		for i := 0; i < iterations; i++ {
+			p.state.getPackages()
+			p.getSyncStatusReport(userID)
+			run("/gpreviews refresh com.example", ":arrows_counterclockwise:")
+			run("/gpreviews sla report", "SLA report")
+		}
+	}()
+
+	wg.Wait()
+
+	assert.Empty(t, p.state.getAlerts().NewReviewsAlerts[userID])
+	assert.Len(t, p.state.getAlerts().KeywordsAlerts[userID], iterations)
+	assert.Len(t, p.state.getAliases(userID), iterations)
+	assert.Len(t, p.state.getSLAs(userID), iterations)
+	assert.Empty(t, p.state.getTemplates(userID))
+	assert.Equal(t, int64(60), p.state.getPackages()[0].PollInterval)
+	assert.Len(t, p.localReviews[userID]["com.example"], iterations)
+	assert.Len(t, p.takeRefreshRequests(), 2*iterations, "the refresh and interval requests of a node other than the leader are kept for the leader")
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// packageSyncStatus stores the result of the latest syncs of a package. It is written by the leader node and read
+// by the others to show the status.
+type packageSyncStatus struct {
+	LastSuccess time.Time
+	LastError   string
+	LastErrorAt time.Time
+	NextRun     time.Time
+}
+
+func (p *Plugin) setSyncSuccess(userID string, packageName string) {
+	p.control.syncStatusMutex.Lock()
+	defer p.control.syncStatusMutex.Unlock()
+
+	status := p.getOrCreateSyncStatus(getPackageKey(userID, packageName))
+	status.LastSuccess = time.Now()
+}
+
+func (p *Plugin) setSyncError(userID string, packageName string, err error) {
+	p.control.syncStatusMutex.Lock()
+	defer p.control.syncStatusMutex.Unlock()
+
+	status := p.getOrCreateSyncStatus(getPackageKey(userID, packageName))
+	status.LastError = err.Error()
+	status.LastErrorAt = time.Now()
+}
+
+func (p *Plugin) setSyncNextRun(userID string, packageName string, nextRun time.Time) {
+	p.control.syncStatusMutex.Lock()
+	defer p.control.syncStatusMutex.Unlock()
+
+	status := p.getOrCreateSyncStatus(getPackageKey(userID, packageName))
+	status.NextRun = nextRun
+}
+
+func (p *Plugin) getOrCreateSyncStatus(key string) *packageSyncStatus {
+	status, ok := p.syncStatus[key]
+	if !ok {
+		status = &packageSyncStatus{}
+		p.syncStatus[key] = status
+	}
+	return status
+}
+
+func formatStatusTime(t time.Time, now time.Time) string {
+	if t.IsZero() {
+		return "Never"
+	}
+	if t.After(now) {
+		return fmt.Sprintf("%s (in %v)", t.Format(time.RFC1123), t.Sub(now).Round(time.Second))
+	}
+	return fmt.Sprintf("%s (%v ago)", t.Format(time.RFC1123), now.Sub(t).Round(time.Second))
+}
+
+// getSyncStatusReport builds a table with the sync status of the packages of a user
+func (p *Plugin) getSyncStatusReport(userID string) string {
+	now := time.Now()
+	text := "## Sync status of your apps:\n"
+	text += "| App | Last successful sync | Last error | Next sync | Cached reviews |\n|:--|:--|:--|:--|:--|\n"
+
+	p.control.syncStatusMutex.RLock()
+	defer p.control.syncStatusMutex.RUnlock()
+	p.control.reviewsMutex.RLock()
+	defer p.control.reviewsMutex.RUnlock()
+
+	for _, packageInfo := range p.state.getUserPackages(userID) {
+		key := getPackageKey(userID, packageInfo.Name)
+		status, ok := p.syncStatus[key]
+		if !ok {
+			status = &packageSyncStatus{}
+		}
+
+		// Only the leader schedules the syncs, the other nodes show the time it stored
+		nextRun := status.NextRun
+		if p.isLeader() {
+			nextRun = p.scheduler.getNextRun(key)
+		}
+
+		lastError := "None"
+		if status.LastError != "" {
+			lastError = fmt.Sprintf("%s: `%s`", formatStatusTime(status.LastErrorAt, now), strings.Replace(status.LastError, "|", "\\|", -1))
+		}
+
+		text += fmt.Sprintf("| %s | %s | %s | %s | %d |\n",
+			packageInfo.Name,
+			formatStatusTime(status.LastSuccess, now),
+			lastError,
+			formatStatusTime(nextRun, now),
+			len(p.localReviews[userID][packageInfo.Name]))
+	}
+	return text
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"strings"
+	"text/template"
+)
+
+// getReplyTemplateFuncs exposes the template placeholders as functions, so they can be written as {{author}}, {{app}}, {{version}} and {{stars}}
+func getReplyTemplateFuncs(author string, app string, version string, stars int64) template.FuncMap {
+	return template.FuncMap{
+		"author":  func() string { return author },
+		"app":     func() string { return app },
+		"version": func() string { return version },
+		"stars":   func() int64 { return stars },
+	}
+}
+
+// validateReplyTemplate checks that a template only uses known placeholders and renders without errors
+func validateReplyTemplate(name string, text string) error {
+	tmpl, err := template.New(name).Funcs(getReplyTemplateFuncs("", "", "", 0)).Parse(text)
+	if err != nil {
+		return err
+	}
+	return tmpl.Execute(&strings.Builder{}, nil)
+}
+
+func renderReplyTemplate(name string, text string, packageName string, review *Review) (string, error) {
+	funcs := getReplyTemplateFuncs(review.Author, packageName, review.AppVersionName, review.Rating)
+
+	tmpl, err := template.New(name).Funcs(funcs).Parse(text)
+	if err != nil {
+		return "", err
+	}
+
+	var rendered strings.Builder
+	if err := tmpl.Execute(&rendered, nil); err != nil {
+		return "", err
+	}
+	return rendered.String(), nil
+}
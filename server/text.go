@@ -0,0 +1,78 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// stopWords are the common words ignored when looking for terms on the reviews
+var stopWords = map[string]bool{
+	"a": true, "about": true, "after": true, "all": true, "also": true, "am": true, "an": true, "and": true,
+	"any": true, "app": true, "are": true, "as": true, "at": true, "be": true, "because": true, "been": true,
+	"but": true, "by": true, "can": true, "cant": true, "could": true, "did": true, "do": true, "does": true,
+	"dont": true, "even": true, "for": true, "from": true, "get": true, "got": true, "had": true, "has": true,
+	"have": true, "he": true, "her": true, "his": true, "how": true, "i": true, "if": true, "im": true,
+	"in": true, "into": true, "is": true, "it": true, "its": true, "just": true, "like": true, "me": true,
+	"more": true, "my": true, "no": true, "not": true, "now": true, "of": true, "on": true, "one": true,
+	"only": true, "or": true, "our": true, "out": true, "please": true, "so": true, "some": true, "than": true,
+	"that": true, "the": true, "their": true, "them": true, "then": true, "there": true, "they": true, "this": true,
+	"to": true, "too": true, "up": true, "us": true, "use": true, "very": true, "was": true, "we": true,
+	"were": true, "what": true, "when": true, "which": true, "while": true, "who": true, "why": true, "will": true,
+	"with": true, "would": true, "you": true, "your": true,
+}
+
+// splitWords splits a text into lowercase words, removing punctuation and numbers
+func splitWords(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && r != '\''
+	})
+	for i, word := range words {
+		words[i] = strings.Replace(word, "'", "", -1)
+	}
+	return words
+}
+
+// tokenize splits a text into lowercase words, removing punctuation, numbers and stop words
+func tokenize(text string) []string {
+	tokens := []string{}
+	for _, word := range splitWords(text) {
+		if len([]rune(word)) < 3 || stopWords[word] {
+			continue
+		}
+		tokens = append(tokens, word)
+	}
+	return tokens
+}
+
+// getTopTerms returns the most repeated terms on the texts, counting each term once per text
+func getTopTerms(texts []string, maxTerms int) []string {
+	counts := make(map[string]int)
+	for _, text := range texts {
+		seen := make(map[string]bool)
+		for _, token := range tokenize(text) {
+			if !seen[token] {
+				seen[token] = true
+				counts[token]++
+			}
+		}
+	}
+
+	terms := []string{}
+	for term, count := range counts {
+		if count > 1 {
+			terms = append(terms, term)
+		}
+	}
+	sort.Slice(terms, func(i, j int) bool {
+		if counts[terms[i]] != counts[terms[j]] {
+			return counts[terms[i]] > counts[terms[j]]
+		}
+		return terms[i] < terms[j]
+	})
+
+	if len(terms) > maxTerms {
+		terms = terms[:maxTerms]
+	}
+	return terms
+}
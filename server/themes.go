@@ -0,0 +1,281 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+const (
+	defaultThemesSinceDays = 30
+	// Reviews with a cosine similarity of at least themeSimilarity to the centroid of a theme join it
+	themeSimilarity = 0.25
+	maxThemes       = 5
+	maxThemeTerms   = 3
+)
+
+// theme is a cluster of reviews describing the same thing in different words
+type theme struct {
+	reviews  []*Review
+	vectors  []map[string]float64
+	centroid map[string]float64
+}
+
+// getTFIDFVectors weights the tokens of each text by their frequency on it and their rarity on all of them.
+// Vectors are normalized, so the cosine similarity of two of them is their dot product.
+func getTFIDFVectors(texts []string) []map[string]float64 {
+	documents := [][]string{}
+	documentFrequency := make(map[string]int)
+	for _, text := range texts {
+		tokens := tokenize(text)
+		documents = append(documents, tokens)
+		seen := make(map[string]bool)
+		for _, token := range tokens {
+			if !seen[token] {
+				seen[token] = true
+				documentFrequency[token]++
+			}
+		}
+	}
+
+	vectors := []map[string]float64{}
+	for _, tokens := range documents {
+		vector := make(map[string]float64)
+		for _, token := range tokens {
+			vector[token]++
+		}
+		for token, count := range vector {
+			idf := math.Log(1 + float64(len(documents))/float64(documentFrequency[token]))
+			vector[token] = count / float64(len(tokens)) * idf
+		}
+		vectors = append(vectors, normalizeVector(vector))
+	}
+	return vectors
+}
+
+func normalizeVector(vector map[string]float64) map[string]float64 {
+	var norm float64
+	for _, weight := range vector {
+		norm += weight * weight
+	}
+	norm = math.Sqrt(norm)
+	if norm == 0 {
+		return vector
+	}
+	for token, weight := range vector {
+		vector[token] = weight / norm
+	}
+	return vector
+}
+
+func cosineSimilarity(a map[string]float64, b map[string]float64) float64 {
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+	var dot float64
+	for token, weight := range a {
+		dot += weight * b[token]
+	}
+	return dot
+}
+
+func (t *theme) add(review *Review, vector map[string]float64) {
+	t.reviews = append(t.reviews, review)
+	t.vectors = append(t.vectors, vector)
+
+	sum := make(map[string]float64)
+	for _, v := range t.vectors {
+		for token, weight := range v {
+			sum[token] += weight
+		}
+	}
+	t.centroid = normalizeVector(sum)
+}
+
+// getRepresentative returns the review closest to the centroid of the theme
+func (t *theme) getRepresentative() *Review {
+	best := 0
+	bestSimilarity := -1.0
+	for i, vector := range t.vectors {
+		if similarity := cosineSimilarity(vector, t.centroid); similarity > bestSimilarity {
+			best = i
+			bestSimilarity = similarity
+		}
+	}
+	return t.reviews[best]
+}
+
+// getVersions returns the app versions of the reviews of the theme, newest first
+func (t *theme) getVersions() []string {
+	codes := []int64{}
+	names := make(map[int64]string)
+	for _, review := range t.reviews {
+		if _, ok := names[review.AppVersionCode]; !ok {
+			codes = append(codes, review.AppVersionCode)
+		}
+		if names[review.AppVersionCode] == "" {
+			names[review.AppVersionCode] = review.AppVersionName
+		}
+	}
+	sort.Slice(codes, func(i, j int) bool { return codes[i] > codes[j] })
+
+	versions := []string{}
+	for _, code := range codes {
+		switch {
+		case names[code] != "":
+			versions = append(versions, names[code])
+		case code != 0:
+			versions = append(versions, strconv.FormatInt(code, 10))
+		default:
+			versions = append(versions, "unknown")
+		}
+	}
+	return versions
+}
+
+// clusterReviews groups the reviews by text similarity, adding each one to the most similar theme or starting a new one.
+// Themes are sorted by size, and reviews without any meaningful word are left out.
+func clusterReviews(reviews []*Review) []*theme {
+	texts := []string{}
+	for _, review := range reviews {
+		texts = append(texts, review.Text)
+	}
+	vectors := getTFIDFVectors(texts)
+
+	themes := []*theme{}
+	for i, review := range reviews {
+		if len(vectors[i]) == 0 {
+			continue
+		}
+		var closest *theme
+		closestSimilarity := themeSimilarity
+		for _, t := range themes {
+			if similarity := cosineSimilarity(vectors[i], t.centroid); similarity >= closestSimilarity {
+				closest = t
+				closestSimilarity = similarity
+			}
+		}
+		if closest == nil {
+			closest = &theme{}
+			themes = append(themes, closest)
+		}
+		closest.add(review, vectors[i])
+	}
+
+	sort.SliceStable(themes, func(i, j int) bool {
+		return len(themes[i].reviews) > len(themes[j].reviews)
+	})
+	return themes
+}
+
+// themeSummary is a theme as the report shows it: a title and the review that best represents it
+type themeSummary struct {
+	title          string
+	representative *Review
+}
+
+// getThemesReport clusters the 1-2 star reviews written since the given time, returning the header of the report and
+// the biggest themes
+func getThemesReport(packageName string, reviews []*Review, since time.Time) (string, []*themeSummary) {
+	lowRated := []*Review{}
+	for _, review := range reviews {
+		if review.isLowRated() && !review.LastModified.Before(since) {
+			lowRated = append(lowRated, review)
+		}
+	}
+	if len(lowRated) == 0 {
+		return fmt.Sprintf("There are no 1-2 star reviews of **%s** since %s.", packageName, since.Format("2006-01-02 15:04")), nil
+	}
+
+	themes := []*theme{}
+	for _, t := range clusterReviews(lowRated) {
+		if len(t.reviews) > 1 {
+			themes = append(themes, t)
+		}
+	}
+
+	text := fmt.Sprintf("## Themes of the **%d** 1-2 star reviews of **%s** since %s:\n", len(lowRated), packageName, since.Format("2006-01-02 15:04"))
+	if len(themes) == 0 {
+		text += "No reviews are similar enough to be grouped."
+		return text, nil
+	}
+	if len(themes) > maxThemes {
+		text += fmt.Sprintf("Showing the **%d** biggest of **%d** themes.", maxThemes, len(themes))
+	}
+
+	summaries := []*themeSummary{}
+	for i, t := range themes[:min(len(themes), maxThemes)] {
+		texts := []string{}
+		for _, review := range t.reviews {
+			texts = append(texts, review.Text)
+		}
+		label := strings.Join(getTopTerms(texts, maxThemeTerms), ", ")
+		if label == "" {
+			label = "Similar reviews"
+		}
+		title := fmt.Sprintf("### %d. %s (**%d** reviews)\n", i+1, label, len(t.reviews))
+		title += fmt.Sprintf("Versions: **%s**", strings.Join(t.getVersions(), "**, **"))
+		summaries = append(summaries, &themeSummary{title: title, representative: t.getRepresentative()})
+	}
+	return text, summaries
+}
+
+// parseSince reads how far back to look, as days like "7d" or "7", or hours like "48h"
+func parseSince(since string) (time.Duration, bool) {
+	unit := 24 * time.Hour
+	if strings.HasSuffix(since, "h") {
+		unit = time.Hour
+		since = strings.TrimSuffix(since, "h")
+	} else {
+		since = strings.TrimSuffix(since, "d")
+	}
+	amount, err := strconv.ParseInt(since, 10, 64)
+	if err != nil || amount <= 0 {
+		return 0, false
+	}
+	return time.Duration(amount) * unit, true
+}
+
+func (p *Plugin) serveThemes(args []string, userID string) (*model.CommandResponse, *model.AppError) {
+	var message string
+
+	if (len(args) != 3 && len(args) != 5) || (len(args) == 5 && args[3] != "--since") {
+		message += fmt.Sprintf(":x:Wrong use: `%s %s packageName_or_alias [--since days]`", args[0], args[1])
+		return commandErrorResponse(message)
+	}
+
+	packageName, ok := p.state.resolvePackageName(args[2], userID)
+	if !ok {
+		message += fmt.Sprintf(":x:Package **%s** is not yet registered.", args[2])
+		return commandErrorResponse(message)
+	}
+
+	period := defaultThemesSinceDays * 24 * time.Hour
+	if len(args) == 5 {
+		if period, ok = parseSince(args[4]); !ok {
+			message += fmt.Sprintf(":x:**%s** is not a well formed period. Please use days like `7d` or hours like `48h`.", args[4])
+			return commandErrorResponse(message)
+		}
+	}
+
+	p.control.reviewsMutex.RLock()
+	defer p.control.reviewsMutex.RUnlock()
+
+	text, summaries := getThemesReport(packageName, p.localReviews[userID][packageName], time.Now().Add(-period))
+	message += text
+
+	representatives := []*Review{}
+	for _, summary := range summaries {
+		representatives = append(representatives, summary.representative)
+	}
+	cards := p.getReviewCards(userID, packageName, representatives)
+	for i, summary := range summaries {
+		cards[i].Pretext = summary.title
+	}
+	return commandCardsResponse(message, cards)
+}
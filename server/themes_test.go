@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClusterReviews(t *testing.T) {
+	reviews := []*Review{
+		newTestReview("1", 1, "The app crashes when I open the camera"),
+		newTestReview("2", 2, "Camera crashes every time, please fix"),
+		newTestReview("3", 3, "Opening the camera crashes the whole app"),
+		newTestReview("4", 4, "Too expensive subscription for what it offers"),
+		newTestReview("5", 5, "The subscription price is too expensive"),
+		newTestReview("6", 6, "!!!"),
+	}
+	reviews[0].AppVersionName = "1.0"
+	reviews[0].AppVersionCode = 10
+	reviews[1].AppVersionName = "1.1"
+	reviews[1].AppVersionCode = 11
+
+	themes := clusterReviews(reviews)
+	require.Len(t, themes, 2)
+	assert.Equal(t, []*Review{reviews[0], reviews[1], reviews[2]}, themes[0].reviews)
+	assert.Equal(t, []*Review{reviews[3], reviews[4]}, themes[1].reviews)
+	assert.Equal(t, []string{"1.1", "1.0", "unknown"}, themes[0].getVersions())
+}
+
+func TestGetThemesReport(t *testing.T) {
+	reviews := []*Review{
+		newTestReview("old", 1, "Login fails with my password"),
+		newTestReview("new", 1000, "Login fails with my password"),
+		newTestReview("newer", 2000, "Login fails again, wrong password"),
+	}
+	high := newTestReview("high", 3000, "Login fails with my password")
+	high.Rating = 5
+	unrated := newTestReview("unrated", 3000, "Login fails with my password")
+	unrated.Rating = 0
+	reviews = append(reviews, high, unrated)
+
+	report, summaries := getThemesReport("com.example", reviews, time.Unix(500, 0))
+	assert.True(t, strings.Contains(report, "**2** 1-2 star reviews"))
+	require.Len(t, summaries, 1)
+	assert.True(t, strings.Contains(summaries[0].title, "(**2** reviews)"))
+	assert.NotNil(t, summaries[0].representative)
+
+	report, summaries = getThemesReport("com.example", reviews, time.Unix(5000, 0))
+	assert.True(t, strings.HasPrefix(report, "There are no 1-2 star reviews"))
+	assert.Empty(t, summaries)
+}
+
+func TestParseSince(t *testing.T) {
+	for since, expected := range map[string]time.Duration{"7": 7 * 24 * time.Hour, "7d": 7 * 24 * time.Hour, "48h": 48 * time.Hour} {
+		period, ok := parseSince(since)
+		assert.True(t, ok, since)
+		assert.Equal(t, expected, period, since)
+	}
+	for _, since := range []string{"", "0", "-1d", "1w", "h"} {
+		_, ok := parseSince(since)
+		assert.False(t, ok, since)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+// noTranslation turns off the translation of an app
+const noTranslation = "none"
+
+var languageRegexp = regexp.MustCompile(`^[a-zA-Z]{2,3}([_-][a-zA-Z0-9]{2,4})?$`)
+
+func isValidLanguage(language string) bool {
+	return languageRegexp.MatchString(language)
+}
+
+// getPrimaryLanguage returns the lowercase language without region, so "es_ES" and "es-419" are the same language
+func getPrimaryLanguage(language string) string {
+	if i := strings.IndexAny(language, "_-"); i >= 0 {
+		language = language[:i]
+	}
+	return strings.ToLower(language)
+}
+
+func isSameLanguage(a string, b string) bool {
+	if getPrimaryLanguage(a) == "" || getPrimaryLanguage(b) == "" {
+		return false
+	}
+	return getPrimaryLanguage(a) == getPrimaryLanguage(b)
+}
+
+// getTeamLanguage is the language the reviews of the app are shown in: the translation language of the app,
+// or the default language of the server if the app is not translated
+func (p *Plugin) getTeamLanguage(packageInfo PackageInfo) string {
+	if packageInfo.Language != "" {
+		return packageInfo.Language
+	}
+	config := p.API.GetConfig()
+	if config == nil || config.LocalizationSettings.DefaultServerLocale == nil {
+		return ""
+	}
+	return *config.LocalizationSettings.DefaultServerLocale
+}
+
+// translateReview answers the Translate button with the review translated to the app language, or to the language of the user
+func (p *Plugin) translateReview(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-ID")
+	if userID == "" {
+		http.Error(w, "Not authorized", http.StatusUnauthorized)
+		return
+	}
+
+	request := model.PostActionIntegrationRequestFromJson(r.Body)
+	if request == nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if _, appErr := p.API.GetChannelMember(request.ChannelId, userID); appErr != nil {
+		writePostActionResponse(w, "Only members of the channel can translate its reviews.")
+		return
+	}
+
+	ownerID, _ := request.Context["user_id"].(string)
+	packageName, _ := request.Context["package_name"].(string)
+	reviewID, _ := request.Context["review_id"].(string)
+	packageInfo, ok := p.state.getPackage(ownerID, packageName)
+	if !ok {
+		writePostActionResponse(w, "This app is no longer registered.")
+		return
+	}
+
+	language := packageInfo.Language
+	if language == "" {
+		if user, appErr := p.API.GetUser(userID); appErr == nil {
+			language = user.Locale
+		}
+	}
+	if language == "" {
+		writePostActionResponse(w, "Set your language on the Mattermost settings to translate reviews.")
+		return
+	}
+
+	source, err := p.getPackageSource(ownerID, packageName)
+	if err != nil {
+		writePostActionResponse(w, "Error translating the review: "+err.Error())
+		return
+	}
+	review, err := source.GetReview(packageName, reviewID, language)
+	if err != nil {
+		p.logError("Error translating review", "userID", ownerID, "package", packageName, "reviewID", reviewID, "language", language, "err", err.Error())
+		writePostActionResponse(w, "Error translating the review: "+err.Error())
+		return
+	}
+	message := fmt.Sprintf("Review translated to **%s**:", language)
+	if review.OriginalText == "" {
+		message = fmt.Sprintf("The store did not translate the review to **%s**:", language)
+	}
+	card := p.newReviewCard(ownerID, packageName, language, review, true)
+	card.Actions = nil
+	p.sendEphemeralCard(userID, request.ChannelId, message, card)
+	writePostActionResponse(w, "")
+}
+
+func (p *Plugin) setLanguage(args []string, userID string) (*model.CommandResponse, *model.AppError) {
+	var message string
+
+	if len(args) != 5 {
+		message += fmt.Sprintf(":x:Wrong use: `%s %s %s packageName_or_alias language`. Use `%s` as language to stop translating the reviews.", args[0], args[1], args[2], noTranslation)
+		return commandErrorResponse(message)
+	}
+
+	packageName, ok := p.state.resolvePackageName(args[3], userID)
+	if !ok {
+		message += fmt.Sprintf(":x:Package **%s** is not yet registered.", args[3])
+		return commandErrorResponse(message)
+	}
+
+	language := args[4]
+	if language == noTranslation {
+		language = ""
+	} else if !isValidLanguage(language) {
+		message += fmt.Sprintf(":x:**%s** is not a valid language. Please use a language code like `en` or `pt_BR`, or `%s`.", language, noTranslation)
+		return commandErrorResponse(message)
+	}
+
+	p.updateSharedState(p.LoadPackages, p.SavePackages, func() {
+		p.state.setLanguage(userID, packageName, language)
+	})
+
+	if language == "" {
+		message += fmt.Sprintf(":white_check_mark:Reviews of **%s** will no longer be translated.", packageName)
+		return commandStatusResponse(message)
+	}
+	message += fmt.Sprintf(":white_check_mark:Reviews of **%s** will be translated to **%s** from the next sync.", packageName, language)
+	return commandStatusResponse(message)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsSameLanguage(t *testing.T) {
+	assert.True(t, isSameLanguage("es_ES", "es-419"))
+	assert.True(t, isSameLanguage("EN", "en"))
+	assert.False(t, isSameLanguage("pt_BR", "es"))
+	assert.False(t, isSameLanguage("", "en"))
+	assert.False(t, isSameLanguage("_", "en"))
+}
+
+func TestGetReviewCards(t *testing.T) {
+	p := newTestPlugin()
+	config := &model.Config{}
+	config.SetDefaults()
+	config.ServiceSettings.SiteURL = model.NewString("https://mm.example.com")
+	p.API.(*plugintest.API).On("GetConfig").Return(config)
+	p.state.addPackage(PackageInfo{Name: "com.example", UserID: "user", Language: "en"})
+
+	translated := newTestReview("translated", 1, "Good")
+	translated.OriginalText = "Bueno"
+	translated.Language = "es"
+	foreign := newTestReview("foreign", 2, "Gut")
+	foreign.Language = "de_DE"
+	local := newTestReview("local", 3, "Fine")
+	local.Language = "en_GB"
+	unknown := newTestReview("unknown", 4, "?")
+
+	cards := p.getReviewCards("user", "com.example", []*Review{translated, foreign, local, unknown})
+	require.Len(t, cards, 4)
+	assert.Equal(t, "Good", cards[0].Text)
+	assert.Equal(t, &model.SlackAttachmentField{Title: "Original text (es)", Value: "Bueno"}, cards[0].Fields[4])
+	assert.Empty(t, cards[0].Actions)
+	require.Len(t, cards[1].Actions, 1)
+	assert.Equal(t, "Translate", cards[1].Actions[0].Name)
+	assert.Equal(t, "https://mm.example.com/plugins/"+manifest.Id+"/reviews/translate", cards[1].Actions[0].Integration.URL)
+	assert.Equal(t, "foreign", cards[1].Actions[0].Integration.Context["review_id"])
+	assert.Empty(t, cards[2].Actions)
+	assert.Empty(t, cards[3].Actions)
+}
+
+func TestTranslateReviewUsesRequestUser(t *testing.T) {
+	p := newTestPlugin()
+	api := p.API.(*plugintest.API)
+	api.On("GetChannelMember", "channel", "member").Return(&model.ChannelMember{}, nil)
+	api.On("GetChannelMember", "channel", "outsider").Return(nil, &model.AppError{Message: "not a member"})
+
+	request := &model.PostActionIntegrationRequest{UserId: "member", ChannelId: "channel", Context: map[string]interface{}{"user_id": "user", "package_name": "com.example", "review_id": "review"}}
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/reviews/translate", bytes.NewReader(request.ToJson()))
+	r.Header.Set("Mattermost-User-ID", "outsider")
+	p.translateReview(w, r)
+	response := model.PostActionIntegrationResponseFromJson(w.Body)
+	require.NotNil(t, response)
+	assert.Equal(t, "Only members of the channel can translate its reviews.", response.EphemeralText)
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodPost, "/reviews/translate", bytes.NewReader(request.ToJson()))
+	p.translateReview(w, r)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
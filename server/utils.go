@@ -1,14 +1,15 @@
 package main
 
 import (
-	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
-	"crypto/rand"
 	"encoding/base64"
 	"errors"
-	"io"
+	"fmt"
 	"reflect"
+	"strconv"
+	"strings"
+	"time"
 
 	"google.golang.org/api/androidpublisher/v3"
 )
@@ -41,8 +42,8 @@ func getAliasesForPackage(packageName string, aliases map[string]string) []strin
 	return result
 }
 
-func removeElement(list []*androidpublisher.Review, index int) []*androidpublisher.Review {
-	var newList []*androidpublisher.Review
+func removeElement(list []*Review, index int) []*Review {
+	var newList []*Review
 	if index == len(list)-1 {
 		newList = list[:index]
 	} else {
@@ -58,32 +59,52 @@ func min(a, b int) int {
 	return a
 }
 
-func isField(fieldName string, s interface{}) bool {
-	valueS := reflect.ValueOf(s)
-	field := valueS.FieldByName(fieldName)
-	return field.IsValid()
+func timestampToTime(timestamp *androidpublisher.Timestamp) time.Time {
+	if timestamp == nil {
+		return time.Time{}
+	}
+	return time.Unix(timestamp.Seconds, timestamp.Nanos)
 }
 
-func encrypt(key []byte, text string) (string, error) {
-	block, err := aes.NewCipher(key)
+func inStarRange(review *Review, minStars int64, maxStars int64) bool {
+	starRating := review.Rating
+	return starRating >= minStars && starRating <= maxStars
+}
+
+// parseStarRange parses star ratings given as a single value ("2") or as a range ("1-2")
+func parseStarRange(arg string) (minStars int64, maxStars int64, ok bool) {
+	bounds := strings.SplitN(arg, "-", 2)
+	minStars, err := strconv.ParseInt(bounds[0], 10, 64)
 	if err != nil {
-		return "", err
+		return 0, 0, false
+	}
+	maxStars = minStars
+	if len(bounds) == 2 {
+		if maxStars, err = strconv.ParseInt(bounds[1], 10, 64); err != nil {
+			return 0, 0, false
+		}
+	}
+	if minStars < 1 || maxStars > 5 || minStars > maxStars {
+		return 0, 0, false
 	}
+	return minStars, maxStars, true
+}
 
-	msg := pad([]byte(text))
-	ciphertext := make([]byte, aes.BlockSize+len(msg))
-	iv := ciphertext[:aes.BlockSize]
-	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
-		return "", err
+func formatStarRange(minStars int64, maxStars int64) string {
+	if minStars == maxStars {
+		return fmt.Sprintf("%d star", minStars)
 	}
+	return fmt.Sprintf("%d-%d star", minStars, maxStars)
+}
 
-	cfb := cipher.NewCFBEncrypter(block, iv)
-	cfb.XORKeyStream(ciphertext[aes.BlockSize:], msg)
-	finalMsg := base64.URLEncoding.EncodeToString(ciphertext)
-	return finalMsg, nil
+func isField(fieldName string, s interface{}) bool {
+	valueS := reflect.ValueOf(s)
+	field := valueS.FieldByName(fieldName)
+	return field.IsValid()
 }
 
-func decrypt(key []byte, text string) (string, error) {
+// decryptCFB decrypts the values stored before the authenticated encryption. They are upgraded when read.
+func decryptCFB(key []byte, text string) (string, error) {
 	block, err := aes.NewCipher(key)
 	if err != nil {
 		return "", err
@@ -94,7 +115,7 @@ func decrypt(key []byte, text string) (string, error) {
 		return "", err
 	}
 
-	if (len(decodedMsg) % aes.BlockSize) != 0 {
+	if (len(decodedMsg)%aes.BlockSize) != 0 || len(decodedMsg) < 2*aes.BlockSize {
 		return "", errors.New("blocksize must be multipe of decoded message length")
 	}
 
@@ -112,19 +133,18 @@ func decrypt(key []byte, text string) (string, error) {
 	return string(unpadMsg), nil
 }
 
-func pad(src []byte) []byte {
-	padding := aes.BlockSize - len(src)%aes.BlockSize
-	padtext := bytes.Repeat([]byte{byte(padding)}, padding)
-	return append(src, padtext...)
-}
-
 func unpad(src []byte) ([]byte, error) {
 	length := len(src)
 	unpadding := int(src[length-1])
 
-	if unpadding > length {
+	if unpadding == 0 || unpadding > aes.BlockSize || unpadding > length {
 		return nil, errors.New("unpad error. This could happen when incorrect encryption key is used")
 	}
+	for _, b := range src[length-unpadding:] {
+		if int(b) != unpadding {
+			return nil, errors.New("unpad error. This could happen when incorrect encryption key is used")
+		}
+	}
 
 	return src[:(length - unpadding)], nil
 }
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	maxCompareTerms   = 5
+	maxCompareReviews = 3
+	maxCompareLength  = 80
+)
+
+// NewVersionAlert declares an alert for the first reviews arriving from an app version never seen before
+type NewVersionAlert = struct {
+	Alert
+	newVersions []*Review
+}
+
+// detectNewVersions records the version codes of the reviews, and returns the first review of each version never seen before.
+// The first time a package is checked, all the cached versions are recorded without reporting them.
+func (p *Plugin) detectNewVersions(userID string, packageName string, reviews []*Review) []*Review {
+	detected := []*Review{}
+	shouldSave := false
+	p.state.editVersions(userID, packageName, func(known map[int64]string, found bool) {
+		if !found {
+			for _, review := range p.localReviews[userID][packageName] {
+				known[review.AppVersionCode] = review.AppVersionName
+			}
+			shouldSave = true
+			return
+		}
+
+		for _, review := range reviews {
+			if _, ok := known[review.AppVersionCode]; ok {
+				continue
+			}
+			known[review.AppVersionCode] = review.AppVersionName
+			detected = append(detected, review)
+			shouldSave = true
+		}
+	})
+
+	if shouldSave {
+		p.SaveVersions()
+	}
+	return detected
+}
+
+func (p *Plugin) alertNewVersions() {
+	for userID, alerts := range p.state.getAlerts().NewVersionAlerts {
+		for k, v := range alerts {
+			go p.sendNewVersionAlert(k, v, userID)
+		}
+	}
+}
+
+func (p *Plugin) sendNewVersionAlert(name string, alert *NewVersionAlert, userID string) {
+	p.control.reviewsMutex.Lock()
+	if alert.lastAlerted.Unix()+alert.Frequency > time.Now().Unix() || len(alert.newVersions) == 0 {
+		p.control.reviewsMutex.Unlock()
+		return
+	}
+
+	shown := alert.newVersions
+	alert.newVersions = []*Review{}
+	p.control.reviewsMutex.Unlock()
+
+	attachments := p.getReviewCards(userID, alert.PackageName, shown)
+	for i, review := range shown {
+		attachments[i].Pretext = fmt.Sprintf("## New version detected on package **%s**: **%s** (code **%d**)\nFirst review received:", alert.PackageName, review.AppVersionName, review.AppVersionCode)
+	}
+	if err := p.sendWebhookPost("newVersion", alert.Webhook, "", attachments); err != nil {
+		p.logError("Error sending new version alert", "userID", userID, "package", alert.PackageName, "alert", name, "err", err.Error())
+		p.recordAlertDelivery("newVersion", err)
+		return
+	}
+	p.logDebug("New version alert sent", "userID", userID, "package", alert.PackageName, "alert", name)
+	p.recordAlertDelivery("newVersion", nil)
+	p.setLastAlerted(&alert.Alert)
+}
+
+// isReviewFromVersion checks the version by code or by name
+func isReviewFromVersion(review *Review, version string) bool {
+	return strconv.FormatInt(review.AppVersionCode, 10) == version || review.AppVersionName == version
+}
+
+type versionSummary struct {
+	count        int
+	averageStars float64
+	distribution [6]int
+	topTerms     []string
+	worst        []*Review
+}
+
+func getVersionSummary(reviews []*Review, version string) versionSummary {
+	summary := versionSummary{}
+	texts := []string{}
+	versionReviews := []*Review{}
+	var stars int64
+	for _, review := range reviews {
+		if !isReviewFromVersion(review, version) {
+			continue
+		}
+		summary.count++
+		summary.distribution[review.getRating()]++
+		stars += review.Rating
+		texts = append(texts, review.Text)
+		versionReviews = append(versionReviews, review)
+	}
+
+	if summary.count > 0 {
+		summary.averageStars = float64(stars) / float64(summary.count)
+	}
+	summary.topTerms = getTopTerms(texts, maxCompareTerms)
+
+	sort.SliceStable(versionReviews, func(i, j int) bool {
+		return versionReviews[i].Rating < versionReviews[j].Rating
+	})
+	summary.worst = versionReviews[:min(len(versionReviews), maxCompareReviews)]
+	return summary
+}
+
+func formatWorstReview(summary versionSummary, index int) string {
+	if index >= len(summary.worst) {
+		return ""
+	}
+	review := summary.worst[index]
+	text := strings.Replace(strings.Join(strings.Fields(review.Text), " "), "|", "\\|", -1)
+	if runes := []rune(text); len(runes) > maxCompareLength {
+		text = string(runes[:maxCompareLength]) + "..."
+	}
+	return fmt.Sprintf("%d:star: %s (**%s**)", review.Rating, text, review.ID)
+}
+
+// compareVersions builds a table comparing the reviews of two versions of an app
+func compareVersions(packageName string, reviews []*Review, versionA string, versionB string) string {
+	a := getVersionSummary(reviews, versionA)
+	b := getVersionSummary(reviews, versionB)
+
+	text := fmt.Sprintf("## Comparing versions **%s** and **%s** of **%s**:\n", versionA, versionB, packageName)
+	text += fmt.Sprintf("| | %s | %s |\n|:--|:--|:--|\n", versionA, versionB)
+	text += fmt.Sprintf("| Reviews | %d | %d |\n", a.count, b.count)
+	text += fmt.Sprintf("| Average stars | %.2f | %.2f |\n", a.averageStars, b.averageStars)
+	for stars := 5; stars >= 1; stars-- {
+		text += fmt.Sprintf("| %d stars | %s | %s |\n", stars, formatShare(a.distribution[stars], a.count), formatShare(b.distribution[stars], b.count))
+	}
+	text += fmt.Sprintf("| Top terms | %s | %s |\n", strings.Join(a.topTerms, ", "), strings.Join(b.topTerms, ", "))
+	for i := 0; i < maxCompareReviews; i++ {
+		text += fmt.Sprintf("| Worst review %d | %s | %s |\n", i+1, formatWorstReview(a, i), formatWorstReview(b, i))
+	}
+	return text
+}
+
+func formatShare(count int, total int) string {
+	if total == 0 {
+		return "0"
+	}
+	return fmt.Sprintf("%d (%d%%)", count, count*100/total)
+}